@@ -83,6 +83,7 @@ func main() {
 	value := flag.String("value", "100", "Value to send to device")
 	device := flag.String("device", "", "Device to send command to")
 	duration := flag.Int("duration", 0, "Duration of the dimming curve (seconds)")
+	easing := flag.String("easing", "", "Easing of the dimming curve (linear, ease-in, ease-out, sine, sunrise)")
 	list := flag.Bool("list", false, "List devices and their status")
 	version := flag.Bool("version", false, "Print version")
 	flag.Parse()
@@ -135,6 +136,7 @@ func main() {
 		Key:      *key,
 		Value:    *value,
 		Duration: *duration,
+		Easing:   *easing,
 	}
 	jsonRequest, _ := json.Marshal(request)
 
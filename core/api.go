@@ -7,5 +7,6 @@ type SwitchRequest struct {
 	Key      string `json:"key"`
 	Value    string `json:"value"`
 	Duration int    `json:"duration"`
+	Easing   string `json:"easing,omitempty"`
 	Force    bool
 }
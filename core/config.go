@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -57,6 +58,15 @@ func LoadConfig() (*ServerConfig, error) {
 	}
 	config.Filename = filename
 	config.RulesFilename = rulesFile
+	if config.StateFile == "" {
+		config.StateFile = filepath.Join(filepath.Dir(filename), "state.json")
+	}
+	if config.HistoryFile == "" {
+		config.HistoryFile = filepath.Join(filepath.Dir(filename), "history.db")
+	}
+	if config.MqttBaseTopic == nil {
+		config.MqttBaseTopic = ToPtr("dimmy")
+	}
 
 	return &config, nil
 }
@@ -96,27 +106,13 @@ func SaveRulesConfig(filename string, rules []RuleConfig) error {
 }
 
 func AddDeviceToConfig(filename string, device DeviceConfig) error {
-	configYaml, err := os.ReadFile(filename)
+	document, err := readConfigDocument(filename)
 	if err != nil {
 		return err
 	}
 
-	var document yaml.Node
-	if err := yaml.Unmarshal(configYaml, &document); err != nil {
-		return err
-	}
-	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
-		return errors.New("config file must contain a YAML mapping")
-	}
-
 	root := document.Content[0]
-	var devices *yaml.Node
-	for i := 0; i+1 < len(root.Content); i += 2 {
-		if root.Content[i].Value == "devices" {
-			devices = root.Content[i+1]
-			break
-		}
-	}
+	devices := mappingValue(root, "devices")
 	if devices == nil {
 		root.Content = append(root.Content,
 			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "devices"},
@@ -134,6 +130,129 @@ func AddDeviceToConfig(filename string, device DeviceConfig) error {
 	}
 	devices.Content = append(devices.Content, &encoded)
 
+	return writeConfigDocument(filename, document)
+}
+
+// SaveDeviceCommand adds or replaces a named command in the options of a configured device,
+// leaving the rest of the config file untouched.
+func SaveDeviceCommand(filename string, deviceName string, commandName string, command string) error {
+	document, err := readConfigDocument(filename)
+	if err != nil {
+		return err
+	}
+
+	devices := mappingValue(document.Content[0], "devices")
+	if devices == nil || devices.Kind != yaml.SequenceNode {
+		return errors.New("config file has no devices")
+	}
+	var device *yaml.Node
+	for _, candidate := range devices.Content {
+		if name := mappingValue(candidate, "name"); name != nil && name.Value == deviceName {
+			device = candidate
+			break
+		}
+	}
+	if device == nil {
+		return fmt.Errorf("device %s not found in config", deviceName)
+	}
+
+	options := mappingValue(device, "options")
+	if options == nil {
+		options = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(device, "options", options)
+	}
+	commands := mappingValue(options, "commands")
+	if commands == nil {
+		commands = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMappingValue(options, "commands", commands)
+	}
+	setMappingValue(commands, commandName, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: command})
+
+	return writeConfigDocument(filename, document)
+}
+
+// RenameDeviceTopic points devices at topic "from" (or below it) and their availability topics to "to" after
+// a device was renamed, and returns how many topics were changed
+func RenameDeviceTopic(filename string, from string, to string) (int, error) {
+	document, err := readConfigDocument(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	devices := mappingValue(document.Content[0], "devices")
+	if devices == nil || devices.Kind != yaml.SequenceNode {
+		return 0, nil
+	}
+	rename := func(node *yaml.Node) int {
+		if node == nil || node.Kind != yaml.ScalarNode {
+			return 0
+		}
+		if node.Value == from {
+			node.Value = to
+			return 1
+		}
+		if strings.HasPrefix(node.Value, from+"/") {
+			node.Value = to + strings.TrimPrefix(node.Value, from)
+			return 1
+		}
+		return 0
+	}
+	changed := 0
+	for _, device := range devices.Content {
+		changed += rename(mappingValue(device, "topic"))
+		if options := mappingValue(device, "options"); options != nil {
+			changed += rename(mappingValue(options, "availability_topic"))
+			changed += rename(mappingValue(options, "state_topic"))
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	return changed, writeConfigDocument(filename, document)
+}
+
+func readConfigDocument(filename string) (*yaml.Node, error) {
+	configYaml, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(configYaml, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("config file must contain a YAML mapping")
+	}
+	return &document, nil
+}
+
+// mappingValue returns the value node for key, or nil if node is not a mapping or has no such key
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// writeConfigDocument replaces the config file with document while retaining the file's permissions
+func writeConfigDocument(filename string, document *yaml.Node) error {
 	info, err := os.Stat(filename)
 	if err != nil {
 		return err
@@ -147,7 +266,7 @@ func AddDeviceToConfig(filename string, device DeviceConfig) error {
 
 	encoder := yaml.NewEncoder(temp)
 	encoder.SetIndent(2)
-	if err := encoder.Encode(&document); err != nil {
+	if err := encoder.Encode(document); err != nil {
 		temp.Close()
 		return err
 	}
@@ -43,3 +43,40 @@ func TestAddDeviceToConfig(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, os.FileMode(0o640), info.Mode().Perm())
 }
+
+func TestSaveDeviceCommand(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dimmyd.conf.yaml")
+	err := os.WriteFile(filename, []byte("mqtt_server: localhost # keep this comment\ndevices:\n  - name: tv\n    type: ircontrol\n    topic: zigbee/ir\n    options:\n      commands:\n        power: CODE_POWER\n  - name: other\n    type: ircontrol\n    topic: zigbee/ir2\n"), 0o640)
+	require.NoError(t, err)
+
+	require.NoError(t, SaveDeviceCommand(filename, "tv", "mute", "CODE_MUTE"))
+	require.NoError(t, SaveDeviceCommand(filename, "other", "power", "CODE_OTHER"))
+	require.Error(t, SaveDeviceCommand(filename, "missing", "power", "CODE"))
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "# keep this comment")
+
+	var config ServerConfig
+	require.NoError(t, yaml.Unmarshal(data, &config))
+	require.Equal(t, map[string]string{"power": "CODE_POWER", "mute": "CODE_MUTE"}, *config.Devices[0].Options.Commands)
+	require.Equal(t, map[string]string{"power": "CODE_OTHER"}, *config.Devices[1].Options.Commands)
+}
+
+func TestRenameDeviceTopic(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dimmyd.conf.yaml")
+	err := os.WriteFile(filename, []byte("devices:\n  - name: bulb\n    type: zlight\n    topic: zigbee2mqtt/Kitchen Bulb/set\n    options:\n      availability_topic: zigbee2mqtt/Kitchen Bulb/availability\n  - name: other\n    type: zlight\n    topic: zigbee2mqtt/Kitchen Bulb 2/set\n"), 0o640)
+	require.NoError(t, err)
+
+	changed, err := RenameDeviceTopic(filename, "zigbee2mqtt/Kitchen Bulb", "zigbee2mqtt/Pantry Bulb")
+	require.NoError(t, err)
+	require.Equal(t, 2, changed)
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	var config ServerConfig
+	require.NoError(t, yaml.Unmarshal(data, &config))
+	require.Equal(t, "zigbee2mqtt/Pantry Bulb/set", config.Devices[0].Topic)
+	require.Equal(t, "zigbee2mqtt/Pantry Bulb/availability", *config.Devices[0].Options.AvailabilityTopic)
+	require.Equal(t, "zigbee2mqtt/Kitchen Bulb 2/set", config.Devices[1].Topic)
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExportBatchSize  = 500
+	defaultExportInterval   = 10 // seconds
+	defaultExportBufferSize = 50000
+	defaultExportTimeout    = 10 // seconds
+	maxExportBackoff        = 5 * time.Minute
+)
+
+// LineExporter buffers values as Influx line protocol and writes them in batches. Lines that couldn't be
+// sent are retried with an increasing delay; if the buffer is full, the oldest lines are dropped.
+type LineExporter struct {
+	config      ExportConfig
+	measurement string
+	batchSize   int
+	interval    time.Duration
+	bufferSize  int
+	client      *http.Client
+
+	mutex   sync.Mutex
+	buffer  []string
+	dropped int
+	backoff time.Duration
+	retryAt time.Time
+}
+
+func NewLineExporter(config ExportConfig) *LineExporter {
+	e := &LineExporter{
+		config:      config,
+		measurement: "dimmy",
+		batchSize:   defaultExportBatchSize,
+		interval:    defaultExportInterval * time.Second,
+		bufferSize:  defaultExportBufferSize,
+	}
+	if config.Measurement != "" {
+		e.measurement = config.Measurement
+	}
+	if config.BatchSize != nil && *config.BatchSize > 0 {
+		e.batchSize = *config.BatchSize
+	}
+	if config.Interval != nil && *config.Interval > 0 {
+		e.interval = time.Duration(*config.Interval) * time.Second
+	}
+	if config.BufferSize != nil && *config.BufferSize > 0 {
+		e.bufferSize = *config.BufferSize
+	}
+	timeout := defaultExportTimeout
+	if config.Timeout != nil {
+		timeout = *config.Timeout
+	}
+	e.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	return e
+}
+
+// Add queues a value. Numbers and booleans are written as the float field "value", other values as the string field "state".
+func (e *LineExporter) Add(tags map[string]string, value any, t time.Time) {
+	line := e.line(tags, value, t)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.buffer = append(e.buffer, line)
+	if over := len(e.buffer) - e.bufferSize; over > 0 {
+		e.buffer = append(e.buffer[:0], e.buffer[over:]...)
+		e.dropped += over
+	}
+}
+
+func (e *LineExporter) line(tags map[string]string, value any, t time.Time) string {
+	var line strings.Builder
+	line.WriteString(escapeLineProtocol(e.measurement, ", "))
+	for _, key := range sortedTagKeys(tags) {
+		if tags[key] == "" {
+			continue
+		}
+		line.WriteString("," + escapeLineProtocol(key, ",= ") + "=" + escapeLineProtocol(tags[key], ",= "))
+	}
+	if number, ok := NumericValue(value); ok {
+		line.WriteString(" value=" + strconv.FormatFloat(number, 'g', -1, 64))
+	} else {
+		quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(fmt.Sprintf("%v", value))
+		line.WriteString(` state="` + quoted + `"`)
+	}
+	line.WriteString(" " + strconv.FormatInt(t.UnixNano(), 10))
+	return line.String()
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	// influx prefers tags sorted by key
+	sort.Strings(keys)
+	return keys
+}
+
+func escapeLineProtocol(s string, special string) string {
+	var escaped strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\r':
+			r = ' '
+			fallthrough
+		case strings.ContainsRune(special, r) || r == '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// Run flushes the buffer every interval until stop is closed, then once more
+func (e *LineExporter) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.Flush()
+		case <-stop:
+			e.Flush()
+			return
+		}
+	}
+}
+
+// Flush writes all buffered lines in batches, keeping those that couldn't be sent
+func (e *LineExporter) Flush() {
+	e.mutex.Lock()
+	if e.dropped > 0 {
+		log.Printf("[%32s] Buffer full, dropped %d values\n", "Export", e.dropped)
+		e.dropped = 0
+	}
+	if len(e.buffer) == 0 || time.Now().Before(e.retryAt) {
+		e.mutex.Unlock()
+		return
+	}
+	lines := e.buffer
+	e.buffer = nil
+	e.mutex.Unlock()
+
+	for len(lines) > 0 {
+		batch := lines[:min(e.batchSize, len(lines))]
+		err := e.write(batch)
+		var rejected rejectedError
+		if errors.As(err, &rejected) {
+			// retrying won't help if the server doesn't accept the data
+			log.Printf("[%32s] Dropped %d values: %s\n", "Export", len(batch), err)
+		} else if err != nil {
+			e.requeue(lines)
+			log.Printf("[%32s] Could not export %d values, retrying in %s: %s\n", "Export", len(lines), e.backoff, err)
+			return
+		}
+		lines = lines[len(batch):]
+	}
+	e.mutex.Lock()
+	e.backoff = 0
+	e.mutex.Unlock()
+}
+
+// requeue puts unsent lines back in front of those added during the flush
+func (e *LineExporter) requeue(lines []string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.buffer = append(lines, e.buffer...)
+	if over := len(e.buffer) - e.bufferSize; over > 0 {
+		e.buffer = e.buffer[over:]
+		e.dropped += over
+	}
+	if e.backoff == 0 {
+		e.backoff = e.interval
+	} else {
+		e.backoff = min(2*e.backoff, maxExportBackoff)
+	}
+	e.retryAt = time.Now().Add(e.backoff)
+}
+
+func (e *LineExporter) write(lines []string) error {
+	payload := strings.Join(lines, "\n") + "\n"
+	if e.config.URL == "" {
+		file, err := os.OpenFile(e.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = file.WriteString(payload)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, e.config.URL, bytes.NewBufferString(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.config.Token != "" {
+		request.Header.Set("Authorization", "Token "+e.config.Token)
+	} else if e.config.Username != "" {
+		request.SetBasicAuth(e.config.Username, e.config.Password)
+	}
+	response, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	err = errors.New(response.Status + ": " + strings.TrimSpace(string(body)))
+	switch {
+	case response.StatusCode < 300:
+		return nil
+	case response.StatusCode >= 400 && response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusRequestTimeout:
+		return rejectedError{err}
+	}
+	return err
+}
+
+type rejectedError struct {
+	error
+}
+
+// Buffered returns the number of lines waiting to be written
+func (e *LineExporter) Buffered() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.buffer)
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineExporter_Line(t *testing.T) {
+	e := NewLineExporter(ExportConfig{})
+	at := time.Unix(1700000000, 5)
+	assert.Equal(t, `dimmy,device=Living\ Room,key=temperature,type=sensor value=21.5 1700000000000000005`,
+		e.line(map[string]string{"type": "sensor", "device": "Living Room", "key": "temperature"}, 21.5, at))
+	assert.Equal(t, `dimmy,device=a\,b\=c,key=occupancy value=1 1700000000000000005`,
+		e.line(map[string]string{"device": "a,b=c", "key": "occupancy"}, true, at))
+	assert.Equal(t, `dimmy,device=Remote,key=action state="say \"hi\"" 1700000000000000005`,
+		e.line(map[string]string{"device": "Remote", "key": "action", "type": ""}, `say "hi"`, at))
+}
+
+func TestLineExporter_BatchesAndRetries(t *testing.T) {
+	var bodies []string
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	e := NewLineExporter(ExportConfig{URL: server.URL, Token: "secret", BatchSize: ToPtr(2), BufferSize: ToPtr(3)})
+	for i := 0; i < 4; i++ {
+		e.Add(map[string]string{"device": "Lamp", "key": "value"}, i, time.Unix(int64(i), 0))
+	}
+	assert.Equal(t, 3, e.Buffered(), "the oldest value is dropped")
+
+	e.Flush()
+	assert.Len(t, bodies, 1)
+	assert.Equal(t, 3, e.Buffered(), "unsent values are kept")
+	e.Flush()
+	assert.Len(t, bodies, 1, "no retry before the backoff")
+
+	status = http.StatusNoContent
+	e.retryAt = time.Time{}
+	e.Flush()
+	assert.Len(t, bodies, 3)
+	assert.Equal(t, "dimmy,device=Lamp,key=value value=1 1000000000\ndimmy,device=Lamp,key=value value=2 2000000000\n", bodies[1])
+	assert.Equal(t, 0, e.Buffered())
+	assert.Zero(t, e.backoff)
+
+	status = http.StatusBadRequest
+	e.Add(map[string]string{"device": "Lamp"}, 1, time.Now())
+	e.Flush()
+	assert.Equal(t, 0, e.Buffered(), "rejected values are not retried")
+}
+
+func TestLineExporter_File(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.lp")
+	e := NewLineExporter(ExportConfig{File: filename, Measurement: "home"})
+	e.Add(map[string]string{"device": "Plug"}, "ON", time.Unix(1, 0))
+	e.Flush()
+	e.Add(map[string]string{"device": "Plug"}, "OFF", time.Unix(2, 0))
+	e.Flush()
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "home,device=Plug value=1 1000000000\nhome,device=Plug value=0 2000000000\n", string(data))
+}
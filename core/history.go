@@ -0,0 +1,337 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultHistoryRetention = 30 * 24 * time.Hour
+
+var ErrNoHistory = errors.New("no history recorded")
+
+// HistoryConfig is written as `history: false`, `history: true` or a retention such as `history: 90d`
+type HistoryConfig struct {
+	Enabled   bool
+	Retention time.Duration
+}
+
+func (h *HistoryConfig) UnmarshalYAML(node *yaml.Node) error {
+	var enabled bool
+	if err := node.Decode(&enabled); err == nil {
+		h.Enabled = enabled
+		return nil
+	}
+	retention, err := ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("history must be true, false or a retention like 90d: %w", err)
+	}
+	h.Enabled = retention > 0
+	h.Retention = retention
+	return nil
+}
+
+func (h HistoryConfig) MarshalYAML() (any, error) {
+	if !h.Enabled || h.Retention == 0 {
+		return h.Enabled, nil
+	}
+	if h.Retention%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", h.Retention/(24*time.Hour)), nil
+	}
+	return h.Retention.String(), nil
+}
+
+// ParseDuration extends time.ParseDuration by days (d), weeks (w) and years (y) as well as plain seconds
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour, "y": 365 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if number, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// historyTiers are the resolutions values are kept at. Each tier keeps its buckets for keep,
+// the coarsest one for the retention of the series.
+var historyTiers = []struct {
+	resolution time.Duration
+	keep       time.Duration
+}{
+	{time.Minute, 24 * time.Hour},
+	{10 * time.Minute, 7 * 24 * time.Hour},
+	{time.Hour, 0},
+}
+
+// HistoryBucket aggregates all values recorded within one resolution step
+type HistoryBucket struct {
+	Start int64 // unix seconds
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+	Last  float64
+}
+
+func (b *HistoryBucket) add(value float64) {
+	if b.Count == 0 || value < b.Min {
+		b.Min = value
+	}
+	if b.Count == 0 || value > b.Max {
+		b.Max = value
+	}
+	b.Count++
+	b.Sum += value
+	b.Last = value
+}
+
+func (b *HistoryBucket) merge(other HistoryBucket) {
+	if other.Count == 0 {
+		return
+	}
+	if b.Count == 0 || other.Min < b.Min {
+		b.Min = other.Min
+	}
+	if b.Count == 0 || other.Max > b.Max {
+		b.Max = other.Max
+	}
+	b.Count += other.Count
+	b.Sum += other.Sum
+	b.Last = other.Last
+}
+
+type historySeries struct {
+	Retention time.Duration
+	Tiers     [][]HistoryBucket
+}
+
+// HistoryPoint is one step of a queried series
+type HistoryPoint struct {
+	Time  time.Time `json:"time"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Last  float64   `json:"last"`
+	Count int       `json:"count"`
+}
+
+// HistoryStore keeps downsampled numeric values per device and key
+type HistoryStore struct {
+	mutex  sync.RWMutex
+	series map[string]map[string]*historySeries
+	dirty  bool
+}
+
+func NewHistoryStore() *HistoryStore {
+	return &HistoryStore{series: make(map[string]map[string]*historySeries)}
+}
+
+// Record adds a value at time t. Booleans are stored as 0 and 1, values that aren't numbers are ignored.
+func (h *HistoryStore) Record(device string, key string, value any, t time.Time, retention time.Duration) {
+	number, ok := NumericValue(value)
+	if !ok {
+		return
+	}
+	if retention <= 0 {
+		retention = DefaultHistoryRetention
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.series[device] == nil {
+		h.series[device] = make(map[string]*historySeries)
+	}
+	s := h.series[device][key]
+	if s == nil {
+		s = &historySeries{Tiers: make([][]HistoryBucket, len(historyTiers))}
+		h.series[device][key] = s
+	}
+	s.Retention = retention
+	for i, tier := range historyTiers {
+		start := t.Truncate(tier.resolution).Unix()
+		buckets := s.Tiers[i]
+		if n := len(buckets); n > 0 && buckets[n-1].Start == start {
+			buckets[n-1].add(number)
+		} else if n == 0 || buckets[n-1].Start < start {
+			bucket := HistoryBucket{Start: start}
+			bucket.add(number)
+			s.Tiers[i] = append(buckets, bucket)
+		} else {
+			// older than the latest bucket, e.g. after the clock was set back
+			continue
+		}
+		s.Tiers[i] = prune(s.Tiers[i], t.Add(-s.keep(i)).Unix())
+	}
+	h.dirty = true
+}
+
+func (s *historySeries) keep(tier int) time.Duration {
+	keep := historyTiers[tier].keep
+	if keep == 0 || keep > s.Retention {
+		return s.Retention
+	}
+	return keep
+}
+
+func prune(buckets []HistoryBucket, before int64) []HistoryBucket {
+	i := sort.Search(len(buckets), func(i int) bool { return buckets[i].Start >= before })
+	if i == 0 {
+		return buckets
+	}
+	return append(buckets[:0], buckets[i:]...)
+}
+
+// NumericValue converts sensor values to numbers: booleans and ON/OFF become 1 and 0, numeric strings are parsed
+func NumericValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		switch strings.ToUpper(v) {
+		case "ON", "TRUE":
+			return 1, true
+		case "OFF", "FALSE":
+			return 0, true
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Query aggregates the values between from and to into steps of step. It uses the finest tier that still
+// covers from; a step of 0 or below the tier's resolution returns the tier's buckets.
+func (h *HistoryStore) Query(device string, key string, from time.Time, to time.Time, step time.Duration) ([]HistoryPoint, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	s, ok := h.series[device][key]
+	if !ok {
+		return nil, ErrNoHistory
+	}
+	tier := len(historyTiers) - 1
+	for i := range historyTiers {
+		if time.Since(from) <= s.keep(i) {
+			tier = i
+			break
+		}
+	}
+	resolution := historyTiers[tier].resolution
+	if step < resolution {
+		step = resolution
+	}
+	step = step.Truncate(resolution)
+
+	points := make([]HistoryPoint, 0)
+	var current HistoryBucket
+	for _, bucket := range s.Tiers[tier] {
+		if bucket.Start < from.Truncate(resolution).Unix() || bucket.Start > to.Unix() {
+			continue
+		}
+		start := time.Unix(bucket.Start, 0).Truncate(step).Unix()
+		if current.Count > 0 && current.Start != start {
+			points = append(points, current.point())
+			current = HistoryBucket{}
+		}
+		if current.Count == 0 {
+			current.Start = start
+		}
+		current.merge(bucket)
+	}
+	if current.Count > 0 {
+		points = append(points, current.point())
+	}
+	return points, nil
+}
+
+func (b HistoryBucket) point() HistoryPoint {
+	return HistoryPoint{Time: time.Unix(b.Start, 0), Avg: b.Sum / float64(b.Count), Min: b.Min, Max: b.Max, Last: b.Last, Count: b.Count}
+}
+
+// Keys lists the recorded keys of a device
+func (h *HistoryStore) Keys(device string) []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	keys := make([]string, 0, len(h.series[device]))
+	for key := range h.series[device] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Load replaces the store's content with the file written by Save. A missing file is not an error.
+func (h *HistoryStore) Load(filename string) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	series := make(map[string]map[string]*historySeries)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&series); err != nil {
+		return err
+	}
+	for _, keys := range series {
+		for _, s := range keys {
+			if len(s.Tiers) != len(historyTiers) {
+				s.Tiers = append(s.Tiers, make([][]HistoryBucket, len(historyTiers)-len(s.Tiers))...)
+			}
+		}
+	}
+	h.mutex.Lock()
+	h.series = series
+	h.dirty = false
+	h.mutex.Unlock()
+	return nil
+}
+
+// Save writes the store if anything was recorded since it was last saved or loaded
+func (h *HistoryStore) Save(filename string) error {
+	h.mutex.Lock()
+	if !h.dirty {
+		h.mutex.Unlock()
+		return nil
+	}
+	var data bytes.Buffer
+	err := gob.NewEncoder(&data).Encode(h.series)
+	h.dirty = false
+	h.mutex.Unlock()
+	if err == nil {
+		err = SaveState(filename, data.Bytes())
+	}
+	if err != nil {
+		h.mutex.Lock()
+		h.dirty = true
+		h.mutex.Unlock()
+	}
+	return err
+}
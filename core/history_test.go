@@ -0,0 +1,88 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestHistoryConfig(t *testing.T) {
+	var sensors []Sensor
+	err := yaml.Unmarshal([]byte(`
+- name: a
+  history: false
+- name: b
+  history: true
+- name: c
+  history: 90d
+`), &sensors)
+	assert.NoError(t, err)
+	assert.Equal(t, HistoryConfig{}, *sensors[0].History)
+	assert.Equal(t, HistoryConfig{Enabled: true}, *sensors[1].History)
+	assert.Equal(t, HistoryConfig{Enabled: true, Retention: 90 * 24 * time.Hour}, *sensors[2].History)
+
+	out, err := yaml.Marshal(sensors[2])
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "history: 90d")
+
+	assert.Error(t, yaml.Unmarshal([]byte(`history: soon`), &Sensor{}))
+}
+
+func TestHistoryStore_Query(t *testing.T) {
+	store := NewHistoryStore()
+	start := time.Now().Add(-time.Hour).Truncate(time.Hour)
+	for i := 0; i < 30; i++ {
+		store.Record("Bathroom", "temperature", float64(20+i%3), start.Add(time.Duration(i)*20*time.Second), 0)
+	}
+	store.Record("Bathroom", "temperature", "unavailable", start, 0)
+	store.Record("Plug", "value", true, start, 0)
+
+	points, err := store.Query("Bathroom", "temperature", start, time.Now(), 0)
+	assert.NoError(t, err)
+	assert.Len(t, points, 10, "one point per minute")
+	assert.Equal(t, HistoryPoint{Time: start, Avg: 21, Min: 20, Max: 22, Last: 22, Count: 3}, points[0])
+
+	points, err = store.Query("Bathroom", "temperature", start, time.Now(), 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, points, 2)
+	assert.Equal(t, 15, points[0].Count)
+	assert.Equal(t, 15, points[1].Count)
+
+	points, _ = store.Query("Plug", "value", start, time.Now(), 0)
+	assert.Equal(t, 1.0, points[0].Last)
+
+	_, err = store.Query("Bathroom", "humidity", start, time.Now(), 0)
+	assert.ErrorIs(t, err, ErrNoHistory)
+	assert.Equal(t, []string{"temperature"}, store.Keys("Bathroom"))
+}
+
+func TestHistoryStore_Retention(t *testing.T) {
+	store := NewHistoryStore()
+	now := time.Now()
+	store.Record("Sensor", "humidity", 50, now.Add(-3*24*time.Hour), 2*24*time.Hour)
+	store.Record("Sensor", "humidity", 60, now.Add(-30*time.Hour), 2*24*time.Hour)
+	store.Record("Sensor", "humidity", 70, now, 2*24*time.Hour)
+
+	points, _ := store.Query("Sensor", "humidity", now.Add(-time.Hour), now, 0)
+	assert.Len(t, points, 1, "minute buckets are kept for a day")
+	points, _ = store.Query("Sensor", "humidity", now.Add(-5*24*time.Hour), now, 0)
+	assert.Len(t, points, 2, "values older than the retention are dropped")
+	assert.Equal(t, 60.0, points[0].Avg)
+}
+
+func TestHistoryStore_SaveAndLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.db")
+	store := NewHistoryStore()
+	assert.NoError(t, store.Load(filename))
+	store.Record("Lamp", "value", 0.5, time.Now(), 0)
+	assert.NoError(t, store.Save(filename))
+
+	loaded := NewHistoryStore()
+	assert.NoError(t, loaded.Load(filename))
+	points, err := loaded.Query("Lamp", "value", time.Now().Add(-time.Minute), time.Now(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, points[0].Last)
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricSample is one value of a metric family in the Prometheus text format
+type MetricSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// WriteMetric writes a metric family with its HELP and TYPE lines
+func WriteMetric(w io.Writer, name string, metricType string, help string, samples []MetricSample) {
+	if len(samples) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	_, _ = fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+	sort.SliceStable(samples, func(i, j int) bool { return formatLabels(samples[i].Labels) < formatLabels(samples[j].Labels) })
+	for _, sample := range samples {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(sample.Labels), formatMetricValue(sample.Value))
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + `="` + escape.Replace(labels[name]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// CounterVec counts events per combination of label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+}
+
+// Inc increases the counter of the given label values, which must match the label names in number
+func (c *CounterVec) Inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: labelValues}
+		c.values[key] = v
+	}
+	v.value++
+}
+
+func (c *CounterVec) Get(labelValues ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if v, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return v.value
+	}
+	return 0
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	c.mutex.Lock()
+	samples := make([]MetricSample, 0, len(c.values))
+	for _, v := range c.values {
+		labels := make(map[string]string, len(c.labels))
+		for i, name := range c.labels {
+			labels[name] = v.labels[i]
+		}
+		samples = append(samples, MetricSample{Labels: labels, Value: v.value})
+	}
+	c.mutex.Unlock()
+	if len(samples) == 0 && len(c.labels) == 0 {
+		samples = append(samples, MetricSample{})
+	}
+	WriteMetric(w, c.name, "counter", c.help, samples)
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	name    string
+	help    string
+	bounds  []float64
+	mutex   sync.Mutex
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(name string, help string, bounds ...float64) *Histogram {
+	sort.Float64s(bounds)
+	return &Histogram{name: name, help: help, bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *Histogram) Write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	_, _ = fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+	for i, bound := range h.bounds {
+		_, _ = fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatMetricValue(bound), h.buckets[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	_, _ = fmt.Fprintf(w, "%s_sum %s\n", h.name, formatMetricValue(h.sum))
+	_, _ = fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetric(t *testing.T) {
+	var out strings.Builder
+	WriteMetric(&out, "dimmy_sensor_value", "gauge", "Sensor values.", []MetricSample{
+		{Labels: map[string]string{"device": `Living "Room"`, "sensor": "temperature"}, Value: 21.5},
+		{Labels: map[string]string{"device": "Bathroom", "sensor": "humidity"}, Value: 60},
+	})
+	WriteMetric(&out, "dimmy_unused", "gauge", "Not written without samples.", nil)
+	assert.Equal(t, `# HELP dimmy_sensor_value Sensor values.
+# TYPE dimmy_sensor_value gauge
+dimmy_sensor_value{device="Bathroom",sensor="humidity"} 60
+dimmy_sensor_value{device="Living \"Room\"",sensor="temperature"} 21.5
+`, out.String())
+}
+
+func TestCounterVec(t *testing.T) {
+	received := NewCounterVec("dimmy_mqtt_messages_received_total", "Messages.", "topic")
+	received.Inc("zigbee/lamp")
+	received.Inc("zigbee/lamp")
+	received.Inc("tele/plug/STATE")
+	assert.Equal(t, 2.0, received.Get("zigbee/lamp"))
+
+	var out strings.Builder
+	received.Write(&out)
+	assert.Contains(t, out.String(), `dimmy_mqtt_messages_received_total{topic="tele/plug/STATE"} 1`+"\n")
+	assert.Contains(t, out.String(), `dimmy_mqtt_messages_received_total{topic="zigbee/lamp"} 2`+"\n")
+
+	out.Reset()
+	NewCounterVec("dimmy_rules_fired_total", "Rules.").Write(&out)
+	assert.Contains(t, out.String(), "dimmy_rules_fired_total 0\n", "counters without labels start at 0")
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("dimmy_cycle_seconds", "Cycle duration.", 0.1, 0.01)
+	h.Observe(0.005)
+	h.Observe(0.05)
+	h.Observe(2)
+
+	var out strings.Builder
+	h.Write(&out)
+	assert.Equal(t, `# HELP dimmy_cycle_seconds Cycle duration.
+# TYPE dimmy_cycle_seconds histogram
+dimmy_cycle_seconds_bucket{le="0.01"} 1
+dimmy_cycle_seconds_bucket{le="0.1"} 2
+dimmy_cycle_seconds_bucket{le="+Inf"} 3
+dimmy_cycle_seconds_sum 2.055
+dimmy_cycle_seconds_count 3
+`, out.String())
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state", "state.json")
+
+	states, err := LoadState(filename)
+	assert.NoError(t, err)
+	assert.Empty(t, states)
+
+	assert.NoError(t, SaveState(filename, []byte(`{"Vacation":{"value":true}}`)))
+	states, err = LoadState(filename)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"value":true}`, string(states["Vacation"]))
+}
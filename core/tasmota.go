@@ -0,0 +1,46 @@
+package core
+
+type TasmotaIRSendMessage struct {
+	Protocol string `json:"Protocol"`
+	Bits     int    `json:"Bits"`
+	Data     string `json:"Data"`
+}
+
+type TasmotaIRHvacMessage struct {
+	Vendor   string  `json:"Vendor"`
+	Model    *int    `json:"Model,omitempty"`
+	Power    string  `json:"Power"`
+	Mode     string  `json:"Mode"`
+	FanSpeed string  `json:"FanSpeed"`
+	Temp     float64 `json:"Temp"`
+	Celsius  string  `json:"Celsius"`
+}
+
+type TasmotaIRReceivedMessage struct {
+	IrReceived *TasmotaIRSendMessage `json:"IrReceived"`
+}
+
+// TasmotaDiscoveryConfig is published retained to tasmota/discovery/<mac>/config by Tasmota's native discovery
+type TasmotaDiscoveryConfig struct {
+	IP            string    `json:"ip"`
+	DeviceName    string    `json:"dn"`
+	FriendlyNames []*string `json:"fn"`
+	Hostname      string    `json:"hn"`
+	Mac           string    `json:"mac"`
+	Module        string    `json:"md"`
+	Version       string    `json:"sw"`
+	Topic         string    `json:"t"`
+	// e.g. %prefix%/%topic%/
+	FullTopic string `json:"ft"`
+	// command, status and telemetry prefixes
+	Prefixes []string `json:"tp"`
+	// per relay: 0 unused, 1 relay, 2 light, 3 shutter
+	Relays []int `json:"rl"`
+	// 0 no light, 1 dimmer, 2 colour temperature, 3-5 RGB, RGBW, RGBCW
+	LightSubtype int `json:"lt_st"`
+}
+
+// TasmotaDiscoverySensors is published to tasmota/discovery/<mac>/sensors with the readings of all sensors
+type TasmotaDiscoverySensors struct {
+	Sensors map[string]any `json:"sn"`
+}
@@ -43,14 +43,39 @@ type PanelConfig struct {
 }
 
 type ConfigOptions struct {
-	Hidden           *bool              `yaml:"hidden,omitempty"`
-	Transition       *bool              `yaml:"transition,omitempty"`
-	Commands         *map[string]string `yaml:"commands,omitempty"`
-	Sensors          *[]Sensor          `yaml:"sensors,omitempty"`
-	Controls         *[]Control         `yaml:"controls,omitempty"`
-	Devices          *[]string          `yaml:"devices,omitempty"`
-	PreventResending bool               `yaml:"prevent_resending,omitempty"`
-	StupidHack       bool               `yaml:"stupid_hack,omitempty"`
+	Hidden       *bool                         `yaml:"hidden,omitempty"`
+	Transition   *bool                         `yaml:"transition,omitempty"`
+	Commands     *map[string]string            `yaml:"commands,omitempty"`
+	Macros       *map[string][]MacroStep       `yaml:"macros,omitempty"`
+	VirtualState *map[string]map[string]string `yaml:"virtual_state,omitempty"`
+	Protocol     *string                       `yaml:"protocol,omitempty"`
+	Hvac         *HvacConfig                   `yaml:"hvac,omitempty"`
+	Shell        *ShellConfig                  `yaml:"shell,omitempty"`
+	Webhook      *WebhookConfig                `yaml:"webhook,omitempty"`
+	Helper       *HelperConfig                 `yaml:"helper,omitempty"`
+	Presence     *PresenceConfig               `yaml:"presence,omitempty"`
+	Notify       *NotifyConfig                 `yaml:"notify,omitempty"`
+	// topic announcing online/offline, derived from the device topic if not set. An empty string disables it.
+	AvailabilityTopic *string `yaml:"availability_topic,omitempty"`
+	// topic the device reports its state on, derived from the device topic if not set
+	StateTopic *string `yaml:"state_topic,omitempty"`
+	// minutes without a message until the device is considered offline
+	OfflineAfter *int `yaml:"offline_after,omitempty"`
+	// battery percentage and link quality at or below which low_battery and weak_signal are raised
+	BatteryLow       *int            `yaml:"battery_low,omitempty"`
+	WeakSignal       *int            `yaml:"weak_signal,omitempty"`
+	Sensors          *[]Sensor       `yaml:"sensors,omitempty"`
+	Controls         *[]Control      `yaml:"controls,omitempty"`
+	Devices          *[]string       `yaml:"devices,omitempty"`
+	Aggregate        *string         `yaml:"aggregate,omitempty"`
+	GroupTopic       *string         `yaml:"group_topic,omitempty"`
+	Scenes           *map[string]int `yaml:"scenes,omitempty"`
+	Curve            *string         `yaml:"curve,omitempty"`
+	Gamma            *float64        `yaml:"gamma,omitempty"`
+	CurveTable       *[]float64      `yaml:"curve_table,omitempty"`
+	Adaptive         *AdaptiveConfig `yaml:"adaptive,omitempty"`
+	PreventResending bool            `yaml:"prevent_resending,omitempty"`
+	StupidHack       bool            `yaml:"stupid_hack,omitempty"`
 
 	History *bool `yaml:"history,omitempty"`
 
@@ -68,6 +93,190 @@ type ConfigOptions struct {
 	Margin *float64 `yaml:"margin,omitempty"`
 }
 
+// MacroStep either sends a command or waits for the given number of seconds
+type MacroStep struct {
+	Command string  `yaml:"command,omitempty"`
+	Delay   float64 `yaml:"delay,omitempty"`
+}
+
+// HvacConfig describes an air conditioner controlled through Tasmota's IRhvac command
+type HvacConfig struct {
+	Vendor    string    `yaml:"vendor"`
+	Model     *int      `yaml:"model,omitempty"`
+	MinTemp   *float64  `yaml:"min_temp,omitempty"`
+	MaxTemp   *float64  `yaml:"max_temp,omitempty"`
+	Modes     *[]string `yaml:"modes,omitempty"`
+	FanSpeeds *[]string `yaml:"fan_speeds,omitempty"`
+}
+
+// ShellConfig holds the defaults for all commands of a shell device. Every setting except
+// allow_command and max_concurrent can be overridden per command.
+type ShellConfig struct {
+	// allow running arbitrary commands through the "command" key
+	AllowCommand bool `yaml:"allow_command,omitempty"`
+	// file every command that was run or refused is appended to, with its exit code and output
+	AuditLog      string                  `yaml:"audit_log,omitempty"`
+	MaxConcurrent *int                    `yaml:"max_concurrent,omitempty"`
+	Timeout       *int                    `yaml:"timeout,omitempty"` // seconds
+	Dir           string                  `yaml:"dir,omitempty"`
+	Env           map[string]string       `yaml:"env,omitempty"`
+	User          string                  `yaml:"user,omitempty"`
+	Commands      map[string]ShellCommand `yaml:"commands,omitempty"`
+}
+
+// ShellCommand is run either through sh -c (command) or directly (args). Parameters are referenced as {{name}}.
+type ShellCommand struct {
+	Command string                `yaml:"command,omitempty"`
+	Args    []string              `yaml:"args,omitempty"`
+	Params  map[string]ShellParam `yaml:"params,omitempty"`
+	Timeout *int                  `yaml:"timeout,omitempty"`
+	Dir     string                `yaml:"dir,omitempty"`
+	Env     map[string]string     `yaml:"env,omitempty"`
+	User    string                `yaml:"user,omitempty"`
+}
+
+type ShellParam struct {
+	// string, int, float, bool or enum
+	Type    string   `yaml:"type,omitempty"`
+	Pattern string   `yaml:"pattern,omitempty"`
+	Min     *float64 `yaml:"min,omitempty"`
+	Max     *float64 `yaml:"max,omitempty"`
+	Values  []string `yaml:"values,omitempty"`
+	Default *string  `yaml:"default,omitempty"`
+}
+
+// WebhookConfig holds the outgoing requests of a webhook device and who may call it
+type WebhookConfig struct {
+	// required as X-Webhook-Secret header, bearer token or secret query parameter for incoming calls
+	Secret string `yaml:"secret,omitempty"`
+	// keys incoming calls may set, any key is accepted if empty
+	Triggers   []string                  `yaml:"triggers,omitempty"`
+	Timeout    *int                      `yaml:"timeout,omitempty"` // seconds
+	Retries    *int                      `yaml:"retries,omitempty"`
+	RetryDelay *float64                  `yaml:"retry_delay,omitempty"` // seconds, doubled after each attempt
+	Headers    map[string]string         `yaml:"headers,omitempty"`
+	Commands   map[string]WebhookCommand `yaml:"commands,omitempty"`
+}
+
+// WebhookCommand is an HTTP request. URL, headers and body can reference parameters as {{name}}.
+type WebhookCommand struct {
+	Method  string            `yaml:"method,omitempty"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+	Timeout *int              `yaml:"timeout,omitempty"`
+	Retries *int              `yaml:"retries,omitempty"`
+}
+
+// HelperConfig configures virtual helper devices (input_boolean, input_number, input_select, counter and timer)
+type HelperConfig struct {
+	Min     *float64 `yaml:"min,omitempty"`
+	Max     *float64 `yaml:"max,omitempty"`
+	Step    *float64 `yaml:"step,omitempty"`
+	Initial *string  `yaml:"initial,omitempty"`
+	// choices of an input_select
+	Values []string `yaml:"values,omitempty"`
+	// default duration of a timer in seconds
+	Duration *int `yaml:"duration,omitempty"`
+}
+
+type PresenceConfig struct {
+	// seconds without being seen by a probe or the lease file until someone is away
+	AwayAfter *int `yaml:"away_after,omitempty"`
+	// seconds between probes
+	Interval *int `yaml:"interval,omitempty"`
+	// dnsmasq lease file
+	LeaseFile string         `yaml:"lease_file,omitempty"`
+	People    []PersonConfig `yaml:"people"`
+}
+
+// PersonConfig lists the sources a person is detected by. Any source seeing them marks them home.
+type PersonConfig struct {
+	Name string `yaml:"name"`
+	// MQTT topic with OwnTracks messages or a plain home/away payload
+	Topic string `yaml:"topic,omitempty"`
+	// OwnTracks region that counts as home
+	Region string `yaml:"region,omitempty"`
+	// probed with ping, or by connecting to port if it is set
+	Host     string `yaml:"host,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+	Mac      string `yaml:"mac,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"`
+}
+
+// NotifyConfig lists the channels a notify device sends its messages through
+type NotifyConfig struct {
+	// default title, can reference the same placeholders as the message
+	Title    string                   `yaml:"title,omitempty"`
+	Channels map[string]NotifyChannel `yaml:"channels"`
+}
+
+// NotifyChannel is one way of delivering a message. Which fields are used depends on the type:
+// smtp (host, port, username, password, from, to), webhook (url, method, headers, body),
+// mqtt (topic), ntfy (url, token, priority) and gotify (url, token, priority).
+type NotifyChannel struct {
+	Type     string            `yaml:"type"`
+	URL      string            `yaml:"url,omitempty"`
+	Method   string            `yaml:"method,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	Body     string            `yaml:"body,omitempty"`
+	Token    string            `yaml:"token,omitempty"`
+	Priority *int              `yaml:"priority,omitempty"`
+	Topic    string            `yaml:"topic,omitempty"`
+	Host     string            `yaml:"host,omitempty"`
+	Port     int               `yaml:"port,omitempty"`
+	Username string            `yaml:"username,omitempty"`
+	Password string            `yaml:"password,omitempty"`
+	From     string            `yaml:"from,omitempty"`
+	To       []string          `yaml:"to,omitempty"`
+	Timeout  *int              `yaml:"timeout,omitempty"` // seconds
+	// at most rate_limit messages per rate_period seconds (default 60), further messages are dropped
+	RateLimit  *int `yaml:"rate_limit,omitempty"`
+	RatePeriod *int `yaml:"rate_period,omitempty"`
+}
+
+// ExportConfig pushes every value change as Influx line protocol to url or, if it isn't set, file
+type ExportConfig struct {
+	// e.g. http://influx:8086/api/v2/write?org=home&bucket=dimmy or http://influx:8086/write?db=dimmy
+	URL string `yaml:"url,omitempty"`
+	// sent as "Authorization: Token ..." (InfluxDB 2), username and password are used for basic auth instead
+	Token    string `yaml:"token,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// lines are appended to this file instead
+	File        string `yaml:"file,omitempty"`
+	Measurement string `yaml:"measurement,omitempty"`
+	// lines per request, default 500
+	BatchSize *int `yaml:"batch_size,omitempty"`
+	// seconds between flushes, default 10
+	Interval *int `yaml:"interval,omitempty"`
+	// lines kept while the endpoint is unreachable, the oldest are dropped beyond that. Default 50000.
+	BufferSize *int `yaml:"buffer_size,omitempty"`
+	Timeout    *int `yaml:"timeout,omitempty"` // seconds
+}
+
+// HomeAssistantConfig announces devices through Home Assistant's MQTT discovery
+type HomeAssistantConfig struct {
+	// topic prefix Home Assistant reads discovery configs from, default homeassistant
+	DiscoveryPrefix string `yaml:"discovery_prefix,omitempty"`
+	// state and command topics are below this topic, default dimmy/homeassistant
+	BaseTopic string `yaml:"base_topic,omitempty"`
+	// devices to announce, all supported devices if empty
+	Devices []string `yaml:"devices,omitempty"`
+}
+
+type AdaptiveConfig struct {
+	MinBrightness *float64 `yaml:"min_brightness,omitempty"`
+	MaxBrightness *float64 `yaml:"max_brightness,omitempty"`
+	// colour temperatures in mired
+	WarmColorTemp *int `yaml:"warm_color_temp,omitempty"`
+	CoolColorTemp *int `yaml:"cool_color_temp,omitempty"`
+	// seconds to fade to a new value
+	Transition *int `yaml:"transition,omitempty"`
+	// minutes after a manual change, 0 to only resume when the light is switched off and on
+	ResumeAfter *int `yaml:"resume_after,omitempty"`
+}
+
 type ControlType string
 
 const (
@@ -100,8 +309,10 @@ type Sensor struct {
 	Values     []string          `yaml:"values,omitempty"`
 	Hidden     bool              `yaml:"hidden,omitempty"`
 	ShowSince  *string           `yaml:"show_since,omitempty"`
-	History    *bool             `yaml:"history,omitempty"`
+	History    *HistoryConfig    `yaml:"history,omitempty"`
 	ValueIcons map[string]string `yaml:"value_icons,omitempty"`
+	// only used by derived devices, see devices/expression.go
+	Expression string `yaml:"expression,omitempty"`
 }
 
 func GetIconHtml(icon string, name string) string {
@@ -123,16 +334,23 @@ func (s *Sensor) GetIconHtml() string {
 }
 
 type ServerConfig struct {
-	Port          int            `yaml:"port"`
-	MqttServer    string         `yaml:"mqtt_server"`
-	WebRoot       string         `yaml:"webroot"`
-	Lat           float64        `yaml:"latitude"`
-	Lon           float64        `yaml:"longitude"`
-	Devices       []DeviceConfig `yaml:"devices"`
-	Rules         []RuleConfig   `yaml:"rules"`
-	Panels        []PanelConfig  `yaml:"panels"`
-	Filename      string         `yaml:"-"`
-	RulesFilename string         `yaml:"-"`
+	Port          int                  `yaml:"port"`
+	MqttServer    string               `yaml:"mqtt_server"`
+	WebRoot       string               `yaml:"webroot"`
+	Lat           float64              `yaml:"latitude"`
+	Lon           float64              `yaml:"longitude"`
+	Devices       []DeviceConfig       `yaml:"devices"`
+	Rules         []RuleConfig         `yaml:"rules"`
+	Panels        []PanelConfig        `yaml:"panels"`
+	StateFile     string               `yaml:"state_file"`
+	HistoryFile   string               `yaml:"history_file"`
+	Export        *ExportConfig        `yaml:"export,omitempty"`
+	HomeAssistant *HomeAssistantConfig `yaml:"home_assistant,omitempty"`
+	// every device's state is published to <topic>/<device>/state and commands are accepted on <topic>/<device>/set.
+	// Defaults to dimmy, an empty string disables it.
+	MqttBaseTopic *string `yaml:"mqtt_base_topic,omitempty"`
+	Filename      string  `yaml:"-"`
+	RulesFilename string  `yaml:"-"`
 }
 
 func ToPtr[T any](v T) *T {
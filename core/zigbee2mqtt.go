@@ -1,7 +1,13 @@
 package core
 
+import "encoding/json"
+
 type Zigbee2MqttMessageUpdate struct {
-	State string `json:"state,omitempty"`
+	State            string   `json:"state,omitempty"`
+	InstalledVersion *int     `json:"installed_version,omitempty"`
+	LatestVersion    *int     `json:"latest_version,omitempty"`
+	Progress         *float64 `json:"progress,omitempty"`
+	Remaining        *int     `json:"remaining,omitempty"`
 }
 
 type Zigbee2MqttMessage struct {
@@ -15,9 +21,14 @@ type Zigbee2MqttLightMessage struct {
 	Zigbee2MqttMessage
 	State      *string `json:"state,omitempty"`
 	Brightness *int    `json:"brightness,omitempty"`
+	ColorTemp  *int    `json:"color_temp,omitempty"`
 	Transition *int    `json:"transition,omitempty"`
 }
 
+type Zigbee2MqttSceneMessage struct {
+	SceneRecall int `json:"scene_recall"`
+}
+
 type Zigbee2MqttBlindMessage struct {
 	Position *int `json:"position"`
 }
@@ -27,3 +38,63 @@ type Zigbee2MqttBlindStatusMessage struct {
 	Zigbee2MqttBlindMessage
 	State *string `json:"state"`
 }
+
+// Zigbee2MqttBridgeDevice is one entry of the device list zigbee2mqtt publishes to bridge/devices
+type Zigbee2MqttBridgeDevice struct {
+	IeeeAddress  string                 `json:"ieee_address"`
+	Type         string                 `json:"type"`
+	FriendlyName string                 `json:"friendly_name"`
+	Supported    bool                   `json:"supported"`
+	Disabled     bool                   `json:"disabled"`
+	Definition   *Zigbee2MqttDefinition `json:"definition"`
+}
+
+type Zigbee2MqttDefinition struct {
+	Model       string              `json:"model"`
+	Vendor      string              `json:"vendor"`
+	Description string              `json:"description"`
+	Exposes     []Zigbee2MqttExpose `json:"exposes"`
+}
+
+// Zigbee2MqttExpose describes a value of a device. Generic types (numeric, binary, enum, text) are values,
+// specific ones (light, switch, cover, ...) and composites group them as features.
+type Zigbee2MqttExpose struct {
+	Type     string              `json:"type"`
+	Name     string              `json:"name"`
+	Property string              `json:"property"`
+	Access   int                 `json:"access"`
+	ValueMin *float64            `json:"value_min,omitempty"`
+	ValueMax *float64            `json:"value_max,omitempty"`
+	Values   []any               `json:"values,omitempty"`
+	Unit     string              `json:"unit,omitempty"`
+	Features []Zigbee2MqttExpose `json:"features,omitempty"`
+}
+
+// access flags of an expose
+const (
+	Zigbee2MqttAccessState = 1
+	Zigbee2MqttAccessSet   = 2
+	Zigbee2MqttAccessGet   = 4
+)
+
+// Zigbee2MqttBridgeInfo is the part of bridge/info dimmy shows. zigbee2mqtt 1.x publishes the seconds left
+// to join, 2.x the time joining ends in milliseconds.
+type Zigbee2MqttBridgeInfo struct {
+	Version           string `json:"version"`
+	PermitJoin        bool   `json:"permit_join"`
+	PermitJoinTimeout *int   `json:"permit_join_timeout,omitempty"`
+	PermitJoinEnd     *int64 `json:"permit_join_end,omitempty"`
+}
+
+// Zigbee2MqttLogMessage is published to bridge/logging
+type Zigbee2MqttLogMessage struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// Zigbee2MqttResponse is published to bridge/response/<request> after a request
+type Zigbee2MqttResponse struct {
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
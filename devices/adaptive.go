@@ -0,0 +1,170 @@
+package devices
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+// adaptiveDevice is implemented by devices that can follow the sun with brightness and colour temperature
+type adaptiveDevice interface {
+	enableAdaptive(*DimmyTime)
+}
+
+// EnableAdaptiveLighting connects all devices with an adaptive config to the sun position of the time device
+func EnableAdaptiveLighting(devices map[string]DeviceInterface, sun *DimmyTime) {
+	for _, device := range devices {
+		if a, ok := device.(adaptiveDevice); ok {
+			a.enableAdaptive(sun)
+		}
+	}
+}
+
+type Adaptive struct {
+	Enabled bool `json:"enabled"`
+	Paused  bool `json:"paused"`
+
+	name          string
+	sun           *DimmyTime
+	minBrightness float64
+	maxBrightness float64
+	warmColorTemp int
+	coolColorTemp int
+	transition    int
+	resumeAfter   time.Duration
+
+	pausedAt   time.Time
+	lastUpdate time.Time
+	apply      func(brightness float64, colorTemp int, duration int)
+	mutex      sync.Mutex
+}
+
+func newAdaptive(name string, config *core.ConfigOptions) *Adaptive {
+	if config == nil || config.Adaptive == nil {
+		return nil
+	}
+	c := config.Adaptive
+	a := Adaptive{
+		Enabled:       true,
+		name:          name,
+		minBrightness: 30,
+		maxBrightness: 100,
+		warmColorTemp: 454,
+		coolColorTemp: 250,
+		transition:    60,
+		resumeAfter:   60 * time.Minute,
+	}
+	if c.MinBrightness != nil {
+		a.minBrightness = *c.MinBrightness
+	}
+	if c.MaxBrightness != nil {
+		a.maxBrightness = *c.MaxBrightness
+	}
+	if c.WarmColorTemp != nil {
+		a.warmColorTemp = *c.WarmColorTemp
+	}
+	if c.CoolColorTemp != nil {
+		a.coolColorTemp = *c.CoolColorTemp
+	}
+	if c.Transition != nil {
+		a.transition = *c.Transition
+	}
+	if c.ResumeAfter != nil {
+		a.resumeAfter = time.Duration(*c.ResumeAfter) * time.Minute
+	}
+	return &a
+}
+
+// targets returns brightness and colour temperature (in mired) for the given time
+func (a *Adaptive) targets(now time.Time) (float64, int, bool) {
+	daylight, ok := a.sun.Daylight(now)
+	if !ok {
+		return 0, 0, false
+	}
+	brightness := a.minBrightness + (a.maxBrightness-a.minBrightness)*daylight
+	colorTemp := a.warmColorTemp - int(math.Round(float64(a.warmColorTemp-a.coolColorTemp)*daylight))
+	return brightness, colorTemp, true
+}
+
+// update adjusts the light if it is on, not paused and has not been adjusted within the last minute
+func (a *Adaptive) update(current float64, target float64) {
+	if a == nil || a.sun == nil || a.apply == nil {
+		return
+	}
+	a.mutex.Lock()
+	now := time.Now()
+	if a.Paused && a.resumeAfter > 0 && now.Sub(a.pausedAt) >= a.resumeAfter {
+		log.Printf("[%32s] Resuming adaptive lighting\n", a.name)
+		a.Paused = false
+	}
+	// only adjust lights that are on and not in the middle of a fade
+	if !a.Enabled || a.Paused || target == 0 || current != target || now.Sub(a.lastUpdate) < time.Minute {
+		a.mutex.Unlock()
+		return
+	}
+	a.lastUpdate = now
+	a.mutex.Unlock()
+
+	brightness, colorTemp, ok := a.targets(now)
+	if !ok {
+		return
+	}
+	if math.Abs(brightness-current) < 1 {
+		brightness = current
+	}
+	a.apply(brightness, colorTemp, a.transition)
+}
+
+// manualChange pauses adaptive lighting when the light is changed by someone else. Switching the
+// light on from off resumes it instead.
+func (a *Adaptive) manualChange(switchedOn bool) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if switchedOn {
+		if a.Paused {
+			log.Printf("[%32s] Resuming adaptive lighting\n", a.name)
+		}
+		a.Paused = false
+		a.lastUpdate = time.Time{}
+		return
+	}
+	if !a.Paused && a.Enabled {
+		log.Printf("[%32s] Pausing adaptive lighting after manual change\n", a.name)
+	}
+	a.Paused = true
+	a.pausedAt = time.Now()
+}
+
+// switchesOn reports whether request switches on a light that currently has the given target
+func switchesOn(target float64, request core.SwitchRequest) bool {
+	if target > 0 || request.Value == "" || request.Value[0] == '-' {
+		return false
+	}
+	value, err := strconv.ParseFloat(request.Value, 64)
+	return err == nil && value > 0
+}
+
+func (a *Adaptive) setEnabled(value string) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	switch strings.ToLower(value) {
+	case "0", "off", "false":
+		a.Enabled = false
+	default:
+		a.Enabled = true
+		a.Paused = false
+		a.lastUpdate = time.Time{}
+	}
+	log.Printf("[%32s] Adaptive lighting enabled: %v\n", a.name, a.Enabled)
+}
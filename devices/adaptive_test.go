@@ -0,0 +1,101 @@
+package devices
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSun returns a time device where the current time is solar noon
+func newTestSun() *DimmyTime {
+	sun := NewDimmyTime(core.DeviceConfig{Name: "time", Type: "time"}, 0, 0)
+	now := time.Now()
+	sun.events["sunrise"] = now.Add(-6 * time.Hour)
+	sun.events["sunset"] = now.Add(6 * time.Hour)
+	return sun
+}
+
+func newAdaptiveLight(t *testing.T) *ZLight {
+	t.Helper()
+	l := NewZLight(core.DeviceConfig{
+		Name:  "adaptive-light",
+		Topic: "zigbee2mqtt/adaptive",
+		Options: &core.ConfigOptions{
+			Adaptive: &core.AdaptiveConfig{
+				MinBrightness: core.ToPtr(20.0),
+				MaxBrightness: core.ToPtr(80.0),
+				Transition:    core.ToPtr(0),
+			},
+		},
+	})
+	l.enableAdaptive(newTestSun())
+	return l
+}
+
+func TestDimmyTime_Daylight(t *testing.T) {
+	sun := newTestSun()
+	daylight, ok := sun.Daylight(time.Now())
+	assert.True(t, ok)
+	assert.InDelta(t, 1, daylight, 1e-6)
+
+	daylight, _ = sun.Daylight(time.Now().Add(8 * time.Hour))
+	assert.Equal(t, 0.0, daylight)
+
+	_, ok = NewDimmyTime(core.DeviceConfig{Name: "time"}, 0, 0).Daylight(time.Now())
+	assert.False(t, ok)
+}
+
+func TestAdaptive_AdjustsLightThatIsOn(t *testing.T) {
+	l := newAdaptiveLight(t)
+	l.followGroup(50)
+
+	l.UpdateValue()
+
+	assert.InDelta(t, 80, l.GetTarget(), 1e-6)
+	assert.Equal(t, 250, *l.nextColorTemp)
+}
+
+func TestAdaptive_IgnoresLightThatIsOff(t *testing.T) {
+	l := newAdaptiveLight(t)
+	l.UpdateValue()
+	assert.Equal(t, 0.0, l.GetTarget())
+	assert.Nil(t, l.nextColorTemp)
+}
+
+func TestAdaptive_ManualChangePausesUntilSwitchedOffAndOn(t *testing.T) {
+	l := newAdaptiveLight(t)
+	l.followGroup(50)
+	l.ProcessRequest(core.SwitchRequest{Value: "40"})
+	assert.True(t, l.Adaptive.Paused)
+
+	l.UpdateValue()
+	assert.Equal(t, 40.0, l.GetTarget())
+
+	l.ProcessRequest(core.SwitchRequest{Value: "0"})
+	l.ProcessRequest(core.SwitchRequest{Value: "100"})
+	assert.False(t, l.Adaptive.Paused)
+}
+
+func TestAdaptive_ResumesAfterTimeout(t *testing.T) {
+	l := newAdaptiveLight(t)
+	l.Adaptive.resumeAfter = time.Minute
+	l.followGroup(50)
+	l.ProcessRequest(core.SwitchRequest{Value: "40"})
+	l.followGroup(40)
+	l.Adaptive.pausedAt = time.Now().Add(-2 * time.Minute)
+
+	l.UpdateValue()
+
+	assert.False(t, l.Adaptive.Paused)
+	assert.InDelta(t, 80, l.GetTarget(), 1e-6)
+}
+
+func TestAdaptive_ReceiverDisables(t *testing.T) {
+	l := newAdaptiveLight(t)
+	l.ProcessRequest(core.SwitchRequest{Key: "adaptive", Value: "off"})
+	l.followGroup(50)
+	l.UpdateValue()
+	assert.Equal(t, 50.0, l.GetTarget())
+}
@@ -0,0 +1,119 @@
+package devices
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	AvailabilityOnline  = "online"
+	AvailabilityOffline = "offline"
+)
+
+var tasmotaTopic = regexp.MustCompile("^cmnd/(.+)$")
+
+// relays of a Tasmota device are switched through cmnd/<topic>/POWER<n>, but share the device's LWT
+var tasmotaPowerTopic = regexp.MustCompile("^cmnd/(.+)/POWER[0-9]*$")
+
+// availabilityTopic returns where a device announces whether it is online: tele/.../LWT for Tasmota
+// and .../availability for zigbee2mqtt
+func availabilityTopic(config core.DeviceConfig) string {
+	if config.Options != nil && config.Options.AvailabilityTopic != nil {
+		return *config.Options.AvailabilityTopic
+	}
+	if config.Topic == "" {
+		return ""
+	}
+	if tasmotaPowerTopic.MatchString(config.Topic) {
+		return tasmotaPowerTopic.ReplaceAllString(config.Topic, "tele/$1/LWT")
+	}
+	if tasmotaTopic.MatchString(config.Topic) {
+		return tasmotaTopic.ReplaceAllString(config.Topic, "tele/$1/LWT")
+	}
+	return config.Topic + "/availability"
+}
+
+func (d *Device) GetAvailabilityTopic() string {
+	return d.availabilityTopic
+}
+
+func (d *Device) tracksAvailability() bool {
+	return d.availabilityTopic != "" || d.offlineAfter > 0
+}
+
+// GetAvailabilityHandler understands plain payloads (Tasmota's Online/Offline, zigbee2mqtt's legacy format)
+// as well as zigbee2mqtt's {"state":"online"}
+func (d *Device) GetAvailabilityHandler() mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		payload := strings.TrimSpace(string(message.Payload()))
+		var state struct {
+			State string `json:"state"`
+		}
+		if err := json.Unmarshal(message.Payload(), &state); err == nil && state.State != "" {
+			payload = state.State
+		}
+		switch strings.ToLower(payload) {
+		case AvailabilityOnline:
+			d.setAvailability(AvailabilityOnline)
+		case AvailabilityOffline:
+			d.setAvailability(AvailabilityOffline)
+		}
+	}
+}
+
+// MessageReceived is called for every message on the device's state topic. Retained messages don't
+// prove that the device is still there.
+func (d *Device) MessageReceived(retained bool) {
+	if retained {
+		return
+	}
+	now := time.Now()
+	d.mutex.Lock()
+	d.lastMessage = now
+	d.lastSeen = &now
+	d.mutex.Unlock()
+	if d.offlineAfter > 0 {
+		d.setAvailability(AvailabilityOnline)
+	}
+}
+
+// CheckAvailability marks the device offline if it hasn't sent anything within offline_after
+func (d *Device) CheckAvailability() {
+	if d.offlineAfter <= 0 {
+		return
+	}
+	d.mutex.RLock()
+	silent := time.Since(d.lastMessage)
+	d.mutex.RUnlock()
+	if silent > d.offlineAfter {
+		d.setAvailability(AvailabilityOffline)
+	}
+}
+
+func (d *Device) setAvailability(availability string) {
+	d.mutex.Lock()
+	if d.Availability == availability {
+		d.mutex.Unlock()
+		return
+	}
+	d.Availability = availability
+	d.mutex.Unlock()
+	log.Printf("[%32s] Device is %s\n", d.Name, availability)
+	d.UpdateRules("availability", availability)
+}
+
+func (d *Device) GetAvailability() string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.Availability
+}
+
+func (d *Device) IsOffline() bool {
+	return d.GetAvailability() == AvailabilityOffline
+}
@@ -0,0 +1,48 @@
+package devices
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvailabilityTopic(t *testing.T) {
+	assert.Equal(t, "tele/dining/ceiling/LWT", availabilityTopic(core.DeviceConfig{Topic: "cmnd/dining/ceiling"}))
+	assert.Equal(t, "tele/kitchen/LWT", availabilityTopic(core.DeviceConfig{Topic: "cmnd/kitchen/POWER2"}))
+	assert.Equal(t, "zigbee/lamp/availability", availabilityTopic(core.DeviceConfig{Topic: "zigbee/lamp"}))
+	assert.Equal(t, "", availabilityTopic(core.DeviceConfig{}))
+	assert.Equal(t, "", availabilityTopic(core.DeviceConfig{Topic: "zigbee/lamp", Options: &core.ConfigOptions{AvailabilityTopic: core.ToPtr("")}}))
+}
+
+func TestAvailabilityHandler(t *testing.T) {
+	light := NewZLight(core.DeviceConfig{Name: "lamp", Topic: "zigbee/lamp"})
+	rule := &Rule{Triggers: []Trigger{{Device: light, Key: "availability", Condition: &condition{}}}}
+	light.AddRule(rule)
+	assert.Contains(t, light.GetTriggers(), "availability")
+
+	handler := light.GetAvailabilityHandler()
+	handler(nil, &mockMessage{payload: []byte(`{"state":"offline"}`)})
+	assert.True(t, light.IsOffline())
+	assert.Equal(t, AvailabilityOffline, rule.Triggers[0].Condition.LastValue)
+
+	handler(nil, &mockMessage{payload: []byte(`Online`)})
+	assert.False(t, light.IsOffline())
+	assert.Equal(t, AvailabilityOnline, light.GetAvailability())
+}
+
+func TestAvailabilityTimeout(t *testing.T) {
+	plug := NewPlug(core.DeviceConfig{Name: "plug", Topic: "cmnd/plug/POWER", Options: &core.ConfigOptions{OfflineAfter: core.ToPtr(1)}})
+	plug.CheckAvailability()
+	assert.False(t, plug.IsOffline())
+
+	plug.lastMessage = time.Now().Add(-2 * time.Minute)
+	plug.CheckAvailability()
+	assert.True(t, plug.IsOffline())
+
+	plug.MessageReceived(true)
+	assert.True(t, plug.IsOffline(), "retained messages don't count")
+	plug.MessageReceived(false)
+	assert.False(t, plug.IsOffline())
+}
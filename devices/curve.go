@@ -0,0 +1,114 @@
+package devices
+
+import (
+	"log"
+	"math"
+	"sort"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+const (
+	CurveLinear  = "linear"
+	CurveGamma   = "gamma"
+	CurveCIE1931 = "cie1931"
+	CurveTable   = "table"
+)
+
+// brightnessCurve maps perceived brightness to the output level of a light. Both are fractions
+// between 0 and 1, so the same curve can be used for any value range.
+type brightnessCurve struct {
+	kind  string
+	gamma float64
+	table []float64
+}
+
+func newBrightnessCurve(name string, options *core.ConfigOptions) brightnessCurve {
+	c := brightnessCurve{kind: CurveLinear, gamma: 2.2}
+	if options == nil || options.Curve == nil {
+		return c
+	}
+	switch *options.Curve {
+	case CurveLinear, CurveCIE1931:
+		c.kind = *options.Curve
+	case CurveGamma:
+		c.kind = CurveGamma
+		if options.Gamma != nil && *options.Gamma > 0 {
+			c.gamma = *options.Gamma
+		}
+	case CurveTable:
+		if options.CurveTable == nil || len(*options.CurveTable) < 2 {
+			log.Printf("[%32s] Curve table needs at least two entries, using linear curve\n", name)
+			return c
+		}
+		table := *options.CurveTable
+		if !sort.Float64sAreSorted(table) {
+			log.Printf("[%32s] Curve table must be ascending, using linear curve\n", name)
+			return c
+		}
+		if table[len(table)-1] == table[0] {
+			log.Printf("[%32s] Curve table must not be flat, using linear curve\n", name)
+			return c
+		}
+		c.kind = CurveTable
+		c.table = make([]float64, len(table))
+		for i, v := range table {
+			c.table[i] = (v - table[0]) / (table[len(table)-1] - table[0])
+		}
+	default:
+		log.Printf("[%32s] Unknown curve %s, using linear curve\n", name, *options.Curve)
+	}
+	return c
+}
+
+// toOutput converts a perceived brightness to the output level
+func (c brightnessCurve) toOutput(perceived float64) float64 {
+	perceived = math.Max(math.Min(perceived, 1), 0)
+	switch c.kind {
+	case CurveGamma:
+		return math.Pow(perceived, c.gamma)
+	case CurveCIE1931:
+		lightness := perceived * 100
+		if lightness <= 8 {
+			return lightness / 903.3
+		}
+		return math.Pow((lightness+16)/116, 3)
+	case CurveTable:
+		position := perceived * float64(len(c.table)-1)
+		i := int(math.Floor(position))
+		if i >= len(c.table)-1 {
+			return c.table[len(c.table)-1]
+		}
+		return c.table[i] + (c.table[i+1]-c.table[i])*(position-float64(i))
+	}
+	return perceived
+}
+
+// toPerceived converts an output level back to perceived brightness
+func (c brightnessCurve) toPerceived(output float64) float64 {
+	output = math.Max(math.Min(output, 1), 0)
+	switch c.kind {
+	case CurveGamma:
+		return math.Pow(output, 1/c.gamma)
+	case CurveCIE1931:
+		if output <= 0.008856 {
+			return output * 903.3 / 100
+		}
+		return (116*math.Cbrt(output) - 16) / 100
+	case CurveTable:
+		i := sort.SearchFloat64s(c.table, output)
+		if i == 0 {
+			return 0
+		}
+		if i >= len(c.table) {
+			return 1
+		}
+		lower, upper := c.table[i-1], c.table[i]
+		position := float64(i - 1)
+		if upper > lower {
+			position += (output - lower) / (upper - lower)
+		}
+		return position / float64(len(c.table)-1)
+	}
+	return output
+}
@@ -0,0 +1,69 @@
+package devices
+
+import (
+	"math"
+	"testing"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+func newCurveLight(options *core.ConfigOptions) *Light {
+	return NewLight(core.DeviceConfig{
+		Name:    "test-light",
+		Topic:   "cmnd/test/dimmer",
+		Options: options,
+	})
+}
+
+func TestBrightnessCurve_RoundTrip(t *testing.T) {
+	for _, options := range []*core.ConfigOptions{
+		{Curve: core.ToPtr(CurveLinear)},
+		{Curve: core.ToPtr(CurveGamma), Gamma: core.ToPtr(2.8)},
+		{Curve: core.ToPtr(CurveCIE1931)},
+		{Curve: core.ToPtr(CurveTable), CurveTable: &[]float64{0, 2, 10, 40, 100}},
+	} {
+		c := newBrightnessCurve("test", options)
+		for _, perceived := range []float64{0, 0.05, 0.3, 0.5, 0.9, 1} {
+			if back := c.toPerceived(c.toOutput(perceived)); math.Abs(back-perceived) > 1e-9 {
+				t.Errorf("%s: expected %f after round trip, got %f", c.kind, perceived, back)
+			}
+		}
+	}
+}
+
+func TestBrightnessCurve_GammaDimsLowLevels(t *testing.T) {
+	l := newCurveLight(&core.ConfigOptions{Curve: core.ToPtr(CurveGamma), Max: core.ToPtr(255)})
+	linear := newCurveLight(&core.ConfigOptions{Max: core.ToPtr(255)})
+	if l.PercentageToValue(20) >= linear.PercentageToValue(20) {
+		t.Errorf("expected gamma curve to send a lower value at 20%%, got %d (linear %d)", l.PercentageToValue(20), linear.PercentageToValue(20))
+	}
+	if v := l.PercentageToValue(100); v != 255 {
+		t.Errorf("expected 255 at 100%%, got %d", v)
+	}
+	if v := l.PercentageToValue(1); v != 1 {
+		t.Errorf("expected lowest value at 1%%, got %d", v)
+	}
+}
+
+func TestBrightnessCurve_TableInterpolates(t *testing.T) {
+	l := newCurveLight(&core.ConfigOptions{Curve: core.ToPtr(CurveTable), CurveTable: &[]float64{0, 10, 100}, Max: core.ToPtr(101)})
+	// 50.5% is halfway along the table, which maps to 10% of the output range
+	if v := l.PercentageToValue(50.5); v != 11 {
+		t.Errorf("expected 11, got %d", v)
+	}
+	if p := l.ValueToPercentage(11); math.Abs(p-50.5) > 1e-9 {
+		t.Errorf("expected 50.5, got %f", p)
+	}
+}
+
+func TestBrightnessCurve_InvalidTableFallsBackToLinear(t *testing.T) {
+	c := newBrightnessCurve("test", &core.ConfigOptions{Curve: core.ToPtr(CurveTable), CurveTable: &[]float64{100, 0}})
+	if c.kind != CurveLinear {
+		t.Errorf("expected linear curve, got %s", c.kind)
+	}
+
+	c = newBrightnessCurve("test", &core.ConfigOptions{Curve: core.ToPtr(CurveTable), CurveTable: &[]float64{5, 5}})
+	if c.kind != CurveLinear {
+		t.Errorf("expected linear curve for a flat table, got %s", c.kind)
+	}
+}
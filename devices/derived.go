@@ -0,0 +1,165 @@
+package devices
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Derived is a sensor whose values are computed from other devices, e.g. the dew point from temperature and humidity
+type Derived struct {
+	GenericDevice
+
+	expressions map[string]expression
+	references  map[string][]reference
+	inputs      map[reference]any
+	inputMutex  sync.Mutex
+	evalMutex   sync.Mutex
+}
+
+func NewDerived(config core.DeviceConfig) *Derived {
+	d := Derived{GenericDevice: *NewDevice(config)}
+	d.MqttState = ""
+	d.expressions = make(map[string]expression)
+	d.references = make(map[string][]reference)
+	d.inputs = make(map[reference]any)
+
+	for _, sensor := range d.Sensors {
+		if sensor.Expression == "" {
+			log.Printf("[%32s] Sensor %s has no expression\n", d.Name, sensor.Name)
+			continue
+		}
+		e, refs, err := parseExpression(sensor.Expression)
+		if err != nil {
+			log.Printf("[%32s] Invalid expression for %s: %s\n", d.Name, sensor.Name, err.Error())
+			continue
+		}
+		d.expressions[sensor.Name] = e
+		d.references[sensor.Name] = refs
+	}
+	return &d
+}
+
+// ConnectDerivedDevices subscribes all derived devices to the values they depend on
+func ConnectDerivedDevices(devices map[string]DeviceInterface) {
+	for _, device := range devices {
+		if d, ok := device.(*Derived); ok {
+			d.connect(devices)
+		}
+	}
+}
+
+// dependsOn reports whether d uses values of the named device, directly or through other derived devices
+func (d *Derived) dependsOn(name string, devices map[string]DeviceInterface, visited map[string]bool) bool {
+	if visited[d.Name] {
+		return false
+	}
+	visited[d.Name] = true
+	for _, refs := range d.references {
+		for _, ref := range refs {
+			if ref.device == name {
+				return true
+			}
+			if other, ok := devices[ref.device].(*Derived); ok && other.dependsOn(name, devices, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *Derived) connect(devices map[string]DeviceInterface) {
+	observed := make(map[string]bool)
+	for _, refs := range d.references {
+		for _, ref := range refs {
+			device, ok := devices[ref.device]
+			if !ok {
+				log.Printf("[%32s] Device %s not found\n", d.Name, ref.device)
+				continue
+			}
+			if other, ok := device.(*Derived); ok && other.dependsOn(d.Name, devices, map[string]bool{}) {
+				log.Printf("[%32s] Ignoring %s.%s, as it depends on this device\n", d.Name, ref.device, ref.key)
+				continue
+			}
+			d.inputs[ref] = initialValue(device, ref.key)
+			if observed[ref.device] {
+				continue
+			}
+			observed[ref.device] = true
+			name := ref.device
+			device.Observe(func(field string, value any) {
+				d.inputChanged(reference{device: name, key: field}, value)
+			})
+		}
+	}
+	d.evaluate()
+}
+
+func initialValue(device DeviceInterface, key string) any {
+	sensor, ok := device.(interface {
+		hasSensor(string) bool
+		GetValue(string) any
+	})
+	if ok && sensor.hasSensor(key) {
+		return sensor.GetValue(key)
+	}
+	if key == "value" {
+		return device.GetCurrent()
+	}
+	return nil
+}
+
+func (d *Derived) inputChanged(ref reference, value any) {
+	d.inputMutex.Lock()
+	if _, ok := d.inputs[ref]; !ok {
+		d.inputMutex.Unlock()
+		return
+	}
+	d.inputs[ref] = value
+	d.inputMutex.Unlock()
+	d.evaluate()
+}
+
+// evaluate recomputes all sensors
+func (d *Derived) evaluate() {
+	d.evalMutex.Lock()
+	defer d.evalMutex.Unlock()
+
+	d.inputMutex.Lock()
+	inputs := make(map[reference]any, len(d.inputs))
+	for ref, value := range d.inputs {
+		inputs[ref] = value
+	}
+	d.inputMutex.Unlock()
+	lookup := func(ref reference) any { return inputs[ref] }
+
+	for _, name := range sortedKeys(d.expressions) {
+		value, err := d.expressions[name].eval(lookup)
+		if err != nil {
+			core.D("[" + d.Name + "] Can't compute " + name + ": " + err.Error())
+			value = nil
+		}
+		if current := d.GetValue(name); current == value {
+			continue
+		}
+		d.setSensorValue(name, value)
+	}
+}
+
+func (d *Derived) ProcessRequest(request core.SwitchRequest) error {
+	return errors.New("derived devices can't be switched")
+}
+
+func (d *Derived) GetMessageHandler(_ chan core.SwitchRequest, _ DeviceInterface) mqtt.MessageHandler {
+	return func(client mqtt.Client, mqttMessage mqtt.Message) {}
+}
+
+func (d *Derived) GetConfig(name string) core.DeviceConfig {
+	config := d.GenericDevice.GetConfig(name)
+	config.Type = "derived"
+	config.Topic = ""
+	return config
+}
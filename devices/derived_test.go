@@ -0,0 +1,89 @@
+package devices
+
+import (
+	"math"
+	"testing"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func evalExpression(source string, values map[reference]any) (any, error) {
+	e, _, err := parseExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	return e.eval(func(ref reference) any { return values[ref] })
+}
+
+func TestExpression(t *testing.T) {
+	values := map[reference]any{
+		{"Sensor", "temperature"}:   21.5,
+		{"Living Room", "contact"}:  false,
+		{"Plug", "power"}:           "12",
+		{"Living Room", "humidity"}: 40.0,
+	}
+	tests := []struct {
+		source   string
+		expected any
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"-Sensor.temperature + 1.5", -20.0},
+		{"Sensor.temperature > 20 && !{Living Room.contact}", true},
+		{"{Living Room.contact} == false ? 'open' : 'closed'", "open"},
+		{"sum(Plug.power, Missing.power, 3)", 15.0},
+		{"max(1, 5, 3) - min(4, 2)", 3.0},
+		{"round(10 / 3, 2)", 3.33},
+		{"any(false, 0, 'on')", true},
+		{"count(true, false, 1)", 2.0},
+		{"pow(2, 10)", 1024.0},
+		{"'T' + Sensor.temperature", "T21.5"},
+	}
+	for _, tt := range tests {
+		value, err := evalExpression(tt.source, values)
+		assert.NoError(t, err, tt.source)
+		assert.Equal(t, tt.expected, value, tt.source)
+	}
+
+	_, err := evalExpression("Missing.power + 1", values)
+	assert.Error(t, err)
+	_, err = evalExpression("1 / 0", values)
+	assert.Error(t, err)
+
+	for _, invalid := range []string{"1 +", "foo(1)", "(1", "Sensor", "1 ? 2", "{unterminated"} {
+		_, _, err := parseExpression(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	_, refs, _ := parseExpression("{Living Room.humidity} + Sensor.temperature")
+	assert.Equal(t, []reference{{"Living Room", "humidity"}, {"Sensor", "temperature"}}, refs)
+}
+
+func TestDerived(t *testing.T) {
+	sensor := NewDevice(core.DeviceConfig{Name: "Bath", Options: &core.ConfigOptions{Sensors: &[]core.Sensor{{Name: "temperature"}, {Name: "humidity"}}}})
+	derived := NewDerived(core.DeviceConfig{Name: "Climate", Type: "derived", Options: &core.ConfigOptions{Sensors: &[]core.Sensor{
+		{Name: "dew_point", Expression: "round(Bath.temperature - (100 - Bath.humidity) / 5, 1)"},
+		{Name: "self", Expression: "Climate.self + 1"},
+	}}})
+	alert := NewDerived(core.DeviceConfig{Name: "Alert", Type: "derived", Options: &core.ConfigOptions{Sensors: &[]core.Sensor{
+		{Name: "humid", Expression: "Climate.dew_point > 15"},
+	}}})
+	devices := map[string]DeviceInterface{"Bath": sensor, "Climate": derived, "Alert": alert}
+	ConnectDerivedDevices(devices)
+
+	assert.Nil(t, derived.GetValue("dew_point"))
+
+	sensor.setSensorValue("temperature", 20.0)
+	sensor.setSensorValue("humidity", 60.0)
+	assert.Equal(t, 12.0, derived.GetValue("dew_point"))
+	assert.Equal(t, false, alert.GetValue("humid"))
+	assert.Nil(t, derived.GetValue("self"))
+
+	rule := &Rule{Triggers: []Trigger{{Device: alert, Key: "humid", Condition: &condition{}}}}
+	alert.AddRule(rule)
+	sensor.setSensorValue("temperature", 25.0)
+	assert.Equal(t, 17.0, derived.GetValue("dew_point"))
+	assert.Equal(t, true, rule.Triggers[0].Condition.LastValue)
+	assert.False(t, math.IsNaN(derived.GetValue("dew_point").(float64)))
+}
@@ -30,7 +30,8 @@ type DeviceInterface interface {
 	GetCurrent() float64
 	SetCurrent(float64)
 	GetEmoji() string
-	ProcessRequest(core.SwitchRequest)
+	// ProcessRequest switches the device, it returns an error if the request can't be processed
+	ProcessRequest(core.SwitchRequest) error
 	GetMessageHandler(chan core.SwitchRequest, DeviceInterface) mqtt.MessageHandler
 	GetStateMessageHandler(chan core.SwitchRequest, DeviceInterface) mqtt.MessageHandler
 	GetTriggers() []string
@@ -41,6 +42,13 @@ type DeviceInterface interface {
 	Unlock()
 	AddRule(*Rule)
 	RemoveRule(*Rule)
+	Observe(func(field string, value any))
+	GetAvailabilityTopic() string
+	GetAvailabilityHandler() mqtt.MessageHandler
+	MessageReceived(retained bool)
+	CheckAvailability()
+	IsOffline() bool
+	GetHealth() Health
 	IsPersistent(string) bool
 	HasReceivers() bool
 	GetIconHtml() template.HTML
@@ -49,6 +57,7 @@ type DeviceInterface interface {
 	GetConfig(string) core.DeviceConfig
 	SetName(string)
 	SetLabel(string)
+	RenameTopic(from string, to string) bool
 
 	PublishValue(mqtt.Client)
 	PollValue(mqtt.Client)
@@ -68,11 +77,25 @@ type Device struct {
 	Receivers   []string
 	mutex       *sync.RWMutex
 	rules       []*Rule
+	observers   []func(field string, value any)
 
 	persistentFields []string
 
-	LinkQuality *int `json:"linkquality"`
-	Battery     *int `json:"battery"`
+	LinkQuality        *int `json:"linkquality"`
+	Battery            *int `json:"battery"`
+	batteryLow         int
+	weakSignal         int
+	lowBattery         bool
+	weakSignalDetected bool
+	linkQualityHistory []HealthSample
+	lastSeen           *time.Time
+
+	Availability      string `json:"availability,omitempty"`
+	availabilityTopic string
+	// state topic set in the config instead of the one derived from the device topic
+	stateTopic   *string
+	offlineAfter time.Duration
+	lastMessage  time.Time
 }
 
 func (d *Device) setBaseConfig(config core.DeviceConfig) {
@@ -104,7 +127,16 @@ func (d *Device) setBaseConfig(config core.DeviceConfig) {
 		if config.Options.Hidden != nil {
 			d.Hidden = *config.Options.Hidden
 		}
+		if config.Options.OfflineAfter != nil {
+			d.offlineAfter = time.Duration(*config.Options.OfflineAfter) * time.Minute
+		}
+		if config.Options.StateTopic != nil && *config.Options.StateTopic != "" {
+			d.stateTopic = core.ToPtr(*config.Options.StateTopic)
+		}
 	}
+	d.availabilityTopic = availabilityTopic(config)
+	d.setHealthThresholds(config)
+	d.lastMessage = time.Now()
 
 	d.persistentFields = []string{"battery"}
 
@@ -189,7 +221,14 @@ func (d *Device) GetStateMessageHandler(channel chan core.SwitchRequest, sensor
 }
 
 func (d *Device) GetTriggers() []string {
-	return d.Triggers
+	health := d.healthTriggers()
+	if d.tracksAvailability() {
+		health = append(health, "availability")
+	}
+	if len(health) == 0 {
+		return d.Triggers
+	}
+	return append(append([]string{}, d.Triggers...), health...)
 }
 
 func (d *Device) GetReceivers() []string {
@@ -216,33 +255,16 @@ func (d *Device) GetMax() int {
 func (d *Device) GetMin() int {
 	return 0
 }
-func (d *Device) ProcessRequest(request core.SwitchRequest) {
-}
-
-func (d *Device) setBatteryLevel(battery *int) {
-	if battery == nil {
-		return
-	}
-	d.Battery = battery
-}
-
-func (d *Device) setLinkQuality(linkQuality *int) {
-	if linkQuality == nil {
-		return
-	}
-	d.LinkQuality = linkQuality
+func (d *Device) ProcessRequest(request core.SwitchRequest) error {
+	return nil
 }
 
 func (d *Device) parseDefaultValues(data map[string]any) {
-	if battery, ok := data["battery"]; ok {
-		if batteryInt, ok := battery.(int); ok {
-			d.setBatteryLevel(&batteryInt)
-		}
+	if battery, ok := intValue(data["battery"]); ok {
+		d.setBatteryLevel(&battery)
 	}
-	if linkQuality, ok := data["linkquality"]; ok {
-		if linkQualityInt, ok := linkQuality.(int); ok {
-			d.setLinkQuality(&linkQualityInt)
-		}
+	if linkQuality, ok := intValue(data["linkquality"]); ok {
+		d.setLinkQuality(&linkQuality)
 	}
 }
 
@@ -260,6 +282,14 @@ func (d *Device) UpdateRules(field string, value any) {
 	for r := range d.rules {
 		d.UpdateRule(d.rules[r], field, value)
 	}
+	for _, observer := range d.observers {
+		observer(field, value)
+	}
+}
+
+// Observe registers f to be called whenever a trigger value of the device changes
+func (d *Device) Observe(f func(field string, value any)) {
+	d.observers = append(d.observers, f)
 }
 
 func (d *Device) AddRule(rule *Rule) {
@@ -279,6 +309,9 @@ func (d *Device) HasReceivers() bool {
 }
 
 func (d *Device) IsPersistent(field string) bool {
+	if isHealthField(field) {
+		return true
+	}
 	for _, f := range d.persistentFields {
 		if f == field {
 			return true
@@ -431,6 +464,30 @@ func (d *Device) SetName(name string) {
 	d.Name = name
 }
 
+// setStateTopic sets the topic the device reports its state on, unless the config sets one
+func (d *Device) setStateTopic(derived string) {
+	d.MqttState = derived
+	if d.stateTopic != nil {
+		d.MqttState = *d.stateTopic
+	}
+}
+
+// RenameTopic moves the device's topics from one zigbee2mqtt friendly name to another. It returns false if
+// none of them used the old name.
+func (d *Device) RenameTopic(from string, to string) bool {
+	renamed := false
+	for _, topic := range []*string{&d.MqttTopic, &d.MqttState, &d.availabilityTopic} {
+		if *topic == from || strings.HasPrefix(*topic, from+"/") {
+			*topic = to + strings.TrimPrefix(*topic, from)
+			renamed = true
+		}
+	}
+	if d.stateTopic != nil {
+		d.stateTopic = core.ToPtr(d.MqttState)
+	}
+	return renamed
+}
+
 func (d *Device) SetLabel(label string) {
 	d.Label = label
 }
@@ -1,6 +1,11 @@
 package devices
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
 
 func TestDevice_LikelySensor_CommonZigbeeFields(t *testing.T) {
 	d := &Device{}
@@ -55,3 +60,19 @@ func (m *mockMessage) Topic() string     { return "" }
 func (m *mockMessage) MessageID() uint16 { return 0 }
 func (m *mockMessage) Payload() []byte   { return m.payload }
 func (m *mockMessage) Ack()              {}
+
+type publishedMessage struct {
+	topic   string
+	payload string
+}
+
+// mockClient records published messages. Methods that are not overridden panic when called.
+type mockClient struct {
+	mqtt.Client
+	published []publishedMessage
+}
+
+func (c *mockClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.published = append(c.published, publishedMessage{topic: topic, payload: fmt.Sprintf("%s", payload)})
+	return &mqtt.DummyToken{}
+}
@@ -1,6 +1,7 @@
 package devices
 
 import (
+	"errors"
 	"log"
 	"math"
 	"strconv"
@@ -21,6 +22,14 @@ type Dimmable struct {
 	TransitionTime int
 	targetLock     *sync.RWMutex
 	stepLock       *sync.RWMutex
+
+	// Eased fades are calculated from their start value instead of using a fixed Step
+	easing     string
+	fadeStart  float64
+	fadeCycle  int
+	fadeCycles int
+
+	Adaptive *Adaptive `json:"adaptive,omitempty"`
 }
 
 func (d *Dimmable) init() {
@@ -77,7 +86,21 @@ func (d *Dimmable) setTarget(target float64) {
 	d.targetLock.Unlock()
 }
 
-func (d *Dimmable) ProcessRequest(request core.SwitchRequest) {
+func (d *Dimmable) ProcessRequest(request core.SwitchRequest) error {
+	if request.Key == "adaptive" {
+		d.Adaptive.setEnabled(request.Value)
+		return nil
+	}
+	if request.Key == "color_temp" {
+		return errors.New("device does not support colour temperature")
+	}
+	d.Adaptive.manualChange(switchesOn(d.GetTarget(), request))
+	d.fade(request)
+	return nil
+}
+
+// fade starts dimming towards the requested value
+func (d *Dimmable) fade(request core.SwitchRequest) {
 	if request.Key == "" {
 		request.Key = "brightness"
 	}
@@ -104,28 +127,47 @@ func (d *Dimmable) ProcessRequest(request core.SwitchRequest) {
 		return
 	}
 
-	diff := int(math.Abs(d.GetCurrent() - value))
+	easing := request.Easing
+	if easing != "" && !IsValidEasing(easing) {
+		log.Printf("[%32s] Unknown easing %s, fading linearly\n", d.GetName(), easing)
+		easing = ""
+	}
+	d.stepLock.Lock()
+	d.easing = easing
+	d.fadeStart = d.GetCurrent()
+	d.fadeCycle = 0
+	d.fadeCycles = request.Duration * 1000 / core.CycleLength
+	d.stepLock.Unlock()
+
+	// Current and Target are perceived brightness, so stepping linearly gives an even fade.
+	// Devices apply their brightness curve when converting to output values.
+	diff := math.Abs(d.GetCurrent() - value)
 	var step float64
 	cycles := request.Duration * 1000 / core.CycleLength
 	if request.Duration == 0 {
-		step = float64(diff)
+		step = diff
 	} else {
-		step = float64(diff) / float64(cycles)
+		step = diff / float64(cycles)
 	}
 	d.setStep(step)
 }
 
-func (d *Dimmable) ProcessRequestChild(request core.SwitchRequest) {
-	d.ProcessRequest(request)
+func (d *Dimmable) ProcessRequestChild(request core.SwitchRequest) error {
+	return d.ProcessRequest(request)
 }
 
 func (d *Dimmable) UpdateValue() (float64, bool) {
+	d.Adaptive.update(d.GetCurrent(), d.GetTarget())
 	current := d.GetCurrent()
 	if current != d.GetTarget() {
 		if d.transition {
 			d.SetCurrent(d.Target)
 			return d.Target, true
 		}
+		if next, ok := d.nextEasedValue(); ok {
+			d.SetCurrent(next)
+			return next, true
+		}
 		if d.GetStep() == 0 {
 			d.setStep(100)
 		}
@@ -147,6 +189,24 @@ func (d *Dimmable) UpdateValue() (float64, bool) {
 	return 0, false
 }
 
+// nextEasedValue returns the value for the next cycle of an eased fade, or false if the current
+// fade is linear
+func (d *Dimmable) nextEasedValue() (float64, bool) {
+	d.stepLock.Lock()
+	defer d.stepLock.Unlock()
+	if d.easing == "" || d.easing == EasingLinear || d.fadeCycles == 0 {
+		return 0, false
+	}
+	d.fadeCycle++
+	target := d.GetTarget()
+	if d.fadeCycle >= d.fadeCycles {
+		d.easing = ""
+		return target, true
+	}
+	progress := float64(d.fadeCycle) / float64(d.fadeCycles)
+	return d.fadeStart + (target-d.fadeStart)*ease(d.easing, progress), true
+}
+
 func (d *Dimmable) UpdateValueChild() (float64, bool) {
 	return d.UpdateValue()
 }
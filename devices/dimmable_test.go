@@ -163,3 +163,38 @@ func TestDimmable_UpdateValue_TransitionJumpsImmediately(t *testing.T) {
 		t.Errorf("expected Current=80 after transition jump, got %f", d.GetCurrent())
 	}
 }
+
+func TestDimmable_Easing_FollowsCurve(t *testing.T) {
+	d := newTestLight()
+	// 5s = 25 cycles, ease-in is cubic so the first step is tiny
+	d.ProcessRequest(core.SwitchRequest{Value: "100", Duration: 5, Easing: EasingIn})
+	val, send := d.UpdateValue()
+	if !send {
+		t.Fatal("expected send=true while easing")
+	}
+	assert.InDelta(t, 100*ease(EasingIn, 1.0/25), val, 1e-9)
+	for i := 0; i < 11; i++ {
+		val, _ = d.UpdateValue()
+	}
+	// after 12 of 25 cycles, ease-in is still well below the linear value
+	assert.Less(t, val, 100*12.0/25)
+	for i := 0; i < 13; i++ {
+		val, _ = d.UpdateValue()
+	}
+	assert.Equal(t, 100.0, val)
+	_, send = d.UpdateValue()
+	assert.False(t, send)
+}
+
+func TestDimmable_Easing_SunriseIsSymmetric(t *testing.T) {
+	assert.InDelta(t, 0.5, ease(EasingSunrise, 0.5), 1e-9)
+	assert.Less(t, ease(EasingSunrise, 0.1), 0.1)
+	assert.Greater(t, ease(EasingSunrise, 0.9), 0.9)
+}
+
+func TestDimmable_Easing_UnknownFallsBackToLinear(t *testing.T) {
+	d := newTestLight()
+	d.ProcessRequest(core.SwitchRequest{Value: "75", Duration: 5, Easing: "bounce"})
+	val, _ := d.UpdateValue()
+	assert.Equal(t, 3.0, val)
+}
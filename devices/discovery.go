@@ -0,0 +1,37 @@
+package devices
+
+import (
+	"sync"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+// DiscoveryInfo is what a device or its bridge announced about an unknown device
+type DiscoveryInfo struct {
+	// suggested device name
+	Name        string
+	Vendor      string
+	Model       string
+	Description string
+	// options the configured device needs
+	AvailabilityTopic *string
+	StateTopic        *string
+	Protocol          *string
+}
+
+// newDiscoveredDevice creates an unknown device that is described by its discovery messages rather than guessed
+func newDiscoveredDevice(topic string) *GenericDevice {
+	d := GenericDevice{}
+	d.setBaseConfig(core.DeviceConfig{Name: topic, Topic: topic})
+	d.MqttState = topic
+	d.Type = "device"
+	d.valueMutex = new(sync.RWMutex)
+	d.Values = make(map[string]*SensorValue)
+	d.discovery = &DiscoveryInfo{}
+	return &d
+}
+
+// GetDiscovery returns what the device announced about itself, or nil if it was detected from its messages
+func (d *GenericDevice) GetDiscovery() *DiscoveryInfo {
+	return d.discovery
+}
@@ -0,0 +1,38 @@
+package devices
+
+import (
+	"math"
+)
+
+const (
+	EasingLinear  = "linear"
+	EasingIn      = "ease-in"
+	EasingOut     = "ease-out"
+	EasingSine    = "sine"
+	EasingSunrise = "sunrise"
+)
+
+// easingFunctions map the progress of a fade (0 to 1) to the share of the brightness change that
+// should be reached at that point
+var easingFunctions = map[string]func(float64) float64{
+	EasingLinear: func(t float64) float64 { return t },
+	EasingIn:     func(t float64) float64 { return t * t * t },
+	EasingOut:    func(t float64) float64 { return 1 - math.Pow(1-t, 3) },
+	EasingSine:   func(t float64) float64 { return -(math.Cos(math.Pi*t) - 1) / 2 },
+	// Slow start, steep middle and a long settle at the end, like the sun coming up
+	EasingSunrise: func(t float64) float64 { return t * t * t * (t*(6*t-15) + 10) },
+}
+
+func IsValidEasing(easing string) bool {
+	_, ok := easingFunctions[easing]
+	return ok
+}
+
+func ease(easing string, progress float64) float64 {
+	progress = math.Max(math.Min(progress, 1), 0)
+	f, ok := easingFunctions[easing]
+	if !ok {
+		return progress
+	}
+	return f(progress)
+}
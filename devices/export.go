@@ -0,0 +1,16 @@
+package devices
+
+import (
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+// ExportValues passes every value change of the devices to exporter
+func ExportValues(devices map[string]DeviceInterface, exporter *core.LineExporter) {
+	for name, device := range devices {
+		device.Observe(func(field string, value any) {
+			exporter.Add(map[string]string{"device": name, "key": field, "type": device.GetType()}, value, time.Now())
+		})
+	}
+}
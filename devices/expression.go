@@ -0,0 +1,551 @@
+package devices
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a parsed formula of a derived device. Values of other devices are referenced as
+// Device.key or, if the device name contains spaces, {Device Name.key}.
+type expression interface {
+	eval(lookup func(reference) any) (any, error)
+}
+
+type reference struct {
+	device string
+	key    string
+}
+
+type literalExpr struct{ value any }
+type referenceExpr struct{ ref reference }
+type unaryExpr struct {
+	op string
+	x  expression
+}
+type binaryExpr struct {
+	op   string
+	l, r expression
+}
+type conditionalExpr struct{ cond, then, otherwise expression }
+type callExpr struct {
+	name string
+	args []expression
+}
+
+type token struct {
+	kind  string // number, string, ident, ref, op, eof
+	value string
+}
+
+type expressionParser struct {
+	tokens []token
+	pos    int
+	refs   []reference
+}
+
+// parseExpression parses source and returns the expression and all values it references
+func parseExpression(source string) (expression, []reference, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := expressionParser{tokens: tokens}
+	e, err := p.conditional()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, nil, fmt.Errorf("unexpected %s", t.value)
+	}
+	return e, p.refs, nil
+}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' ||
+				((runes[i] == '-' || runes[i] == '+') && runes[i-1] == 'e')) {
+				i++
+			}
+			tokens = append(tokens, token{"number", string(runes[start:i])})
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != c {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{"string", string(runes[i+1 : end])})
+			i = end + 1
+		case c == '{':
+			end := i + 1
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated reference")
+			}
+			tokens = append(tokens, token{"ref", string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{"ident", string(runes[start:i])})
+		default:
+			op := string(c)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "<=", ">=", "==", "!=", "&&", "||":
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%<>!()?:,", op) && len(op) == 1 {
+				return nil, fmt.Errorf("unexpected character %s", op)
+			}
+			tokens = append(tokens, token{"op", op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: "eof", value: "end of expression"}), nil
+}
+
+func (p *expressionParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.value == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *expressionParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		return fmt.Errorf("expected %s, got %s", op, p.peek().value)
+	}
+	return nil
+}
+
+func (p *expressionParser) conditional() (expression, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	return conditionalExpr{cond, then, otherwise}, nil
+}
+
+// binaryLevels lists the binary operators from lowest to highest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *expressionParser) binary(level int) (expression, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(binaryLevels[level]...)
+		if !ok {
+			return l, nil
+		}
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = binaryExpr{op, l, r}
+	}
+}
+
+func (p *expressionParser) unary() (expression, error) {
+	if op, ok := p.accept("-", "!"); ok {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op, x}, nil
+	}
+	return p.primary()
+}
+
+func (p *expressionParser) primary() (expression, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case "number":
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.value)
+		}
+		return literalExpr{value}, nil
+	case "string":
+		return literalExpr{t.value}, nil
+	case "ref":
+		return p.reference(t.value)
+	case "ident":
+		switch t.value {
+		case "true":
+			return literalExpr{true}, nil
+		case "false":
+			return literalExpr{false}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.call(t.value)
+		}
+		return p.reference(t.value)
+	case "op":
+		if t.value == "(" {
+			e, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", t.value)
+}
+
+func (p *expressionParser) reference(name string) (expression, error) {
+	i := strings.LastIndex(name, ".")
+	if i <= 0 || i == len(name)-1 {
+		return nil, fmt.Errorf("invalid reference %s, expected device.key", name)
+	}
+	ref := reference{device: strings.TrimSpace(name[:i]), key: strings.TrimSpace(name[i+1:])}
+	p.refs = append(p.refs, ref)
+	return referenceExpr{ref}, nil
+}
+
+func (p *expressionParser) call(name string) (expression, error) {
+	if _, ok := expressionFunctions[name]; !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	c := callExpr{name: name}
+	if _, ok := p.accept(")"); ok {
+		return c, nil
+	}
+	for {
+		arg, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if _, ok := p.accept(","); !ok {
+			break
+		}
+	}
+	return c, p.expect(")")
+}
+
+func (e literalExpr) eval(func(reference) any) (any, error) {
+	return e.value, nil
+}
+
+func (e referenceExpr) eval(lookup func(reference) any) (any, error) {
+	return lookup(e.ref), nil
+}
+
+func (e unaryExpr) eval(lookup func(reference) any) (any, error) {
+	x, err := e.x.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "!" {
+		return !toBool(x), nil
+	}
+	n, err := toNumber(x)
+	return -n, err
+}
+
+func (e binaryExpr) eval(lookup func(reference) any) (any, error) {
+	l, err := e.l.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "&&":
+		if !toBool(l) {
+			return false, nil
+		}
+		r, err := e.r.eval(lookup)
+		return toBool(r), err
+	case "||":
+		if toBool(l) {
+			return true, nil
+		}
+		r, err := e.r.eval(lookup)
+		return toBool(r), err
+	}
+	r, err := e.r.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "==", "!=":
+		equal := fmt.Sprintf("%v", l) == fmt.Sprintf("%v", r)
+		if ln, err := toNumber(l); err == nil {
+			if rn, err := toNumber(r); err == nil {
+				equal = ln == rn
+			}
+		}
+		return equal == (e.op == "=="), nil
+	case "+":
+		if ls, ok := l.(string); ok {
+			return ls + fmt.Sprintf("%v", r), nil
+		}
+	}
+	ln, err := toNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	rn, err := toNumber(r)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "<":
+		return ln < rn, nil
+	case "<=":
+		return ln <= rn, nil
+	case ">":
+		return ln > rn, nil
+	case ">=":
+		return ln >= rn, nil
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/":
+		if rn == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return ln / rn, nil
+	case "%":
+		return math.Mod(ln, rn), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", e.op)
+}
+
+func (e conditionalExpr) eval(lookup func(reference) any) (any, error) {
+	cond, err := e.cond.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	if toBool(cond) {
+		return e.then.eval(lookup)
+	}
+	return e.otherwise.eval(lookup)
+}
+
+func (e callExpr) eval(lookup func(reference) any) (any, error) {
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		value, err := arg.eval(lookup)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return expressionFunctions[e.name](args)
+}
+
+var expressionFunctions = map[string]func([]any) (any, error){
+	"min":   aggregate(func(values []float64) float64 { return fold(values, math.Min) }),
+	"max":   aggregate(func(values []float64) float64 { return fold(values, math.Max) }),
+	"sum":   aggregate(sumOf),
+	"avg":   aggregate(func(values []float64) float64 { return sumOf(values) / float64(len(values)) }),
+	"abs":   math1(math.Abs),
+	"floor": math1(math.Floor),
+	"ceil":  math1(math.Ceil),
+	"sqrt":  math1(math.Sqrt),
+	"ln":    math1(math.Log),
+	"exp":   math1(math.Exp),
+	"pow": func(args []any) (any, error) {
+		numbers, err := numberArgs(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return math.Pow(numbers[0], numbers[1]), nil
+	},
+	"round": func(args []any) (any, error) {
+		if len(args) == 1 {
+			args = append(args, 0.0)
+		}
+		numbers, err := numberArgs(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		factor := math.Pow(10, numbers[1])
+		return math.Round(numbers[0]*factor) / factor, nil
+	},
+	"any": func(args []any) (any, error) {
+		for _, arg := range args {
+			if toBool(arg) {
+				return true, nil
+			}
+		}
+		return false, nil
+	},
+	"all": func(args []any) (any, error) {
+		for _, arg := range args {
+			if !toBool(arg) {
+				return false, nil
+			}
+		}
+		return true, nil
+	},
+	"count": func(args []any) (any, error) {
+		count := 0.0
+		for _, arg := range args {
+			if toBool(arg) {
+				count++
+			}
+		}
+		return count, nil
+	},
+}
+
+// aggregate skips missing values, so a single device being offline doesn't hide the result
+func aggregate(f func([]float64) float64) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		var values []float64
+		for _, arg := range args {
+			if arg == nil {
+				continue
+			}
+			n, err := toNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, n)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("no values")
+		}
+		return f(values), nil
+	}
+}
+
+func math1(f func(float64) float64) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		numbers, err := numberArgs(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return f(numbers[0]), nil
+	}
+}
+
+func numberArgs(args []any, count int) ([]float64, error) {
+	if len(args) != count {
+		return nil, fmt.Errorf("expected %d arguments, got %d", count, len(args))
+	}
+	numbers := make([]float64, count)
+	for i, arg := range args {
+		n, err := toNumber(arg)
+		if err != nil {
+			return nil, err
+		}
+		numbers[i] = n
+	}
+	return numbers, nil
+}
+
+func sumOf(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func fold(values []float64, f func(float64, float64) float64) float64 {
+	result := values[0]
+	for _, v := range values[1:] {
+		result = f(result, v)
+	}
+	return result
+}
+
+func toNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case nil:
+		return 0, fmt.Errorf("missing value")
+	}
+	return 0, fmt.Errorf("not a number: %v", value)
+}
+
+func toBool(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case string:
+		switch strings.ToLower(v) {
+		case "", "off", "false", "0", "closed":
+			return false
+		}
+		return true
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +33,7 @@ type GenericDevice struct {
 	Values     map[string]*SensorValue `json:"Values"`
 	hasHistory bool
 	valueMutex *sync.RWMutex
+	discovery  *DiscoveryInfo
 }
 
 func NewDevice(config core.DeviceConfig) *GenericDevice {
@@ -117,6 +119,10 @@ func NewDeviceFromMessage(topic string, data map[string]any) *GenericDevice {
 }
 
 func (d *GenericDevice) UpdateFromMessage(data map[string]any) {
+	if d.discovery != nil {
+		// the device described itself, that's more accurate than guessing from its messages
+		return
+	}
 
 	d.Type = LikelyDeviceType("", data)
 
@@ -131,9 +137,7 @@ func (d *GenericDevice) UpdateFromMessage(data map[string]any) {
 				sensor.Values = make([]string, 1)
 				sensor.Values[0] = val
 			}
-			d.Sensors = append(d.Sensors, sensor)
-			d.Triggers = append(d.Triggers, key)
-			d.Values[key] = &SensorValue{LastChanged: time.Unix(0, 0), History: make([]SensorHistory, 0)}
+			d.addSensor(sensor)
 		}
 		if d.likelyControl(key) && !d.hasControl(key) {
 			controlType := core.ControlTypeBool
@@ -144,7 +148,7 @@ func (d *GenericDevice) UpdateFromMessage(data map[string]any) {
 				minValue = core.ToPtr(0)
 				maxValue = core.ToPtr(254)
 			}
-			control := core.Control{
+			d.addControl(core.Control{
 				Name:         key,
 				Icon:         getIcon(key),
 				Type:         controlType,
@@ -153,13 +157,22 @@ func (d *GenericDevice) UpdateFromMessage(data map[string]any) {
 				Max:          maxValue,
 				NeedsSending: false,
 				Value:        nil,
-			}
-			d.Controls = append(d.Controls, control)
-			d.Receivers = append(d.Receivers, key)
+			})
 		}
 	}
 }
 
+func (d *GenericDevice) addSensor(sensor core.Sensor) {
+	d.Sensors = append(d.Sensors, sensor)
+	d.Triggers = append(d.Triggers, sensor.Name)
+	d.Values[sensor.Name] = &SensorValue{LastChanged: time.Unix(0, 0), History: make([]SensorHistory, 0)}
+}
+
+func (d *GenericDevice) addControl(control core.Control) {
+	d.Controls = append(d.Controls, control)
+	d.Receivers = append(d.Receivers, control.Name)
+}
+
 func (d *GenericDevice) hasSensor(name string) bool {
 	for _, sensor := range d.Sensors {
 		if sensor.Name == name {
@@ -268,7 +281,7 @@ func (d *GenericDevice) GetMessageHandler(_ chan core.SwitchRequest, _ DeviceInt
 		}
 
 		for _, sensor := range d.Sensors {
-			if value, ok := data[sensor.Name]; ok {
+			if value, ok := lookupValue(data, sensor.Name); ok {
 				log.Printf("[%32s] Received new %s: %v (%T)\n", d.Name, sensor.Name, value, value)
 				d.setSensorValue(sensor.Name, value)
 			}
@@ -276,6 +289,23 @@ func (d *GenericDevice) GetMessageHandler(_ chan core.SwitchRequest, _ DeviceInt
 	}
 }
 
+// lookupValue finds a key in a message. Nested values like Tasmota's {"AM2301":{"Temperature":21.5}}
+// are referenced as AM2301.Temperature.
+func lookupValue(data map[string]any, key string) (any, bool) {
+	if value, ok := data[key]; ok {
+		return value, true
+	}
+	parent, child, found := strings.Cut(key, ".")
+	if !found {
+		return nil, false
+	}
+	nested, ok := data[parent].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return lookupValue(nested, child)
+}
+
 func (d *GenericDevice) addHistory(field string, value any) {
 	d.mutex.Lock()
 	d.Values[field].History = append(d.Values[field].History, SensorHistory{Time: time.Now(), Value: value})
@@ -294,7 +324,7 @@ func (d *GenericDevice) UpdateValue() (float64, bool) {
 	return 0, false
 }
 
-func (d *GenericDevice) ProcessRequest(request core.SwitchRequest) {
+func (d *GenericDevice) ProcessRequest(request core.SwitchRequest) error {
 	if request.Key == "" {
 		if len(d.Controls) > 0 {
 			request.Key = d.Controls[0].Name
@@ -302,11 +332,10 @@ func (d *GenericDevice) ProcessRequest(request core.SwitchRequest) {
 		log.Printf("[%32s] Warning: No key specified in request. Defaulting to %s\n", d.Name, request.Key)
 	}
 
-	if request.Value[0] == '+' || request.Value[0] == '-' {
+	if request.Value != "" && (request.Value[0] == '+' || request.Value[0] == '-') {
 		value, err := strconv.ParseFloat(request.Value, 64)
 		if err != nil {
-			log.Printf("[%32s] Can't convert %s to number: %s\n", d.Name, request.Value, err.Error())
-			return
+			return fmt.Errorf("can't convert %s to number: %w", request.Value, err)
 		}
 		currentValueRaw := d.getControlValue(request.Key)
 		if currentValueRaw == nil {
@@ -314,12 +343,12 @@ func (d *GenericDevice) ProcessRequest(request core.SwitchRequest) {
 		}
 		currentValue, err := strconv.ParseFloat(fmt.Sprintf("%v", currentValueRaw), 64)
 		if err != nil {
-			log.Printf("[%32s] Can't convert to number: %s\n", d.Name, err.Error())
-			return
+			return fmt.Errorf("can't convert %v to number: %w", currentValueRaw, err)
 		}
 		request.Value = fmt.Sprintf("%d", int(currentValue+value))
 	}
 	d.setControlValue(request.Key, request.Value, true)
+	return nil
 }
 
 func (d *GenericDevice) PublishValue(client mqtt.Client) {
@@ -444,5 +473,10 @@ func (d *GenericDevice) GetConfig(name string) core.DeviceConfig {
 			Sensors:  &sensors,
 		},
 	}
+	if d.discovery != nil {
+		config.Options.AvailabilityTopic = d.discovery.AvailabilityTopic
+		config.Options.StateTopic = d.discovery.StateTopic
+		config.Options.Protocol = d.discovery.Protocol
+	}
 	return config
 }
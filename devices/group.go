@@ -1,6 +1,8 @@
 package devices
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -8,11 +10,36 @@ import (
 	"time"
 
 	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	AggregateMax     = "max"
+	AggregateMin     = "min"
+	AggregateAverage = "average"
+	AggregateAnyOn   = "any_on"
 )
 
 type Group struct {
 	Dimmable
-	devices []DeviceInterface
+	devices   []DeviceInterface
+	aggregate string
+
+	anyOn bool
+	allOn bool
+
+	// groupTopic is the topic of a zigbee2mqtt group containing all members. If set, the group
+	// is switched with a single message instead of one message per member.
+	groupTopic    string
+	scenes        map[string]int
+	nextScene     *int
+	nextColorTemp *int
+}
+
+// groupFollower is implemented by devices that can adopt a value that was sent to them through
+// a zigbee2mqtt group, without publishing it again.
+type groupFollower interface {
+	followGroup(float64)
 }
 
 func NewGroup(config core.DeviceConfig, allDevices map[string]DeviceInterface) *Group {
@@ -35,32 +62,56 @@ func NewGroup(config core.DeviceConfig, allDevices map[string]DeviceInterface) *
 		return nil
 	}
 
-	g.devices = make([]DeviceInterface, len(*config.Options.Devices))
+	g.aggregate = AggregateMax
+	if config.Options.Aggregate != nil {
+		switch *config.Options.Aggregate {
+		case AggregateMax, AggregateMin, AggregateAverage, AggregateAnyOn:
+			g.aggregate = *config.Options.Aggregate
+		default:
+			log.Printf("[%32s] Unknown aggregate mode %s, falling back to %s\n", config.Name, *config.Options.Aggregate, AggregateMax)
+		}
+	}
+
+	g.devices = make([]DeviceInterface, 0, len(*config.Options.Devices))
 
-	i := 0
 	for _, key := range *config.Options.Devices {
-		_, ok := allDevices[key]
-		if ok {
-			dev, ok := allDevices[key]
-			if ok {
-				if g.Type == "" {
-					g.Type = dev.GetType()
-					g.Icon = dev.GetEmoji()
-					g.MqttState = dev.GetMqttStateTopic()
-					g.Receivers = dev.GetReceivers()
-				} else if g.Type != dev.GetType() {
-					log.Println("Can't add Device " + key + " to group, as it is of a different type than the other devices in the group")
-					fmt.Printf("%s != %s", g.Type, dev.GetType())
-					return nil
-				}
-				g.devices[i] = dev
-				i++
-			}
-		} else {
+		dev, ok := allDevices[key]
+		if !ok {
 			fmt.Println("Could not find Device " + key + ", as part of a group")
+			continue
+		}
+		if member, ok := dev.(*Group); ok && (member.GetName() == config.Name || member.Contains(config.Name)) {
+			log.Println("Can't add group " + key + " to group " + config.Name + ", as it would create a cycle")
+			continue
 		}
+		if g.Type == "" {
+			g.Type = dev.GetType()
+			g.Icon = dev.GetEmoji()
+			g.MqttState = dev.GetMqttStateTopic()
+		} else if g.Type != dev.GetType() {
+			// Mixed groups are controlled like a light; binary members are switched on for any brightness
+			g.Type = "light"
+		}
+		g.devices = append(g.devices, dev)
 	}
 
+	g.Receivers = []string{"brightness", "duration", "easing", "color_temp"}
+	g.Triggers = []string{"brightness", "any_on", "all_on"}
+	g.Adaptive = newAdaptive(config.Name, config.Options)
+	if g.Adaptive != nil {
+		g.Receivers = append(g.Receivers, "adaptive")
+	}
+
+	if config.Options.GroupTopic != nil {
+		g.groupTopic = *config.Options.GroupTopic
+		g.MqttTopic = g.groupTopic
+		g.Receivers = append(g.Receivers, "scene_recall")
+	}
+	if config.Options.Scenes != nil {
+		g.scenes = *config.Options.Scenes
+	}
+	g.persistentFields = []string{"brightness", "value", "any_on", "all_on"}
+
 	g.init()
 
 	log.Printf("[%32s] Created group with %d devices\n", config.Name, len(g.devices))
@@ -68,15 +119,235 @@ func NewGroup(config core.DeviceConfig, allDevices map[string]DeviceInterface) *
 	return &g
 }
 
-func (g *Group) GetCurrent() float64 {
-	var current float64 = 0
+// NewGroups creates all groups in configs and adds them to allDevices. Groups may reference
+// other groups regardless of their order in the config, groups that form a cycle are skipped.
+func NewGroups(configs []core.DeviceConfig, allDevices map[string]DeviceInterface) []*Group {
+	groupConfigs := make(map[string]core.DeviceConfig)
+	for _, config := range configs {
+		if config.Type == "group" {
+			groupConfigs[config.Name] = config
+		}
+	}
+
+	var groups []*Group
+	visiting := make(map[string]bool)
+	done := make(map[string]bool)
+
+	var build func(name string) bool
+	build = func(name string) bool {
+		if done[name] {
+			_, ok := allDevices[name]
+			return ok
+		}
+		if visiting[name] {
+			log.Println("Group " + name + " is part of a cycle")
+			return false
+		}
+		visiting[name] = true
+		config := groupConfigs[name]
+		if config.Options != nil && config.Options.Devices != nil {
+			for _, member := range *config.Options.Devices {
+				if _, isGroup := groupConfigs[member]; isGroup && !build(member) {
+					visiting[name] = false
+					done[name] = true
+					return false
+				}
+			}
+		}
+		visiting[name] = false
+		done[name] = true
+
+		group := NewGroup(config, allDevices)
+		if group == nil {
+			return false
+		}
+		allDevices[name] = group
+		groups = append(groups, group)
+		return true
+	}
+
+	for _, config := range configs {
+		if config.Type == "group" {
+			build(config.Name)
+		}
+	}
+	return groups
+}
+
+// Contains reports whether the named device is a member of this group or of any nested group.
+func (g *Group) Contains(name string) bool {
 	for _, d := range g.devices {
-		current = math.Max(d.GetCurrent(), current)
+		if d.GetName() == name {
+			return true
+		}
+		if member, ok := d.(*Group); ok && member.Contains(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Group) GetDevices() []DeviceInterface {
+	return g.devices
+}
+
+// memberPercentage returns the member's current value on a 0-100 scale, so that plugs and lights can be compared.
+func memberPercentage(d DeviceInterface) float64 {
+	if isBinary(d) {
+		return d.GetCurrent() * 100
 	}
-	g.SetCurrent(current)
+	return d.GetCurrent()
+}
+
+// isBinary tells whether a member is switched on and off with 1 and 0. Groups of plugs take their type from their
+// first member, but report a percentage and map brightness to their plugs themselves.
+func isBinary(d DeviceInterface) bool {
+	if _, ok := d.(*Group); ok {
+		return false
+	}
+	return d.GetType() == "plug"
+}
+
+func (g *Group) aggregateCurrent() (current float64, anyOn bool, allOn bool) {
+	if len(g.devices) == 0 {
+		return 0, false, false
+	}
+	allOn = true
+	var sum float64
+	for i, d := range g.devices {
+		value := memberPercentage(d)
+		if value > 0 {
+			anyOn = true
+		} else {
+			allOn = false
+		}
+		sum += value
+		switch g.aggregate {
+		case AggregateMin:
+			if i == 0 {
+				current = value
+			}
+			current = math.Min(current, value)
+		default:
+			current = math.Max(current, value)
+		}
+	}
+	switch g.aggregate {
+	case AggregateAverage:
+		current = sum / float64(len(g.devices))
+	case AggregateAnyOn:
+		current = 0
+		if anyOn {
+			current = 100
+		}
+	}
+	return current, anyOn, allOn
+}
+
+func (g *Group) GetCurrent() float64 {
+	current, _, _ := g.aggregateCurrent()
 	return current
 }
 
+func (g *Group) enableAdaptive(sun *DimmyTime) {
+	if g.Adaptive == nil {
+		return
+	}
+	g.Adaptive.sun = sun
+	g.Adaptive.apply = func(brightness float64, colorTemp int, duration int) {
+		g.switchTo(core.SwitchRequest{Key: "color_temp", Value: strconv.Itoa(colorTemp)})
+		g.switchTo(core.SwitchRequest{Value: fmt.Sprintf("%f", brightness), Duration: duration})
+	}
+}
+
+func (g *Group) UpdateValue() (float64, bool) {
+	if g.groupTopic != "" {
+		g.Adaptive.update(g.Device.GetCurrent(), g.GetTarget())
+	} else {
+		// members fade on their own, so the group itself is never in the middle of a fade
+		g.Adaptive.update(g.GetTarget(), g.GetTarget())
+	}
+	current, anyOn, allOn := g.aggregateCurrent()
+	if anyOn != g.anyOn {
+		g.anyOn = anyOn
+		g.UpdateRules("any_on", anyOn)
+	}
+	if allOn != g.allOn {
+		g.allOn = allOn
+		g.UpdateRules("all_on", allOn)
+	}
+
+	if g.groupTopic != "" {
+		if g.nextScene != nil || g.nextColorTemp != nil {
+			return g.Device.GetCurrent(), true
+		}
+		if g.Device.GetCurrent() != g.GetTarget() {
+			value, ok := g.Dimmable.UpdateValue()
+			if ok {
+				g.UpdateRules("brightness", value)
+			}
+			return value, ok
+		}
+		// Not fading, so adopt whatever the members reported on their own state topics
+		if current != g.Device.GetCurrent() {
+			g.setTarget(current)
+			g.SetCurrent(current)
+			g.LastSent = groupBrightness(current)
+			g.UpdateRules("brightness", current)
+		}
+		return current, false
+	}
+
+	if current != g.Device.GetCurrent() {
+		g.SetCurrent(current)
+		g.UpdateRules("brightness", current)
+	}
+	// Members publish their own values
+	return current, false
+}
+
+func (g *Group) PublishValue(client mqtt.Client) {
+	if g.groupTopic == "" {
+		return
+	}
+	if g.nextScene != nil {
+		s, _ := json.Marshal(core.Zigbee2MqttSceneMessage{SceneRecall: *g.nextScene})
+		log.Printf("[%32s] Recalling scene %d\n", g.GetName(), *g.nextScene)
+		client.Publish(g.groupTopic+"/set", 0, false, s)
+		g.nextScene = nil
+		// Members report their new state after the scene has been recalled
+		g.setTarget(g.Device.GetCurrent())
+		return
+	}
+
+	current := g.Device.GetCurrent()
+	newVal := groupBrightness(current)
+	colorTemp := g.nextColorTemp
+	g.nextColorTemp = nil
+	if newVal == g.LastSent && colorTemp == nil {
+		return
+	}
+	g.LastSent = newVal
+
+	state := "OFF"
+	if newVal > 0 {
+		state = "ON"
+	}
+	s, _ := json.Marshal(core.Zigbee2MqttLightMessage{State: &state, Brightness: &newVal, ColorTemp: colorTemp})
+	client.Publish(g.groupTopic+"/set", 0, false, s)
+
+	for _, d := range g.devices {
+		if follower, ok := d.(groupFollower); ok {
+			follower.followGroup(current)
+		}
+	}
+}
+
+// groupBrightness converts a percentage to the brightness range of zigbee2mqtt groups
+func groupBrightness(percentage float64) int {
+	return int(math.Round(percentage * 254 / 100))
+}
+
 func (g *Group) GetMax() int {
 	return 100
 }
@@ -85,15 +356,106 @@ func (g *Group) GetMin() int {
 	return 0
 }
 
-func (g *Group) ProcessRequest(request core.SwitchRequest) {
+func (g *Group) ProcessRequest(request core.SwitchRequest) error {
+	if request.Key == "adaptive" {
+		g.Adaptive.setEnabled(request.Value)
+		return nil
+	}
+	g.Adaptive.manualChange(switchesOn(g.GetTarget(), request))
+	return g.switchTo(request)
+}
+
+// switchTo switches the group and its members, it returns the errors of members that failed
+func (g *Group) switchTo(request core.SwitchRequest) error {
+	if request.Key == "scene_recall" {
+		return g.recallScene(request.Value)
+	}
+	if request.Key == "color_temp" {
+		return g.setColorTemp(request)
+	}
 	if len(request.Value) > 0 && (request.Value[0] == '+' || request.Value[0] == '-') {
 		value, err := strconv.ParseFloat(request.Value, 64)
 		if err == nil {
 			request.Value = fmt.Sprintf("%f", g.GetCurrent()+value)
 		}
 	}
+	if g.groupTopic != "" {
+		return g.Dimmable.ProcessRequest(request)
+	}
+	err := g.switchMembers(request)
+	g.setTarget(g.GetCurrent())
+	if value, err := strconv.ParseFloat(request.Value, 64); err == nil {
+		g.setTarget(math.Max(math.Min(value, 100), 0))
+	}
+	return err
+}
+
+func (g *Group) switchMembers(request core.SwitchRequest) error {
+	var errs []error
 	for _, d := range g.devices {
-		d.ProcessRequest(request)
+		if memberRequest, ok := memberRequest(d, request); ok {
+			if err := d.ProcessRequest(memberRequest); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", d.GetName(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g *Group) recallScene(value string) error {
+	if g.groupTopic == "" {
+		return fmt.Errorf("can't recall scene %s, group has no group_topic", value)
+	}
+	if id, ok := g.scenes[value]; ok {
+		g.nextScene = &id
+		return nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("unknown scene %s", value)
+	}
+	g.nextScene = &id
+	return nil
+}
+
+func (g *Group) GetScenes() []string {
+	var scenes []string
+	for name := range g.scenes {
+		scenes = append(scenes, name)
+	}
+	return scenes
+}
+
+// memberRequest translates a group request for the given member. Binary members are switched
+// on for any brightness above zero and ignore other keys.
+func memberRequest(d DeviceInterface, request core.SwitchRequest) (core.SwitchRequest, bool) {
+	if !isBinary(d) {
+		return request, true
+	}
+	if request.Key != "" && request.Key != "brightness" && request.Key != "state" {
+		return request, false
+	}
+	value, err := strconv.ParseFloat(request.Value, 64)
+	if err != nil {
+		return request, true
+	}
+	request.Key = "state"
+	if value > 0 {
+		request.Value = "1"
+	} else {
+		request.Value = "0"
+	}
+	return request, true
+}
+
+func (g *Group) setColorTemp(request core.SwitchRequest) error {
+	if g.groupTopic == "" {
+		return g.switchMembers(request)
+	}
+	colorTemp, err := strconv.Atoi(request.Value)
+	if err != nil {
+		return fmt.Errorf("invalid colour temperature %s", request.Value)
 	}
-	g.ProcessRequestChild(request)
+	g.nextColorTemp = &colorTemp
+	return nil
 }
@@ -25,10 +25,13 @@ func newMockDevice(name, deviceType string, current float64) *mockDevice {
 	return d
 }
 
-func (m *mockDevice) GetType() string                      { return m.deviceType }
-func (m *mockDevice) GetCurrent() float64                  { return m.current }
-func (m *mockDevice) UpdateValue() (float64, bool)         { return m.current, false }
-func (m *mockDevice) ProcessRequest(r core.SwitchRequest)  { m.requests = append(m.requests, r) }
+func (m *mockDevice) GetType() string              { return m.deviceType }
+func (m *mockDevice) GetCurrent() float64          { return m.current }
+func (m *mockDevice) UpdateValue() (float64, bool) { return m.current, false }
+func (m *mockDevice) ProcessRequest(r core.SwitchRequest) error {
+	m.requests = append(m.requests, r)
+	return nil
+}
 
 // groupConfig builds a DeviceConfig that lists the given device names under options.devices.
 func groupConfig(t *testing.T, deviceNames []string) core.DeviceConfig {
@@ -63,14 +66,20 @@ func TestNewGroup_NoDevices_ReturnsNil(t *testing.T) {
 	}
 }
 
-func TestNewGroup_MixedDeviceTypes_ReturnsNil(t *testing.T) {
+func TestNewGroup_MixedDeviceTypes(t *testing.T) {
 	allDevices := map[string]DeviceInterface{
 		"dev1": newMockDevice("dev1", "light", 0),
-		"dev2": newMockDevice("dev2", "blind", 0),
+		"dev2": newMockDevice("dev2", "plug", 0),
 	}
 	g := NewGroup(groupConfig(t, []string{"dev1", "dev2"}), allDevices)
-	if g != nil {
-		t.Error("expected nil when devices have different types")
+	if g == nil {
+		t.Fatal("expected non-nil group for mixed device types")
+	}
+	if g.Type != "light" {
+		t.Errorf("expected Type=light, got %s", g.Type)
+	}
+	if len(g.devices) != 2 {
+		t.Errorf("expected 2 devices, got %d", len(g.devices))
 	}
 }
 
@@ -144,3 +153,182 @@ func TestGroup_ProcessRequest_RelativeResolvesBeforeDelegating(t *testing.T) {
 		t.Errorf("expected resolved value=85, got %f", val)
 	}
 }
+
+func TestGroup_ProcessRequest_MapsBrightnessToPlugState(t *testing.T) {
+	light := newMockDevice("light", "light", 0)
+	plug := newMockDevice("plug", "plug", 0)
+	g := NewGroup(groupConfig(t, []string{"light", "plug"}), map[string]DeviceInterface{"light": light, "plug": plug})
+
+	g.ProcessRequest(core.SwitchRequest{Value: "40"})
+	g.ProcessRequest(core.SwitchRequest{Value: "0"})
+
+	if light.requests[0].Value != "40" {
+		t.Errorf("expected light Value=40, got %s", light.requests[0].Value)
+	}
+	if plug.requests[0].Value != "1" || plug.requests[1].Value != "0" {
+		t.Errorf("expected plug values 1 and 0, got %s and %s", plug.requests[0].Value, plug.requests[1].Value)
+	}
+}
+
+func TestGroup_GetCurrent_AggregateModes(t *testing.T) {
+	for mode, expected := range map[string]float64{
+		AggregateMax:     100,
+		AggregateMin:     0,
+		AggregateAverage: 50,
+		AggregateAnyOn:   100,
+	} {
+		allDevices := map[string]DeviceInterface{
+			"dev1": newMockDevice("dev1", "light", 0),
+			"dev2": newMockDevice("dev2", "light", 50),
+			"dev3": newMockDevice("dev3", "plug", 1),
+		}
+		cfg := groupConfig(t, []string{"dev1", "dev2", "dev3"})
+		cfg.Options.Aggregate = core.ToPtr(mode)
+		g := NewGroup(cfg, allDevices)
+		if g.GetCurrent() != expected {
+			t.Errorf("expected GetCurrent=%f for %s, got %f", expected, mode, g.GetCurrent())
+		}
+	}
+}
+
+func TestGroup_UpdateValue_UpdatesAnyOnTrigger(t *testing.T) {
+	dev1 := newMockDevice("dev1", "light", 0)
+	dev2 := newMockDevice("dev2", "light", 0)
+	g := NewGroup(groupConfig(t, []string{"dev1", "dev2"}), map[string]DeviceInterface{"dev1": dev1, "dev2": dev2})
+	rule := NewRule(core.RuleConfig{
+		Triggers: []core.TriggerConfig{{DeviceName: "test-group", Key: "any_on", Condition: core.ReceiverConditionConfig{Operator: "==", Value: true}}},
+	}, map[string]DeviceInterface{"test-group": g})
+
+	g.UpdateValue()
+	if rule.CheckTriggers() {
+		t.Error("expected any_on trigger not to match while all devices are off")
+	}
+
+	dev2.current = 20
+	g.UpdateValue()
+	if !rule.CheckTriggers() {
+		t.Error("expected any_on trigger to match once a device is on")
+	}
+}
+
+func TestNewGroups_NestedGroupsInAnyOrder(t *testing.T) {
+	allDevices := map[string]DeviceInterface{
+		"dev1": newMockDevice("dev1", "light", 30),
+		"dev2": newMockDevice("dev2", "light", 60),
+	}
+	outer := groupConfig(t, []string{"inner", "dev2"})
+	outer.Name = "outer"
+	outer.Type = "group"
+	inner := groupConfig(t, []string{"dev1"})
+	inner.Name = "inner"
+	inner.Type = "group"
+
+	groups := NewGroups([]core.DeviceConfig{outer, inner}, allDevices)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+	g := allDevices["outer"].(*Group)
+	if !g.Contains("dev1") {
+		t.Error("expected outer group to contain dev1 through the nested group")
+	}
+	if g.GetCurrent() != 60 {
+		t.Errorf("expected GetCurrent=60, got %f", g.GetCurrent())
+	}
+}
+
+func TestNewGroups_NestedPlugGroup(t *testing.T) {
+	plug1 := newMockDevice("plug1", "plug", 1)
+	allDevices := map[string]DeviceInterface{
+		"plug1": plug1,
+		"plug2": newMockDevice("plug2", "plug", 0),
+		"dev1":  newMockDevice("dev1", "light", 30),
+	}
+	outer := groupConfig(t, []string{"plugs", "dev1"})
+	outer.Name = "outer"
+	outer.Type = "group"
+	plugs := groupConfig(t, []string{"plug1", "plug2"})
+	plugs.Name = "plugs"
+	plugs.Type = "group"
+
+	NewGroups([]core.DeviceConfig{outer, plugs}, allDevices)
+	if allDevices["plugs"].GetCurrent() != 100 {
+		t.Errorf("expected plug group GetCurrent=100, got %f", allDevices["plugs"].GetCurrent())
+	}
+	if allDevices["outer"].GetCurrent() != 100 {
+		t.Errorf("expected outer GetCurrent=100, got %f", allDevices["outer"].GetCurrent())
+	}
+
+	allDevices["outer"].ProcessRequest(core.SwitchRequest{Value: "40"})
+	if plug1.requests[0].Value != "1" {
+		t.Errorf("expected nested plug Value=1, got %s", plug1.requests[0].Value)
+	}
+}
+
+func TestNewGroups_CycleIsSkipped(t *testing.T) {
+	allDevices := map[string]DeviceInterface{
+		"dev1": newMockDevice("dev1", "light", 0),
+	}
+	a := groupConfig(t, []string{"b", "dev1"})
+	a.Name = "a"
+	a.Type = "group"
+	b := groupConfig(t, []string{"a"})
+	b.Name = "b"
+	b.Type = "group"
+
+	groups := NewGroups([]core.DeviceConfig{a, b}, allDevices)
+	if len(groups) != 0 {
+		t.Errorf("expected no groups to be created for a cycle, got %d", len(groups))
+	}
+	if _, ok := allDevices["a"]; ok {
+		t.Error("expected group a to be skipped")
+	}
+}
+
+func TestGroup_GroupTopic_PublishesOnceForAllMembers(t *testing.T) {
+	light1 := newTestLight()
+	light2 := newTestLight()
+	cfg := groupConfig(t, []string{"light1", "light2"})
+	cfg.Options.GroupTopic = core.ToPtr("zigbee2mqtt/livingroom")
+	g := NewGroup(cfg, map[string]DeviceInterface{"light1": light1, "light2": light2})
+	client := &mockClient{}
+
+	g.ProcessRequest(core.SwitchRequest{Value: "50"})
+	if _, ok := g.UpdateValue(); !ok {
+		t.Fatal("expected group to publish its new value")
+	}
+	g.PublishValue(client)
+
+	if len(client.published) != 1 {
+		t.Fatalf("expected exactly one message, got %d", len(client.published))
+	}
+	if client.published[0].topic != "zigbee2mqtt/livingroom/set" {
+		t.Errorf("expected group set topic, got %s", client.published[0].topic)
+	}
+	if client.published[0].payload != `{"update":{},"state":"ON","brightness":127}` {
+		t.Errorf("unexpected payload %s", client.published[0].payload)
+	}
+	if light1.GetCurrent() != 50 || light1.GetTarget() != 50 {
+		t.Errorf("expected member to follow the group, got current=%f target=%f", light1.GetCurrent(), light1.GetTarget())
+	}
+	if _, ok := light1.UpdateValue(); ok {
+		t.Error("expected member not to publish on its own")
+	}
+}
+
+func TestGroup_SceneRecall(t *testing.T) {
+	cfg := groupConfig(t, []string{"dev1"})
+	cfg.Options.GroupTopic = core.ToPtr("zigbee2mqtt/livingroom")
+	cfg.Options.Scenes = &map[string]int{"movie": 3}
+	g := NewGroup(cfg, map[string]DeviceInterface{"dev1": newMockDevice("dev1", "light", 0)})
+	client := &mockClient{}
+
+	g.ProcessRequest(core.SwitchRequest{Key: "scene_recall", Value: "movie"})
+	if _, ok := g.UpdateValue(); !ok {
+		t.Fatal("expected pending scene to be published")
+	}
+	g.PublishValue(client)
+
+	if len(client.published) != 1 || client.published[0].payload != `{"scene_recall":3}` {
+		t.Errorf("unexpected messages %v", client.published)
+	}
+}
@@ -0,0 +1,171 @@
+package devices
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+const (
+	defaultBatteryLow = 20 // percent
+	defaultWeakSignal = 30 // zigbee link quality, 0-255
+
+	// one link quality sample per interval is kept, covering the last day
+	linkQualityInterval = 30 * time.Minute
+	linkQualitySamples  = 48
+)
+
+type HealthSample struct {
+	Time  time.Time `json:"time"`
+	Value int       `json:"value"`
+}
+
+// Health is a device's entry in the fleet view
+type Health struct {
+	Name               string         `json:"name"`
+	Label              string         `json:"label"`
+	Type               string         `json:"type"`
+	Battery            *int           `json:"battery,omitempty"`
+	LowBattery         bool           `json:"low_battery"`
+	LinkQuality        *int           `json:"linkquality,omitempty"`
+	WeakSignal         bool           `json:"weak_signal"`
+	LinkQualityHistory []HealthSample `json:"linkquality_history,omitempty"`
+	LastSeen           *time.Time     `json:"last_seen,omitempty"`
+	Availability       string         `json:"availability,omitempty"`
+}
+
+func (h Health) HasProblem() bool {
+	return h.LowBattery || h.WeakSignal || h.Availability == AvailabilityOffline
+}
+
+// Sparkline renders the link quality history as block characters
+func (h Health) Sparkline() string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	var line strings.Builder
+	for _, sample := range h.LinkQualityHistory {
+		i := sample.Value * len(blocks) / 256
+		line.WriteRune(blocks[max(0, min(i, len(blocks)-1))])
+	}
+	return line.String()
+}
+
+func (d *Device) setHealthThresholds(config core.DeviceConfig) {
+	d.batteryLow = defaultBatteryLow
+	d.weakSignal = defaultWeakSignal
+	if config.Options == nil {
+		return
+	}
+	if config.Options.BatteryLow != nil {
+		d.batteryLow = *config.Options.BatteryLow
+	}
+	if config.Options.WeakSignal != nil {
+		d.weakSignal = *config.Options.WeakSignal
+	}
+}
+
+func (d *Device) setBatteryLevel(battery *int) {
+	if battery == nil {
+		return
+	}
+	level := *battery
+	low := level <= d.batteryLow
+	d.mutex.Lock()
+	changed := d.Battery == nil || d.lowBattery != low
+	d.Battery = &level
+	d.lowBattery = low
+	d.mutex.Unlock()
+
+	d.UpdateRules("battery", level)
+	if changed {
+		if low {
+			log.Printf("[%32s] Battery low (%d%%)\n", d.Name, level)
+		}
+		d.UpdateRules("low_battery", low)
+	}
+}
+
+func (d *Device) setLinkQuality(linkQuality *int) {
+	if linkQuality == nil {
+		return
+	}
+	quality := *linkQuality
+	weak := quality <= d.weakSignal
+	now := time.Now()
+	d.mutex.Lock()
+	changed := d.LinkQuality == nil || d.weakSignalDetected != weak
+	d.LinkQuality = &quality
+	d.weakSignalDetected = weak
+	if n := len(d.linkQualityHistory); n == 0 || now.Sub(d.linkQualityHistory[n-1].Time) >= linkQualityInterval {
+		d.linkQualityHistory = append(d.linkQualityHistory, HealthSample{Time: now, Value: quality})
+		if len(d.linkQualityHistory) > linkQualitySamples {
+			d.linkQualityHistory = d.linkQualityHistory[1:]
+		}
+	}
+	d.mutex.Unlock()
+
+	d.UpdateRules("linkquality", quality)
+	if changed {
+		if weak {
+			log.Printf("[%32s] Weak signal (link quality %d)\n", d.Name, quality)
+		}
+		d.UpdateRules("weak_signal", weak)
+	}
+}
+
+// healthTriggers are only offered once the device has reported the corresponding value
+func (d *Device) healthTriggers() []string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	var triggers []string
+	if d.Battery != nil {
+		triggers = append(triggers, "battery", "low_battery")
+	}
+	if d.LinkQuality != nil {
+		triggers = append(triggers, "linkquality", "weak_signal")
+	}
+	return triggers
+}
+
+func isHealthField(field string) bool {
+	switch field {
+	case "battery", "low_battery", "linkquality", "weak_signal", "availability":
+		return true
+	}
+	return false
+}
+
+func (d *Device) GetHealth() Health {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return Health{
+		Name:               d.Name,
+		Label:              d.GetLabel(),
+		Type:               d.Type,
+		Battery:            d.Battery,
+		LowBattery:         d.lowBattery,
+		LinkQuality:        d.LinkQuality,
+		WeakSignal:         d.weakSignalDetected,
+		LinkQualityHistory: append([]HealthSample(nil), d.linkQualityHistory...),
+		LastSeen:           d.lastSeen,
+		Availability:       d.Availability,
+	}
+}
+
+// intValue accepts the float64 the JSON decoder produces as well as plain integers
+func intValue(value any) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err == nil
+	}
+	return 0, false
+}
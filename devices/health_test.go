@@ -0,0 +1,63 @@
+package devices
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth_ParsesJsonNumbers(t *testing.T) {
+	device := NewDevice(core.DeviceConfig{Name: "sensor", Topic: "zigbee/sensor"})
+	handler := device.GetMessageHandler(nil, device)
+	handler(nil, &mockMessage{payload: []byte(`{"battery":87,"linkquality":120}`)})
+
+	health := device.GetHealth()
+	assert.Equal(t, 87, *health.Battery)
+	assert.Equal(t, 120, *health.LinkQuality)
+	assert.False(t, health.LowBattery)
+	assert.False(t, health.WeakSignal)
+	assert.Subset(t, device.GetTriggers(), []string{"battery", "low_battery", "linkquality", "weak_signal"})
+}
+
+func TestHealth_Thresholds(t *testing.T) {
+	device := NewDevice(core.DeviceConfig{Name: "sensor", Topic: "zigbee/sensor", Options: &core.ConfigOptions{BatteryLow: core.ToPtr(15)}})
+	rule := &Rule{Triggers: []Trigger{
+		{Device: device, Key: "low_battery", Condition: &condition{}},
+		{Device: device, Key: "weak_signal", Condition: &condition{}},
+	}}
+	device.AddRule(rule)
+
+	device.parseDefaultValues(map[string]any{"battery": 16.0, "linkquality": 31.0})
+	assert.Equal(t, false, rule.Triggers[0].Condition.LastValue)
+	assert.Equal(t, false, rule.Triggers[1].Condition.LastValue)
+
+	device.parseDefaultValues(map[string]any{"battery": 15.0, "linkquality": 30.0})
+	assert.Equal(t, true, rule.Triggers[0].Condition.LastValue)
+	assert.Equal(t, true, rule.Triggers[1].Condition.LastValue)
+	assert.True(t, device.GetHealth().HasProblem())
+	assert.True(t, device.IsPersistent("low_battery"))
+}
+
+func TestHealth_LinkQualityHistory(t *testing.T) {
+	device := NewDevice(core.DeviceConfig{Name: "sensor", Topic: "zigbee/sensor"})
+	device.parseDefaultValues(map[string]any{"linkquality": 255.0})
+	device.parseDefaultValues(map[string]any{"linkquality": 100.0})
+	assert.Len(t, device.GetHealth().LinkQualityHistory, 1, "samples are taken once per interval")
+
+	device.linkQualityHistory[0].Time = time.Now().Add(-linkQualityInterval)
+	device.parseDefaultValues(map[string]any{"linkquality": 0.0})
+	health := device.GetHealth()
+	assert.Len(t, health.LinkQualityHistory, 2)
+	assert.Equal(t, "█▁", health.Sparkline())
+}
+
+func TestHealth_LastSeen(t *testing.T) {
+	device := NewDevice(core.DeviceConfig{Name: "sensor", Topic: "zigbee/sensor"})
+	assert.Nil(t, device.GetHealth().LastSeen)
+	device.MessageReceived(true)
+	assert.Nil(t, device.GetHealth().LastSeen)
+	device.MessageReceived(false)
+	assert.NotNil(t, device.GetHealth().LastSeen)
+}
@@ -0,0 +1,405 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+const (
+	HelperBoolean = "input_boolean"
+	HelperNumber  = "input_number"
+	HelperSelect  = "input_select"
+	HelperCounter = "counter"
+	HelperTimer   = "timer"
+)
+
+// PersistentDevice is implemented by devices whose state is kept across restarts
+type PersistentDevice interface {
+	GetState() any
+	RestoreState(json.RawMessage) error
+}
+
+// Helper is a virtual device that only stores state, so rules can use flags, numbers and timers
+type Helper struct {
+	Device
+
+	Value     any      `json:"state"`
+	Display   string   `json:"display"`
+	Values    []string `json:"values,omitempty"`
+	Remaining int      `json:"remaining,omitempty"`
+
+	min      float64
+	max      float64
+	step     float64
+	initial  string
+	duration int
+	endsAt   time.Time
+}
+
+type helperState struct {
+	Value  any        `json:"value"`
+	EndsAt *time.Time `json:"endsAt,omitempty"`
+}
+
+func IsHelperType(deviceType string) bool {
+	switch deviceType {
+	case HelperBoolean, HelperNumber, HelperSelect, HelperCounter, HelperTimer:
+		return true
+	}
+	return false
+}
+
+func NewHelper(config core.DeviceConfig) *Helper {
+	h := Helper{}
+	h.setBaseConfig(config)
+	h.Type = config.Type
+	h.min = math.Inf(-1)
+	h.max = math.Inf(1)
+	h.step = 1
+	h.duration = 60
+
+	var c core.HelperConfig
+	if config.Options != nil && config.Options.Helper != nil {
+		c = *config.Options.Helper
+	}
+	if c.Min != nil {
+		h.min = *c.Min
+	}
+	if c.Max != nil {
+		h.max = *c.Max
+	}
+	if c.Step != nil && *c.Step > 0 {
+		h.step = *c.Step
+	}
+	if c.Initial != nil {
+		h.initial = *c.Initial
+	}
+	if c.Duration != nil {
+		h.duration = *c.Duration
+	}
+	h.Values = c.Values
+
+	h.Receivers = []string{"value"}
+	h.Triggers = []string{"value"}
+	h.persistentFields = []string{"value"}
+	icon := ""
+	switch h.Type {
+	case HelperBoolean:
+		icon = "🔘"
+		h.Value = false
+	case HelperNumber:
+		icon = "🔢"
+		if math.IsInf(h.min, -1) {
+			h.min = 0
+		}
+		if math.IsInf(h.max, 1) {
+			h.max = 100
+		}
+		h.Value = h.min
+	case HelperSelect:
+		icon = "📋"
+		if len(h.Values) == 0 {
+			log.Printf("[%32s] input_select without values\n", h.Name)
+			h.Value = ""
+		} else {
+			h.Value = h.Values[0]
+		}
+	case HelperCounter:
+		icon = "🧮"
+		h.Value = 0.0
+	case HelperTimer:
+		icon = "⏲️"
+		h.Value = "idle"
+		h.Receivers = []string{"command", "duration"}
+		h.Triggers = []string{"state", "remaining", "finished"}
+		h.persistentFields = []string{"state", "remaining"}
+	}
+	if config.Icon == "" {
+		h.Icon = icon
+	}
+	if h.initial != "" && h.Type != HelperTimer {
+		if err := h.set(h.initial); err != nil {
+			log.Printf("[%32s] Invalid initial value %s: %s\n", h.Name, h.initial, err.Error())
+		}
+	}
+	h.updateDisplay()
+
+	return &h
+}
+
+func (h *Helper) ProcessRequest(request core.SwitchRequest) error {
+	var err error
+	if h.Type == HelperTimer {
+		err = h.timerCommand(request.Value, request.Duration)
+	} else {
+		err = h.set(request.Value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %s: %w", request.Value, err)
+	}
+	return nil
+}
+
+// set parses value for the helper's type. Numbers and counters accept increment, decrement and reset, counters
+// also relative values like "+1" or "-2", selects next and previous.
+func (h *Helper) set(value string) error {
+	h.mutex.Lock()
+	current := h.Value
+	var newValue any
+	var err error
+	switch h.Type {
+	case HelperBoolean:
+		newValue, err = parseHelperBool(value, current == true)
+	case HelperNumber, HelperCounter:
+		newValue, err = h.parseNumber(value, current.(float64))
+	case HelperSelect:
+		newValue, err = h.parseSelect(value, current.(string))
+	}
+	if err != nil {
+		h.mutex.Unlock()
+		return err
+	}
+	h.Value = newValue
+	h.updateDisplay()
+	now := time.Now()
+	h.LastChanged = &now
+	h.mutex.Unlock()
+
+	if newValue != current {
+		log.Printf("[%32s] Set to %v\n", h.Name, newValue)
+	}
+	h.UpdateRules("value", newValue)
+	return nil
+}
+
+func parseHelperBool(value string, current bool) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	case "toggle", "":
+		return !current, nil
+	}
+	return false, fmt.Errorf("not a boolean")
+}
+
+func (h *Helper) parseNumber(value string, current float64) (float64, error) {
+	var result float64
+	switch strings.ToLower(value) {
+	case "increment":
+		result = current + h.step
+	case "decrement":
+		result = current - h.step
+	case "reset":
+		result, _ = strconv.ParseFloat(h.initial, 64)
+	default:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return current, fmt.Errorf("not a number")
+		}
+		result = number
+		// numbers can be negative, so only counters count up and down by signed values
+		if h.Type == HelperCounter && (value[0] == '+' || value[0] == '-') {
+			result = current + number
+		}
+	}
+	if h.Type == HelperNumber {
+		result = h.min + math.Round((result-h.min)/h.step)*h.step
+	}
+	return math.Max(math.Min(result, h.max), h.min), nil
+}
+
+func (h *Helper) parseSelect(value string, current string) (string, error) {
+	if contains(h.Values, value) {
+		return value, nil
+	}
+	if len(h.Values) == 0 || (value != "next" && value != "previous") {
+		return current, fmt.Errorf("unknown option")
+	}
+	i := 0
+	for n, v := range h.Values {
+		if v == current {
+			i = n
+		}
+	}
+	if value == "next" {
+		i = (i + 1) % len(h.Values)
+	} else {
+		i = (i + len(h.Values) - 1) % len(h.Values)
+	}
+	return h.Values[i], nil
+}
+
+// timerCommand starts, cancels or finishes the timer. A start uses the given duration if it is set.
+func (h *Helper) timerCommand(command string, duration int) error {
+	switch command {
+	case "start", "":
+		if duration <= 0 {
+			duration = h.duration
+		}
+		h.mutex.Lock()
+		h.endsAt = time.Now().Add(time.Duration(duration) * time.Second)
+		h.Remaining = duration
+		h.Value = "active"
+		h.updateDisplay()
+		h.mutex.Unlock()
+		log.Printf("[%32s] Started for %ds\n", h.Name, duration)
+		h.UpdateRules("state", "active")
+		h.UpdateRules("remaining", duration)
+	case "cancel":
+		h.stopTimer(false)
+	case "finish":
+		h.stopTimer(true)
+	default:
+		return fmt.Errorf("unknown command")
+	}
+	return nil
+}
+
+func (h *Helper) stopTimer(finished bool) {
+	h.mutex.Lock()
+	if h.Value != "active" {
+		h.mutex.Unlock()
+		return
+	}
+	h.Value = "idle"
+	h.Remaining = 0
+	h.endsAt = time.Time{}
+	h.updateDisplay()
+	h.mutex.Unlock()
+	log.Printf("[%32s] Stopped (finished: %v)\n", h.Name, finished)
+	h.UpdateRules("state", "idle")
+	h.UpdateRules("remaining", 0)
+	if finished {
+		h.UpdateRules("finished", true)
+	}
+}
+
+// UpdateValue counts down an active timer. Helpers never publish anything.
+func (h *Helper) UpdateValue() (float64, bool) {
+	if h.Type != HelperTimer {
+		return 0, false
+	}
+	h.mutex.Lock()
+	if h.Value != "active" {
+		h.mutex.Unlock()
+		return 0, false
+	}
+	remaining := int(math.Ceil(time.Until(h.endsAt).Seconds()))
+	changed := remaining != h.Remaining
+	if changed && remaining > 0 {
+		h.Remaining = remaining
+		h.updateDisplay()
+	}
+	h.mutex.Unlock()
+
+	if remaining <= 0 {
+		h.stopTimer(true)
+	} else if changed {
+		h.UpdateRules("remaining", remaining)
+	}
+	return 0, false
+}
+
+func (h *Helper) updateDisplay() {
+	switch h.Type {
+	case HelperBoolean:
+		h.Display = "off"
+		if h.Value == true {
+			h.Display = "on"
+		}
+	case HelperNumber, HelperCounter:
+		h.Display = strconv.FormatFloat(h.Value.(float64), 'f', -1, 64)
+	case HelperTimer:
+		h.Display = h.Value.(string)
+		if h.Value == "active" {
+			h.Display = fmt.Sprintf("%d:%02d", h.Remaining/60, h.Remaining%60)
+		}
+	default:
+		h.Display = fmt.Sprintf("%v", h.Value)
+	}
+}
+
+func (h *Helper) GetValues() []string {
+	return h.Values
+}
+
+func (h *Helper) GetState() any {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	state := helperState{Value: h.Value}
+	if h.Value == "active" {
+		state.EndsAt = &h.endsAt
+	}
+	return state
+}
+
+// RestoreState sets the state saved before a restart. Timers that expired in the meantime finish with the next update.
+func (h *Helper) RestoreState(data json.RawMessage) error {
+	var state helperState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if h.Type == HelperTimer {
+		if state.Value != "active" || state.EndsAt == nil {
+			return nil
+		}
+		h.mutex.Lock()
+		h.Value = "active"
+		h.endsAt = *state.EndsAt
+		h.mutex.Unlock()
+		h.UpdateRules("state", "active")
+		return nil
+	}
+	if state.Value == nil {
+		return nil
+	}
+	if h.Type == HelperNumber || h.Type == HelperCounter {
+		// a saved value is absolute, passing it through set() would count a negative one down from the initial value
+		number, ok := core.NumericValue(state.Value)
+		if !ok {
+			return fmt.Errorf("saved value %v is not a number", state.Value)
+		}
+		number = math.Max(math.Min(number, h.max), h.min)
+		h.mutex.Lock()
+		h.Value = number
+		h.updateDisplay()
+		h.mutex.Unlock()
+		h.UpdateRules("value", number)
+		return nil
+	}
+	return h.set(fmt.Sprintf("%v", state.Value))
+}
+
+func (h *Helper) GetConfig(name string) core.DeviceConfig {
+	c := core.HelperConfig{Values: h.Values}
+	if !math.IsInf(h.min, -1) {
+		c.Min = &h.min
+	}
+	if !math.IsInf(h.max, 1) {
+		c.Max = &h.max
+	}
+	if h.step != 1 {
+		c.Step = &h.step
+	}
+	if h.initial != "" {
+		c.Initial = &h.initial
+	}
+	if h.Type == HelperTimer {
+		c.Duration = &h.duration
+	}
+	return core.DeviceConfig{
+		Name:    name,
+		Type:    h.Type,
+		Icon:    h.Icon,
+		Options: &core.ConfigOptions{Hidden: &h.Hidden, Helper: &c},
+	}
+}
@@ -0,0 +1,137 @@
+package devices
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHelper(deviceType string, helper core.HelperConfig) *Helper {
+	return NewHelper(core.DeviceConfig{Name: "helper", Type: deviceType, Options: &core.ConfigOptions{Helper: &helper}})
+}
+
+func TestHelper_Boolean(t *testing.T) {
+	h := newTestHelper(HelperBoolean, core.HelperConfig{})
+	assert.Equal(t, false, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "toggle"})
+	assert.Equal(t, true, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "off"})
+	assert.Equal(t, false, h.Value)
+	assert.Error(t, h.ProcessRequest(core.SwitchRequest{Value: "maybe"}))
+	assert.Equal(t, false, h.Value)
+}
+
+func TestHelper_Number(t *testing.T) {
+	h := newTestHelper(HelperNumber, core.HelperConfig{Min: core.ToPtr(10.0), Max: core.ToPtr(20.0), Step: core.ToPtr(0.5), Initial: core.ToPtr("15")})
+	assert.Equal(t, 15.0, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "increment"})
+	assert.Equal(t, 15.5, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "decrement"})
+	h.ProcessRequest(core.SwitchRequest{Value: "decrement"})
+	assert.Equal(t, 14.5, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "+16.2"})
+	assert.Equal(t, 16.0, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "100"})
+	assert.Equal(t, 20.0, h.Value)
+	assert.Equal(t, "20", h.Display)
+}
+
+func TestHelper_NegativeNumber(t *testing.T) {
+	h := newTestHelper(HelperNumber, core.HelperConfig{Min: core.ToPtr(-10.0), Max: core.ToPtr(10.0), Initial: core.ToPtr("2")})
+	h.ProcessRequest(core.SwitchRequest{Value: "-5"})
+	assert.Equal(t, -5.0, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "-20"})
+	assert.Equal(t, -10.0, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "increment"})
+	assert.Equal(t, -9.0, h.Value)
+}
+
+func TestHelper_Select(t *testing.T) {
+	h := newTestHelper(HelperSelect, core.HelperConfig{Values: []string{"home", "away", "night"}})
+	assert.Equal(t, "home", h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "night"})
+	assert.Equal(t, "night", h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "next"})
+	assert.Equal(t, "home", h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "previous"})
+	assert.Equal(t, "night", h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "party"})
+	assert.Equal(t, "night", h.Value)
+}
+
+func TestHelper_Counter(t *testing.T) {
+	h := newTestHelper(HelperCounter, core.HelperConfig{})
+	h.ProcessRequest(core.SwitchRequest{Value: "increment"})
+	h.ProcessRequest(core.SwitchRequest{Value: "increment"})
+	assert.Equal(t, 2.0, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "+5"})
+	h.ProcessRequest(core.SwitchRequest{Value: "-3"})
+	assert.Equal(t, 4.0, h.Value)
+	h.ProcessRequest(core.SwitchRequest{Value: "reset"})
+	assert.Equal(t, 0.0, h.Value)
+}
+
+func TestHelper_Timer(t *testing.T) {
+	h := newTestHelper(HelperTimer, core.HelperConfig{Duration: core.ToPtr(60)})
+	rule := &Rule{Triggers: []Trigger{{Device: h, Key: "finished", Condition: &condition{}}}}
+	h.AddRule(rule)
+
+	h.ProcessRequest(core.SwitchRequest{Key: "command", Value: "start"})
+	assert.Equal(t, "active", h.Value)
+	assert.Equal(t, 60, h.Remaining)
+	assert.Equal(t, "1:00", h.Display)
+
+	h.ProcessRequest(core.SwitchRequest{Key: "command", Value: "cancel"})
+	assert.Equal(t, "idle", h.Value)
+	assert.Nil(t, rule.Triggers[0].Condition.LastValue)
+
+	h.ProcessRequest(core.SwitchRequest{Key: "command", Value: "start", Duration: 1})
+	time.Sleep(1100 * time.Millisecond)
+	h.UpdateValue()
+	assert.Equal(t, "idle", h.Value)
+	assert.Equal(t, true, rule.Triggers[0].Condition.LastValue)
+}
+
+func TestHelper_PersistState(t *testing.T) {
+	h := newTestHelper(HelperSelect, core.HelperConfig{Values: []string{"home", "away"}})
+	h.ProcessRequest(core.SwitchRequest{Value: "away"})
+	data, err := json.Marshal(h.GetState())
+	assert.NoError(t, err)
+
+	restored := newTestHelper(HelperSelect, core.HelperConfig{Values: []string{"home", "away"}})
+	assert.NoError(t, restored.RestoreState(data))
+	assert.Equal(t, "away", restored.Value)
+
+	timer := newTestHelper(HelperTimer, core.HelperConfig{})
+	timer.ProcessRequest(core.SwitchRequest{Value: "start", Duration: 30})
+	data, err = json.Marshal(timer.GetState())
+	assert.NoError(t, err)
+	restoredTimer := newTestHelper(HelperTimer, core.HelperConfig{})
+	assert.NoError(t, restoredTimer.RestoreState(data))
+	restoredTimer.UpdateValue()
+	assert.Equal(t, "active", restoredTimer.Value)
+	assert.InDelta(t, 30, restoredTimer.Remaining, 1)
+}
+
+func TestHelper_PersistNegativeCounter(t *testing.T) {
+	h := newTestHelper(HelperCounter, core.HelperConfig{Initial: core.ToPtr("2")})
+	assert.NoError(t, h.ProcessRequest(core.SwitchRequest{Value: "-5"}))
+	assert.Equal(t, -3.0, h.Value)
+	data, err := json.Marshal(h.GetState())
+	assert.NoError(t, err)
+
+	// restoring twice must not drift away from the saved value
+	for range 2 {
+		restored := newTestHelper(HelperCounter, core.HelperConfig{Initial: core.ToPtr("2")})
+		assert.NoError(t, restored.RestoreState(data))
+		assert.Equal(t, -3.0, restored.Value)
+		assert.Equal(t, "-3", restored.Display)
+	}
+
+	number := newTestHelper(HelperNumber, core.HelperConfig{Min: core.ToPtr(-10.0), Max: core.ToPtr(10.0)})
+	assert.NoError(t, number.RestoreState(json.RawMessage(`{"value": -12}`)))
+	assert.Equal(t, -10.0, number.Value)
+}
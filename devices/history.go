@@ -0,0 +1,62 @@
+package devices
+
+import (
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+// historyRetention returns how long values of a field are kept, or 0 if the field isn't recorded.
+// Sensors are recorded unless disabled with `history: false`, lights, groups and plugs record their value.
+func historyRetention(device DeviceInterface, field string) time.Duration {
+	switch d := device.(type) {
+	case *GenericDevice:
+		return d.historyRetention(field)
+	case *Derived:
+		return d.historyRetention(field)
+	case *Light, *ZLight, *Group, *Plug:
+		if field == "value" {
+			return core.DefaultHistoryRetention
+		}
+	}
+	return 0
+}
+
+func (d *GenericDevice) historyRetention(field string) time.Duration {
+	for _, sensor := range d.Sensors {
+		if sensor.Name != field {
+			continue
+		}
+		if sensor.History == nil {
+			return core.DefaultHistoryRetention
+		}
+		if !sensor.History.Enabled {
+			return 0
+		}
+		if sensor.History.Retention > 0 {
+			return sensor.History.Retention
+		}
+		return core.DefaultHistoryRetention
+	}
+	return 0
+}
+
+// StepHistory tells whether a field changes in steps (switching and dimming) rather than continuously
+func StepHistory(device DeviceInterface, field string) bool {
+	switch device.(type) {
+	case *Light, *ZLight, *Group, *Plug:
+		return field == "value"
+	}
+	return false
+}
+
+// RecordHistory stores the values of all recorded fields in store as they change
+func RecordHistory(devices map[string]DeviceInterface, store *core.HistoryStore) {
+	for name, device := range devices {
+		device.Observe(func(field string, value any) {
+			if retention := historyRetention(device, field); retention > 0 {
+				store.Record(name, field, value, time.Now(), retention)
+			}
+		})
+	}
+}
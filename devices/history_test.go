@@ -0,0 +1,35 @@
+package devices
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordHistory(t *testing.T) {
+	sensor := NewDevice(core.DeviceConfig{Name: "Bathroom", Topic: "zigbee/bathroom", Options: &core.ConfigOptions{
+		Sensors: &[]core.Sensor{
+			{Name: "temperature"},
+			{Name: "humidity", History: &core.HistoryConfig{Enabled: true, Retention: time.Hour}},
+			{Name: "action", History: &core.HistoryConfig{}},
+		},
+	}})
+	assert.Equal(t, core.DefaultHistoryRetention, historyRetention(sensor, "temperature"))
+	assert.Equal(t, time.Hour, historyRetention(sensor, "humidity"))
+	assert.Zero(t, historyRetention(sensor, "action"))
+
+	lamp := NewLight(core.DeviceConfig{Name: "Lamp", Topic: "cmnd/lamp/dimmer"})
+	store := core.NewHistoryStore()
+	RecordHistory(map[string]DeviceInterface{"Bathroom": sensor, "Lamp": lamp}, store)
+
+	handler := sensor.GetMessageHandler(nil, sensor)
+	handler(nil, &mockMessage{payload: []byte(`{"temperature": 21.5, "humidity": 40, "action": 1}`)})
+	lamp.SetCurrent(0.8)
+
+	assert.Equal(t, []string{"humidity", "temperature"}, store.Keys("Bathroom"))
+	points, err := store.Query("Lamp", "value", time.Now().Add(-time.Minute), time.Now(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.8, points[0].Last)
+}
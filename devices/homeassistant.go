@@ -0,0 +1,435 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// HomeAssistant announces devices through Home Assistant's MQTT discovery. Every device publishes its state as
+// one retained JSON message to <base>/<id>/state, commands arrive on <base>/<id>/set (and <base>/<id>/scene for
+// scenes of groups) and are turned into switch requests, so dimmy stays in charge of fades and groups.
+type HomeAssistant struct {
+	prefix  string
+	base    string
+	include []string
+
+	mutex     sync.Mutex
+	names     map[string]string // object id -> device name
+	published map[string]string // device name -> last published state
+}
+
+// haEntity is one discovery config, published to <prefix>/<component>/<objectId>/config
+type haEntity struct {
+	Component string
+	ObjectId  string
+	Config    map[string]any
+}
+
+func NewHomeAssistant(config core.HomeAssistantConfig) *HomeAssistant {
+	h := &HomeAssistant{
+		prefix:    "homeassistant",
+		base:      "dimmy/homeassistant",
+		include:   config.Devices,
+		names:     make(map[string]string),
+		published: make(map[string]string),
+	}
+	if config.DiscoveryPrefix != "" {
+		h.prefix = strings.TrimSuffix(config.DiscoveryPrefix, "/")
+	}
+	if config.BaseTopic != "" {
+		h.base = strings.TrimSuffix(config.BaseTopic, "/")
+	}
+	return h
+}
+
+// AvailabilityTopic is set to online once the devices are announced and to offline by the broker when dimmy disconnects
+func (h *HomeAssistant) AvailabilityTopic() string {
+	return h.base + "/status"
+}
+
+// StatusTopic is where Home Assistant announces that it (re)started and needs the discovery configs again
+func (h *HomeAssistant) StatusTopic() string {
+	return h.prefix + "/status"
+}
+
+// OwnsTopic tells whether dimmy publishes to topic itself, so these messages aren't mistaken for new devices
+func (h *HomeAssistant) OwnsTopic(topic string) bool {
+	return strings.HasPrefix(topic, h.base+"/") || strings.HasPrefix(topic, h.prefix+"/")
+}
+
+// Announce publishes the discovery configs of all supported devices and has their states published again
+func (h *HomeAssistant) Announce(client mqtt.Client, devices map[string]DeviceInterface) {
+	names := make(map[string]string)
+	var entities []haEntity
+	for _, name := range sortedKeys(devices) {
+		if len(h.include) > 0 && !contains(h.include, name) {
+			continue
+		}
+		id := haObjectId(name)
+		if id == "" {
+			continue
+		}
+		if other, ok := names[id]; ok {
+			log.Printf("[%32s] Not announced to Home Assistant, its id %s is already used by %s\n", name, id, other)
+			continue
+		}
+		deviceEntities := h.entities(id, devices[name])
+		if len(deviceEntities) == 0 {
+			continue
+		}
+		names[id] = name
+		entities = append(entities, deviceEntities...)
+	}
+
+	h.mutex.Lock()
+	h.names = names
+	h.published = make(map[string]string)
+	h.mutex.Unlock()
+
+	for _, entity := range entities {
+		payload, _ := json.Marshal(entity.Config)
+		client.Publish(h.prefix+"/"+entity.Component+"/"+entity.ObjectId+"/config", 0, true, payload)
+	}
+	client.Publish(h.AvailabilityTopic(), 0, true, "online")
+	log.Printf("[%32s] Announced %d entities of %d devices\n", "Home Assistant", len(entities), len(names))
+}
+
+// PublishStates publishes the state of every announced device that changed since it was last published
+func (h *HomeAssistant) PublishStates(client mqtt.Client, devices map[string]DeviceInterface) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for id, name := range h.names {
+		device, ok := devices[name]
+		if !ok {
+			continue
+		}
+		payload, err := json.Marshal(haState(device))
+		if err != nil || string(payload) == h.published[name] {
+			continue
+		}
+		h.published[name] = string(payload)
+		client.Publish(h.base+"/"+id+"/state", 0, true, payload)
+	}
+}
+
+// Subscriptions returns the handlers of the command topics, which pass requests on to channel
+func (h *HomeAssistant) Subscriptions(channel chan core.SwitchRequest, devices func(string) (DeviceInterface, bool)) map[string]mqtt.MessageHandler {
+	handler := func(client mqtt.Client, message mqtt.Message) {
+		id, command, _ := strings.Cut(strings.TrimPrefix(message.Topic(), h.base+"/"), "/")
+		h.mutex.Lock()
+		name, ok := h.names[id]
+		h.mutex.Unlock()
+		if !ok {
+			return
+		}
+		device, ok := devices(name)
+		if !ok {
+			return
+		}
+		request, ok := haRequest(device, command, message.Payload())
+		if !ok {
+			log.Printf("[%32s] Ignoring Home Assistant command %s: %s\n", name, command, message.Payload())
+			return
+		}
+		request.Device = name
+		channel <- request
+	}
+	return map[string]mqtt.MessageHandler{
+		h.base + "/+/set":   handler,
+		h.base + "/+/scene": handler,
+	}
+}
+
+func (h *HomeAssistant) entities(id string, device DeviceInterface) []haEntity {
+	topic := h.base + "/" + id
+	config := func(uniqueId string, name any) map[string]any {
+		return map[string]any{
+			"unique_id":          uniqueId,
+			"name":               name,
+			"state_topic":        topic + "/state",
+			"availability_topic": h.AvailabilityTopic(),
+			"device": map[string]any{
+				"identifiers":  []string{"dimmy_" + id},
+				"name":         device.GetLabel(),
+				"manufacturer": "dimmy",
+				"model":        device.GetType(),
+			},
+		}
+	}
+	// the device's main entity is named after the device
+	main := func(component string) (haEntity, map[string]any) {
+		c := config("dimmy_"+id, nil)
+		c["command_topic"] = topic + "/set"
+		return haEntity{Component: component, ObjectId: id, Config: c}, c
+	}
+
+	switch d := device.(type) {
+	case *Light, *ZLight, *Group:
+		entity, c := main("light")
+		c["schema"] = "json"
+		c["brightness"] = true
+		c["brightness_scale"] = 100
+		c["supported_color_modes"] = []string{"brightness"}
+		entities := []haEntity{entity}
+		if group, ok := d.(*Group); ok {
+			scenes := group.GetScenes()
+			sort.Strings(scenes)
+			for _, scene := range scenes {
+				objectId := id + "_" + haObjectId(scene)
+				c := config("dimmy_"+objectId, scene)
+				delete(c, "state_topic")
+				c["command_topic"] = topic + "/scene"
+				c["payload_on"] = scene
+				entities = append(entities, haEntity{Component: "scene", ObjectId: objectId, Config: c})
+			}
+		}
+		return entities
+	case *Plug:
+		entity, c := main("switch")
+		c["value_template"] = "{{ value_json.state }}"
+		return []haEntity{entity}
+	case *Helper:
+		return d.haEntities(main)
+	case *GenericDevice:
+		return d.haEntities(id, config)
+	case *Derived:
+		return d.GenericDevice.haEntities(id, config)
+	}
+	return nil
+}
+
+func (h *Helper) haEntities(main func(string) (haEntity, map[string]any)) []haEntity {
+	var entity haEntity
+	var c map[string]any
+	switch h.Type {
+	case HelperBoolean:
+		entity, c = main("switch")
+	case HelperNumber:
+		entity, c = main("number")
+		c["min"] = h.min
+		c["max"] = h.max
+		c["step"] = h.step
+	case HelperSelect:
+		entity, c = main("select")
+		c["options"] = h.Values
+	default:
+		// counters and timers are changed through rules, Home Assistant only shows them
+		entity, c = main("sensor")
+		delete(c, "command_topic")
+	}
+	c["value_template"] = "{{ value_json.state }}"
+	if h.Type == HelperTimer {
+		c["json_attributes_topic"] = c["state_topic"]
+	}
+	return []haEntity{entity}
+}
+
+func (d *GenericDevice) haEntities(id string, config func(string, any) map[string]any) []haEntity {
+	var entities []haEntity
+	for _, sensor := range d.Sensors {
+		if sensor.Hidden {
+			continue
+		}
+		objectId := id + "_" + haObjectId(sensor.Name)
+		c := config("dimmy_"+objectId, sensor.Name)
+		value := fmt.Sprintf("value_json[%q]", sensor.Name)
+		component := "sensor"
+		if deviceClass, ok := haBinarySensorClasses[sensor.Name]; ok || d.isBoolValue(sensor.Name) {
+			component = "binary_sensor"
+			if deviceClass != "" {
+				c["device_class"] = deviceClass
+			}
+			// contact sensors report true while closed, Home Assistant's door class is on while open
+			if sensor.Name == "contact" {
+				c["value_template"] = "{{ 'OFF' if " + value + " else 'ON' }}"
+			} else {
+				c["value_template"] = "{{ 'ON' if " + value + " in [true, 'ON', 'on'] else 'OFF' }}"
+			}
+		} else {
+			c["value_template"] = "{{ " + value + " }}"
+			if class, ok := haSensorClasses[sensor.Name]; ok {
+				c["device_class"] = class.deviceClass
+				c["unit_of_measurement"] = class.unit
+				c["state_class"] = "measurement"
+			}
+		}
+		entities = append(entities, haEntity{Component: component, ObjectId: objectId, Config: c})
+	}
+	return entities
+}
+
+func (d *GenericDevice) isBoolValue(field string) bool {
+	d.valueMutex.RLock()
+	defer d.valueMutex.RUnlock()
+	value, ok := d.Values[field]
+	if !ok || value == nil {
+		return false
+	}
+	_, isBool := value.Value.(bool)
+	return isBool
+}
+
+var haSensorClasses = map[string]struct{ deviceClass, unit string }{
+	"temperature":        {"temperature", "°C"},
+	"device_temperature": {"temperature", "°C"},
+	"humidity":           {"humidity", "%"},
+	"soil_moisture":      {"moisture", "%"},
+	"pressure":           {"pressure", "hPa"},
+	"illuminance":        {"illuminance", "lx"},
+	"co2":                {"carbon_dioxide", "ppm"},
+	"pm25":               {"pm25", "µg/m³"},
+	"pm10":               {"pm10", "µg/m³"},
+	"power":              {"power", "W"},
+	"energy":             {"energy", "kWh"},
+	"current":            {"current", "A"},
+	"voltage":            {"voltage", "V"},
+	"battery":            {"battery", "%"},
+}
+
+// haBinarySensorClasses lists sensors that are on or off, with the device class if Home Assistant has one
+var haBinarySensorClasses = map[string]string{
+	"contact":         "door",
+	"occupancy":       "occupancy",
+	"presence":        "presence",
+	"water_leak":      "moisture",
+	"smoke":           "smoke",
+	"gas":             "gas",
+	"carbon_monoxide": "carbon_monoxide",
+	"vibration":       "vibration",
+	"tamper":          "tamper",
+	"battery_low":     "battery",
+	"moving":          "moving",
+}
+
+// haState returns the payload published to a device's state topic
+func haState(device DeviceInterface) map[string]any {
+	onOff := func(on bool) string {
+		if on {
+			return "ON"
+		}
+		return "OFF"
+	}
+	switch d := device.(type) {
+	case *Light, *ZLight, *Group:
+		brightness := math.Round(device.GetCurrent())
+		return map[string]any{"state": onOff(brightness > 0), "brightness": brightness, "color_mode": "brightness"}
+	case *Plug:
+		return map[string]any{"state": onOff(device.GetCurrent() > 0)}
+	case *Helper:
+		d.mutex.RLock()
+		defer d.mutex.RUnlock()
+		switch d.Type {
+		case HelperBoolean:
+			return map[string]any{"state": onOff(d.Value == true)}
+		case HelperTimer:
+			return map[string]any{"state": d.Value, "remaining": d.Remaining}
+		}
+		return map[string]any{"state": d.Value}
+	case *GenericDevice:
+		return d.haState()
+	case *Derived:
+		return d.GenericDevice.haState()
+	}
+	return nil
+}
+
+func (d *GenericDevice) haState() map[string]any {
+	d.valueMutex.RLock()
+	defer d.valueMutex.RUnlock()
+	state := make(map[string]any, len(d.Values))
+	for name, value := range d.Values {
+		if value != nil && value.Value != nil {
+			state[name] = value.Value
+		}
+	}
+	return state
+}
+
+// haLightCommand is the JSON schema Home Assistant sends to lights
+type haLightCommand struct {
+	State      string   `json:"state"`
+	Brightness *float64 `json:"brightness"`
+	Transition *float64 `json:"transition"`
+}
+
+// haRequest translates a command published by Home Assistant into a switch request
+func haRequest(device DeviceInterface, command string, payload []byte) (core.SwitchRequest, bool) {
+	var request core.SwitchRequest
+	value := strings.TrimSpace(string(payload))
+	if command == "scene" {
+		if _, ok := device.(*Group); !ok || value == "" {
+			return request, false
+		}
+		request.Key = "scene_recall"
+		request.Value = value
+		return request, true
+	}
+	if command != "set" {
+		return request, false
+	}
+
+	switch d := device.(type) {
+	case *Light, *ZLight, *Group:
+		var light haLightCommand
+		if err := json.Unmarshal(payload, &light); err != nil {
+			return request, false
+		}
+		switch {
+		case strings.EqualFold(light.State, "OFF"):
+			request.Value = "0"
+		case light.Brightness != nil:
+			request.Value = strconv.FormatFloat(*light.Brightness, 'f', -1, 64)
+		case strings.EqualFold(light.State, "ON"):
+			request.Value = "100"
+		default:
+			return request, false
+		}
+		if light.Transition != nil {
+			request.Duration = int(math.Round(*light.Transition))
+		}
+		return request, true
+	case *Plug:
+		switch strings.ToUpper(value) {
+		case "ON":
+			request.Value = "1"
+		case "OFF":
+			request.Value = "0"
+		default:
+			return request, false
+		}
+		return request, true
+	case *Helper:
+		if d.Type == HelperCounter || d.Type == HelperTimer {
+			return request, false
+		}
+		request.Key = "value"
+		request.Value = value
+		return request, true
+	}
+	return request, false
+}
+
+// haObjectId turns a name into an id usable in topics and entity ids
+func haObjectId(name string) string {
+	var id strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			id.WriteRune(r)
+			underscore = false
+		} else if !underscore && id.Len() > 0 {
+			id.WriteRune('_')
+			underscore = true
+		}
+	}
+	return strings.TrimSuffix(id.String(), "_")
+}
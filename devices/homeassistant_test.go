@@ -0,0 +1,96 @@
+package devices
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHomeAssistant_Announce(t *testing.T) {
+	light := NewLight(core.DeviceConfig{Name: "Living Room", Type: "light", Topic: "cmnd/living/Dimmer"})
+	sensor := NewDevice(core.DeviceConfig{Name: "Bath", Options: &core.ConfigOptions{Sensors: &[]core.Sensor{{Name: "temperature"}, {Name: "contact"}}}})
+	ha := NewHomeAssistant(core.HomeAssistantConfig{})
+	client := &mockClient{}
+
+	ha.Announce(client, map[string]DeviceInterface{"Living Room": light, "Bath": sensor, "time": &DimmyTime{}})
+
+	configs := make(map[string]map[string]any)
+	for _, message := range client.published {
+		var config map[string]any
+		if json.Unmarshal([]byte(message.payload), &config) == nil {
+			configs[message.topic] = config
+		}
+	}
+	assert.Len(t, configs, 3)
+	lightConfig := configs["homeassistant/light/living_room/config"]
+	assert.Equal(t, "dimmy/homeassistant/living_room/set", lightConfig["command_topic"])
+	assert.Equal(t, "dimmy/homeassistant/living_room/state", lightConfig["state_topic"])
+	assert.Equal(t, "json", lightConfig["schema"])
+	assert.Equal(t, float64(100), lightConfig["brightness_scale"])
+
+	temperature := configs["homeassistant/sensor/bath_temperature/config"]
+	assert.Equal(t, "temperature", temperature["device_class"])
+	assert.Equal(t, "°C", temperature["unit_of_measurement"])
+	assert.Equal(t, "door", configs["homeassistant/binary_sensor/bath_contact/config"]["device_class"])
+
+	last := client.published[len(client.published)-1]
+	assert.Equal(t, publishedMessage{topic: "dimmy/homeassistant/status", payload: "online"}, last)
+}
+
+func TestHomeAssistant_PublishStates(t *testing.T) {
+	plug := NewPlug(core.DeviceConfig{Name: "Fan", Type: "plug", Topic: "cmnd/fan/POWER"})
+	devices := map[string]DeviceInterface{"Fan": plug}
+	ha := NewHomeAssistant(core.HomeAssistantConfig{BaseTopic: "home/dimmy/"})
+	client := &mockClient{}
+	ha.Announce(client, devices)
+	client.published = nil
+
+	ha.PublishStates(client, devices)
+	ha.PublishStates(client, devices)
+	plug.SetCurrent(1)
+	ha.PublishStates(client, devices)
+
+	assert.Equal(t, []publishedMessage{
+		{topic: "home/dimmy/fan/state", payload: `{"state":"OFF"}`},
+		{topic: "home/dimmy/fan/state", payload: `{"state":"ON"}`},
+	}, client.published)
+}
+
+func TestHomeAssistant_Request(t *testing.T) {
+	light := NewLight(core.DeviceConfig{Name: "light", Type: "light", Topic: "cmnd/light/Dimmer"})
+
+	request, ok := haRequest(light, "set", []byte(`{"state":"ON","brightness":40,"transition":2.5}`))
+	assert.True(t, ok)
+	assert.Equal(t, core.SwitchRequest{Value: "40", Duration: 3}, request)
+
+	request, ok = haRequest(light, "set", []byte(`{"state":"OFF"}`))
+	assert.True(t, ok)
+	assert.Equal(t, "0", request.Value)
+
+	request, _ = haRequest(light, "set", []byte(`{"state":"ON"}`))
+	assert.Equal(t, "100", request.Value)
+
+	_, ok = haRequest(light, "scene", []byte("evening"))
+	assert.False(t, ok, "only groups have scenes")
+
+	plug := NewPlug(core.DeviceConfig{Name: "plug", Type: "plug", Topic: "cmnd/plug/POWER"})
+	request, ok = haRequest(plug, "set", []byte("ON"))
+	assert.True(t, ok)
+	assert.Equal(t, "1", request.Value)
+
+	number := newTestHelper(HelperNumber, core.HelperConfig{})
+	request, ok = haRequest(number, "set", []byte("42"))
+	assert.True(t, ok)
+	assert.Equal(t, core.SwitchRequest{Key: "value", Value: "42"}, request)
+
+	_, ok = haRequest(newTestHelper(HelperCounter, core.HelperConfig{}), "set", []byte("1"))
+	assert.False(t, ok)
+}
+
+func TestHomeAssistant_ObjectId(t *testing.T) {
+	assert.Equal(t, "living_room_lamp_1", haObjectId("Living Room - Lamp 1"))
+	assert.Equal(t, "k_che", haObjectId("Küche"))
+	assert.Equal(t, "", haObjectId("!!"))
+}
@@ -2,65 +2,357 @@ package devices
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/PhilGruber/dimmy/core"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+const (
+	IrProtocolZigbee2Mqtt = "zigbee2mqtt"
+	IrProtocolTasmota     = "tasmota"
+)
+
 type IRControl struct {
 	Device
 
+	protocol         string
 	commands         map[string]string
 	nextRequest      *IrControlMessage
 	preventResending bool
 	lastCommand      string
+
+	Learning    bool   `json:"learning"`
+	LearnedCode string `json:"learnedCode,omitempty"`
+	startLearn  bool
+
+	macros map[string][]core.MacroStep
+	// effects describes how each command changes the virtual state, e.g. vol_up: {volume: "+1"}
+	effects map[string]map[string]string
+	State   map[string]any `json:"state,omitempty"`
+
+	hvac        *irHvac
+	hvacPending bool
 }
 
 type IrControlMessage struct {
-	IrCode string `json:"ir_code_to_send"`
+	IrCode  string `json:"ir_code_to_send"`
+	command string
 }
 
+type irLearnMessage struct {
+	LearnIrCode string `json:"learn_ir_code"`
+}
+
+type irStateMessage struct {
+	LearnedIrCode *string `json:"learned_ir_code"`
+}
+
+var tasmotaCommandTopic = regexp.MustCompile("^cmnd/(.+)$")
+
 func NewIrControl(config core.DeviceConfig) *IRControl {
 	i := IRControl{}
 	i.Icon = "📡"
 	i.setBaseConfig(config)
-	i.preventResending = config.Options.PreventResending
+	i.MqttState = config.Topic
 
 	i.Type = "IRControl"
-	if config.Options != nil && config.Options.Commands != nil {
-		i.commands = *config.Options.Commands
+	i.protocol = IrProtocolZigbee2Mqtt
+	i.commands = make(map[string]string)
+	i.macros = make(map[string][]core.MacroStep)
+	i.effects = make(map[string]map[string]string)
+	if config.Options != nil {
+		if config.Options.Protocol != nil {
+			switch *config.Options.Protocol {
+			case IrProtocolZigbee2Mqtt, IrProtocolTasmota:
+				i.protocol = *config.Options.Protocol
+			default:
+				log.Printf("[%32s] Unknown IR protocol %s, using %s\n", i.Name, *config.Options.Protocol, i.protocol)
+			}
+		}
+		i.preventResending = config.Options.PreventResending
+		if config.Options.Commands != nil {
+			i.commands = *config.Options.Commands
+		}
+		if config.Options.Macros != nil {
+			i.macros = *config.Options.Macros
+		}
+		if config.Options.VirtualState != nil {
+			i.effects = *config.Options.VirtualState
+		}
+		if config.Options.Hvac != nil {
+			if i.protocol == IrProtocolTasmota {
+				i.hvac = newIrHvac(config.Options.Hvac)
+			} else {
+				log.Printf("[%32s] HVAC control needs the tasmota protocol, ignoring it\n", i.Name)
+			}
+		}
+	}
+	if i.protocol == IrProtocolTasmota {
+		// Tasmota reports received codes on tele/<topic>/RESULT
+		i.setStateTopic(tasmotaCommandTopic.ReplaceAllString(i.MqttTopic, "tele/$1/RESULT"))
 	}
 
 	i.Receivers = []string{"command"}
+	if len(i.macros) > 0 {
+		i.Receivers = append(i.Receivers, "macro")
+	}
+
+	i.State = make(map[string]any)
+	if i.hvac != nil {
+		if config.Icon == "" {
+			i.Icon = "ac.png"
+		}
+		i.State = i.hvac.defaults()
+		i.Triggers = append(i.Triggers, hvacKeys...)
+		i.Receivers = append(i.Receivers, hvacKeys...)
+	}
+	for _, effects := range i.effects {
+		for key := range effects {
+			if _, ok := i.State[key]; !ok {
+				i.State[key] = nil
+				i.Triggers = append(i.Triggers, key)
+				i.Receivers = append(i.Receivers, key)
+			}
+		}
+	}
+	sort.Strings(i.Triggers)
+	i.persistentFields = append(i.persistentFields, i.Triggers...)
 
 	log.Printf("IRControl Device %s created with commands: %s\n", i.Name, i.GetCommands())
 	return &i
 }
 
-func (i *IRControl) ProcessRequest(request core.SwitchRequest) {
-	command, ok := i.commands[request.Value]
+func (i *IRControl) ProcessRequest(request core.SwitchRequest) error {
+	i.mutex.RLock()
+	steps, isMacro := i.macros[request.Value]
+	_, isState := i.State[request.Key]
+	i.mutex.RUnlock()
+
+	if i.hvac != nil && i.hvac.handles(request.Key) {
+		return i.setHvac(request.Key, request.Value, request.Force)
+	}
+	if isMacro && request.Key != "command" {
+		log.Printf("[%32s] Running macro %s\n", i.Name, request.Value)
+		go i.runMacro(steps)
+		return nil
+	}
+	if isState {
+		request.Value = i.commandForState(request.Key, request.Value)
+		if request.Value == "" {
+			return nil
+		}
+	}
+	return i.queueCommand(request.Value, request.Force)
+}
+
+// queueCommand prepares the named command to be sent in the next cycle
+func (i *IRControl) queueCommand(name string, force bool) error {
+	i.mutex.RLock()
+	command, ok := i.commands[name]
+	lastCommand := i.lastCommand
+	i.mutex.RUnlock()
 	if !ok {
-		log.Printf("Device %s does not support command %s. Please define this in config file.\n", i.Name, request.Value)
-		return
+		return fmt.Errorf("command %s is not supported, please define it in the config file", name)
 	}
-	if i.preventResending && i.lastCommand == command && !request.Force {
-		log.Printf("Skipping request for Device %s, as we have sent it before: %v\n", i.Name, request.Value)
-		return
+	if i.preventResending && lastCommand == command && !force {
+		log.Printf("Skipping request for Device %s, as we have sent it before: %v\n", i.Name, name)
+		return nil
 	}
-	log.Printf("Processing request for Device %s: %v\n", i.Name, request.Value)
-	req := IrControlMessage{IrCode: command}
+	log.Printf("Processing request for Device %s: %v\n", i.Name, name)
+	req := IrControlMessage{IrCode: command, command: name}
+	i.mutex.Lock()
 	i.nextRequest = &req
+	i.mutex.Unlock()
+	return nil
+}
+
+// setHvac changes one setting of the air conditioner. The whole state is sent in the next cycle.
+func (i *IRControl) setHvac(key string, value string, force bool) error {
+	i.mutex.Lock()
+	newValue, ok := i.hvac.parse(key, value, i.State[key])
+	if !ok {
+		i.mutex.Unlock()
+		return fmt.Errorf("invalid value %s for %s", value, key)
+	}
+	if newValue == i.State[key] && !force {
+		i.mutex.Unlock()
+		return nil
+	}
+	log.Printf("[%32s] Setting %s to %v\n", i.Name, key, newValue)
+	i.State[key] = newValue
+	i.hvacPending = true
+	i.mutex.Unlock()
+	i.UpdateRules(key, newValue)
+	return nil
+}
+
+func (i *IRControl) runMacro(steps []core.MacroStep) {
+	for _, step := range steps {
+		if step.Delay > 0 {
+			time.Sleep(time.Duration(step.Delay * float64(time.Second)))
+		}
+		if step.Command == "" {
+			continue
+		}
+		// wait for the previous command to be sent, so each command gets its own message
+		for i.hasPendingRequest() {
+			time.Sleep(core.CycleLength * time.Millisecond)
+		}
+		if err := i.queueCommand(step.Command, true); err != nil {
+			log.Printf("[%32s] %s\n", i.Name, err.Error())
+		}
+	}
+}
+
+func (i *IRControl) hasPendingRequest() bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.nextRequest != nil
+}
+
+// commandForState finds a command that sets the virtual state key to value. Returns an empty
+// string if the state already has that value or no command can set it.
+func (i *IRControl) commandForState(key string, value string) string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	current := fmt.Sprintf("%v", i.State[key])
+	if current == value {
+		return ""
+	}
+	var toggle string
+	for _, name := range sortedKeys(i.effects) {
+		switch i.effects[name][key] {
+		case value:
+			return name
+		case "toggle":
+			toggle = name
+		}
+	}
+	if toggle != "" && (value == "on" || value == "off") {
+		return toggle
+	}
+	log.Printf("[%32s] No command sets %s to %s\n", i.Name, key, value)
+	return ""
+}
+
+// applyEffects updates the virtual state after a command has been sent
+func (i *IRControl) applyEffects(name string) {
+	i.mutex.Lock()
+	changed := make(map[string]any)
+	for key, effect := range i.effects[name] {
+		value := applyEffect(i.State[key], effect)
+		i.State[key] = value
+		changed[key] = value
+	}
+	i.mutex.Unlock()
+	for key, value := range changed {
+		i.UpdateRules(key, value)
+	}
+}
+
+func applyEffect(current any, effect string) any {
+	if effect == "toggle" {
+		if current == "on" {
+			return "off"
+		}
+		return "on"
+	}
+	if len(effect) > 1 && (effect[0] == '+' || effect[0] == '-') {
+		if delta, err := strconv.ParseFloat(effect, 64); err == nil {
+			value, _ := current.(float64)
+			return value + delta
+		}
+	}
+	if value, err := strconv.ParseFloat(effect, 64); err == nil {
+		return value
+	}
+	return effect
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (i *IRControl) PublishValue(mqtt mqtt.Client) {
-	if i.nextRequest == nil {
+	i.mutex.Lock()
+	startLearn := i.startLearn
+	i.startLearn = false
+	request := i.nextRequest
+	i.nextRequest = nil
+	var hvacMessage *core.TasmotaIRHvacMessage
+	if i.hvacPending {
+		m := i.hvac.message(i.State)
+		hvacMessage = &m
+		i.hvacPending = false
+	}
+	i.mutex.Unlock()
+	if startLearn {
+		s, _ := json.Marshal(irLearnMessage{LearnIrCode: "ON"})
+		mqtt.Publish(i.GetMqttTopic(), 0, false, s)
+	}
+	if hvacMessage != nil {
+		s, _ := json.Marshal(hvacMessage)
+		mqtt.Publish(i.MqttTopic+"/IRhvac", 0, false, s)
+	}
+	if request == nil {
 		return
 	}
-	s, _ := json.Marshal(i.nextRequest)
+	var s []byte
+	if i.protocol == IrProtocolTasmota {
+		message, err := parseTasmotaCode(request.IrCode)
+		if err != nil {
+			log.Printf("[%32s] Invalid IR code for command %s: %s\n", i.Name, request.command, err.Error())
+			return
+		}
+		s, _ = json.Marshal(message)
+	} else {
+		s, _ = json.Marshal(request)
+	}
 	mqtt.Publish(i.GetMqttTopic(), 0, false, s)
-	i.lastCommand = i.nextRequest.IrCode
-	i.nextRequest = nil
+	i.mutex.Lock()
+	i.lastCommand = request.IrCode
+	i.mutex.Unlock()
+	i.applyEffects(request.command)
+}
+
+// parseTasmotaCode reads an IRsend code, either as JSON or in the short form PROTOCOL:BITS:DATA, e.g. NEC:32:0x20DF10EF
+func parseTasmotaCode(code string) (core.TasmotaIRSendMessage, error) {
+	var message core.TasmotaIRSendMessage
+	if strings.HasPrefix(strings.TrimSpace(code), "{") {
+		if err := json.Unmarshal([]byte(code), &message); err != nil {
+			return message, err
+		}
+	} else {
+		parts := strings.Split(code, ":")
+		if len(parts) != 3 {
+			return message, fmt.Errorf("expected PROTOCOL:BITS:DATA, got %s", code)
+		}
+		bits, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return message, fmt.Errorf("invalid number of bits %s", parts[1])
+		}
+		message = core.TasmotaIRSendMessage{Protocol: parts[0], Bits: bits, Data: parts[2]}
+	}
+	if message.Protocol == "" || message.Data == "" {
+		return message, fmt.Errorf("protocol and data are required")
+	}
+	return message, nil
+}
+
+func formatTasmotaCode(message core.TasmotaIRSendMessage) string {
+	return fmt.Sprintf("%s:%d:%s", message.Protocol, message.Bits, message.Data)
 }
 
 func (i *IRControl) GetMax() int {
@@ -71,40 +363,153 @@ func (i *IRControl) GetMin() int {
 }
 
 func (i *IRControl) UpdateValue() (float64, bool) {
-	return 0, i.nextRequest != nil
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return 0, i.nextRequest != nil || i.startLearn || i.hvacPending
 }
 
-func (i *IRControl) GetCommands() []string {
-	var commands []string
-	for k := range i.commands {
-		commands = append(commands, k)
+// StartLearning puts the IR blaster into learn mode. The next code it receives can be read with GetLearnedCode.
+func (i *IRControl) StartLearning() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	log.Printf("[%32s] Starting to learn IR code\n", i.Name)
+	i.Learning = true
+	i.LearnedCode = ""
+	// Tasmota always reports received codes, only zigbee2mqtt blasters need to be switched to learn mode
+	i.startLearn = i.protocol == IrProtocolZigbee2Mqtt
+}
+
+// GetLearnedCode returns the last learned code and whether the device is still waiting for one
+func (i *IRControl) GetLearnedCode() (string, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.LearnedCode, i.Learning
+}
+
+// SaveCommand makes code available as a named command
+func (i *IRControl) SaveCommand(name string, code string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.commands[name] = code
+}
+
+func (i *IRControl) GetMessageHandler(chan core.SwitchRequest, DeviceInterface) mqtt.MessageHandler {
+	return func(client mqtt.Client, mqttMessage mqtt.Message) {
+		payload := mqttMessage.Payload()
+		var data map[string]any
+		if err := json.Unmarshal(payload, &data); err != nil {
+			log.Printf("[%32s] Error: %s\n", i.Name, err.Error())
+			return
+		}
+		i.parseDefaultValues(data)
+
+		var code string
+		if i.protocol == IrProtocolTasmota {
+			var received core.TasmotaIRReceivedMessage
+			if err := json.Unmarshal(payload, &received); err != nil || received.IrReceived == nil {
+				return
+			}
+			code = formatTasmotaCode(*received.IrReceived)
+		} else {
+			var state irStateMessage
+			if err := json.Unmarshal(payload, &state); err != nil || state.LearnedIrCode == nil {
+				return
+			}
+			code = *state.LearnedIrCode
+		}
+		if code == "" {
+			return
+		}
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		if !i.Learning {
+			return
+		}
+		log.Printf("[%32s] Learned IR code %s\n", i.Name, code)
+		i.LearnedCode = code
+		i.Learning = false
 	}
-	return commands
+}
+
+func (i *IRControl) GetCommands() []string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return sortedKeys(i.commands)
+}
+
+func (i *IRControl) GetMacros() []string {
+	return sortedKeys(i.macros)
 }
 
 func (i *IRControl) SetReceiverValue(key string, value interface{}) {
 	switch key {
 	case "command":
 		command := i.commands[value.(string)]
-		req := IrControlMessage{IrCode: command}
+		req := IrControlMessage{IrCode: command, command: value.(string)}
 		i.nextRequest = &req
 	}
 }
 
 func (d *IRControl) GetMqttTopic() string {
+	if d.protocol == IrProtocolTasmota {
+		return d.MqttTopic + "/IRsend"
+	}
 	return d.MqttTopic + "/set"
 }
 
+// GetHvacModes returns the modes of the air conditioner, or nil if the device doesn't control one
+func (d *IRControl) GetHvacModes() []string {
+	if d.hvac == nil {
+		return nil
+	}
+	return d.hvac.modes
+}
+
+func (d *IRControl) GetHvacFanSpeeds() []string {
+	if d.hvac == nil {
+		return nil
+	}
+	return d.hvac.fanSpeeds
+}
+
 func (d *IRControl) GetConfig(name string) core.DeviceConfig {
+	var protocol *string
+	if d.protocol != IrProtocolZigbee2Mqtt {
+		protocol = &d.protocol
+	}
+	var hvac *core.HvacConfig
+	if d.hvac != nil {
+		hvac = &core.HvacConfig{
+			Vendor:    d.hvac.vendor,
+			Model:     d.hvac.model,
+			MinTemp:   &d.hvac.minTemp,
+			MaxTemp:   &d.hvac.maxTemp,
+			Modes:     &d.hvac.modes,
+			FanSpeeds: &d.hvac.fanSpeeds,
+		}
+	}
+	var macros *map[string][]core.MacroStep
+	if len(d.macros) > 0 {
+		macros = &d.macros
+	}
+	var effects *map[string]map[string]string
+	if len(d.effects) > 0 {
+		effects = &d.effects
+	}
 	config := core.DeviceConfig{
 		Name:  name,
-		Type:  "device",
+		Type:  "ircontrol",
 		Topic: d.MqttTopic,
 		Icon:  d.Icon,
 		Options: &core.ConfigOptions{
 			Commands:         &d.commands,
+			Macros:           macros,
+			VirtualState:     effects,
+			Protocol:         protocol,
+			Hvac:             hvac,
 			Hidden:           &d.Hidden,
 			PreventResending: d.preventResending,
+			StateTopic:       d.stateTopic,
 		},
 	}
 	return config
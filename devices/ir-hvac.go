@@ -0,0 +1,136 @@
+package devices
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+// irHvac keeps the full state of an air conditioner, as IR remotes for these always send all settings at once
+type irHvac struct {
+	vendor    string
+	model     *int
+	minTemp   float64
+	maxTemp   float64
+	modes     []string
+	fanSpeeds []string
+}
+
+var hvacKeys = []string{"fan", "mode", "power", "temperature"}
+
+func newIrHvac(config *core.HvacConfig) *irHvac {
+	h := irHvac{
+		vendor:    config.Vendor,
+		model:     config.Model,
+		minTemp:   16,
+		maxTemp:   30,
+		modes:     []string{"auto", "cool", "heat", "dry", "fan"},
+		fanSpeeds: []string{"auto", "min", "low", "medium", "high", "max"},
+	}
+	if config.MinTemp != nil {
+		h.minTemp = *config.MinTemp
+	}
+	if config.MaxTemp != nil {
+		h.maxTemp = *config.MaxTemp
+	}
+	if config.Modes != nil && len(*config.Modes) > 0 {
+		h.modes = lowerAll(*config.Modes)
+	}
+	if config.FanSpeeds != nil && len(*config.FanSpeeds) > 0 {
+		h.fanSpeeds = lowerAll(*config.FanSpeeds)
+	}
+	return &h
+}
+
+func (h *irHvac) defaults() map[string]any {
+	return map[string]any{
+		"power":       "off",
+		"mode":        h.modes[0],
+		"fan":         h.fanSpeeds[0],
+		"temperature": math.Max(math.Min(22, h.maxTemp), h.minTemp),
+	}
+}
+
+func (h *irHvac) handles(key string) bool {
+	for _, k := range hvacKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parse validates a requested value for key. Temperatures can be given relative to the current one, e.g. "+1".
+func (h *irHvac) parse(key string, value string, current any) (any, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch key {
+	case "power":
+		switch value {
+		case "on", "1", "true":
+			return "on", true
+		case "off", "0", "false":
+			return "off", true
+		case "toggle":
+			if current == "on" {
+				return "off", true
+			}
+			return "on", true
+		}
+	case "mode":
+		return value, contains(h.modes, value)
+	case "fan":
+		return value, contains(h.fanSpeeds, value)
+	case "temperature":
+		temp, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, false
+		}
+		if value[0] == '+' || value[0] == '-' {
+			c, _ := current.(float64)
+			temp += c
+		}
+		return math.Max(math.Min(temp, h.maxTemp), h.minTemp), true
+	}
+	return nil, false
+}
+
+func (h *irHvac) message(state map[string]any) core.TasmotaIRHvacMessage {
+	temp, _ := state["temperature"].(float64)
+	return core.TasmotaIRHvacMessage{
+		Vendor:   h.vendor,
+		Model:    h.model,
+		Power:    tasmotaValue(state["power"]),
+		Mode:     tasmotaValue(state["mode"]),
+		FanSpeed: tasmotaValue(state["fan"]),
+		Temp:     temp,
+		Celsius:  "On",
+	}
+}
+
+// tasmotaValue capitalises a state value the way Tasmota expects it, e.g. "cool" becomes "Cool"
+func tasmotaValue(value any) string {
+	s, _ := value.(string)
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func lowerAll(values []string) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = strings.ToLower(v)
+	}
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -3,8 +3,10 @@ package devices
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"gopkg.in/yaml.v3"
 )
 
@@ -37,7 +39,9 @@ func TestIRControl_ProcessRequest_KnownCommand(t *testing.T) {
 
 func TestIRControl_ProcessRequest_UnknownCommand(t *testing.T) {
 	ir := NewIrControl(irControlConfig(t, false))
-	ir.ProcessRequest(core.SwitchRequest{Value: "unknown"})
+	if err := ir.ProcessRequest(core.SwitchRequest{Value: "unknown"}); err == nil {
+		t.Error("expected an error for unknown command")
+	}
 	if ir.nextRequest != nil {
 		t.Error("expected nextRequest to remain nil for unknown command")
 	}
@@ -49,8 +53,10 @@ func TestIRControl_ProcessRequest_PreventResendingBlocksDuplicate(t *testing.T)
 	// Simulate the command having been sent already.
 	ir.lastCommand = ir.nextRequest.IrCode
 	ir.nextRequest = nil
-	// Same command again without Force — should be skipped.
-	ir.ProcessRequest(core.SwitchRequest{Value: "power"})
+	// Same command again without Force — should be skipped, which isn't an error.
+	if err := ir.ProcessRequest(core.SwitchRequest{Value: "power"}); err != nil {
+		t.Errorf("expected skipped command not to fail, got %v", err)
+	}
 	if ir.nextRequest != nil {
 		t.Error("expected nextRequest to remain nil when preventResending blocks duplicate")
 	}
@@ -113,3 +119,256 @@ func TestIRControl_GetCommands_ReturnsAllKeys(t *testing.T) {
 		t.Error("expected 'mute' in commands")
 	}
 }
+
+func TestIRControl_LearnCode(t *testing.T) {
+	ir := NewIrControl(irControlConfig(t, false))
+	client := &mockClient{}
+	handler := ir.GetMessageHandler(nil, ir)
+
+	// codes received outside of learn mode are ignored
+	handler(nil, &mockMessage{payload: []byte(`{"learned_ir_code":"IGNORED"}`)})
+	if code, _ := ir.GetLearnedCode(); code != "" {
+		t.Errorf("expected no learned code, got %s", code)
+	}
+
+	ir.StartLearning()
+	if _, send := ir.UpdateValue(); !send {
+		t.Fatal("expected send=true after starting learn mode")
+	}
+	ir.PublishValue(client)
+	if len(client.published) != 1 || client.published[0].payload != `{"learn_ir_code":"ON"}` {
+		t.Fatalf("unexpected messages %v", client.published)
+	}
+
+	handler(nil, &mockMessage{payload: []byte(`{"learn_ir_code":"ON","linkquality":80}`)})
+	if _, learning := ir.GetLearnedCode(); !learning {
+		t.Error("expected device to still be learning")
+	}
+	handler(nil, &mockMessage{payload: []byte(`{"learned_ir_code":"IR_NEW"}`)})
+	code, learning := ir.GetLearnedCode()
+	if learning || code != "IR_NEW" {
+		t.Errorf("expected learned code IR_NEW, got %s (learning=%v)", code, learning)
+	}
+
+	ir.SaveCommand("new", code)
+	ir.ProcessRequest(core.SwitchRequest{Value: "new"})
+	if ir.nextRequest == nil || ir.nextRequest.IrCode != "IR_NEW" {
+		t.Error("expected saved command to be sendable")
+	}
+}
+
+func irControlStateConfig(t *testing.T) core.DeviceConfig {
+	t.Helper()
+	raw := `name: tv
+topic: ir/tv
+options:
+  commands:
+    power: IR_POWER
+    hdmi2: IR_HDMI2
+    vol_up: IR_VOL_UP
+  macros:
+    console:
+      - command: power
+      - delay: 0.1
+      - command: hdmi2
+  virtual_state:
+    power:
+      power: toggle
+    hdmi2:
+      input: hdmi2
+    vol_up:
+      volume: "+1"`
+	var cfg core.DeviceConfig
+	if err := yaml.Unmarshal([]byte(raw), &cfg); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	return cfg
+}
+
+func TestIRControl_VirtualState(t *testing.T) {
+	ir := NewIrControl(irControlStateConfig(t))
+	client := &mockClient{}
+
+	if triggers := ir.GetTriggers(); len(triggers) != 4 || triggers[0] != "input" || triggers[1] != "power" || triggers[2] != "volume" {
+		t.Fatalf("expected state keys as triggers, got %v", triggers)
+	}
+
+	ir.ProcessRequest(core.SwitchRequest{Value: "vol_up"})
+	ir.PublishValue(client)
+	ir.ProcessRequest(core.SwitchRequest{Value: "vol_up", Force: true})
+	ir.PublishValue(client)
+	if ir.State["volume"] != 2.0 {
+		t.Errorf("expected volume 2, got %v", ir.State["volume"])
+	}
+
+	// setting a state key sends the command that leads to it
+	ir.ProcessRequest(core.SwitchRequest{Key: "power", Value: "on"})
+	if ir.nextRequest == nil || ir.nextRequest.IrCode != "IR_POWER" {
+		t.Fatal("expected power command to be queued")
+	}
+	ir.PublishValue(client)
+	if ir.State["power"] != "on" {
+		t.Errorf("expected power on, got %v", ir.State["power"])
+	}
+
+	// nothing is sent if the state already matches
+	ir.ProcessRequest(core.SwitchRequest{Key: "power", Value: "on"})
+	if ir.nextRequest != nil {
+		t.Error("expected no request when state already matches")
+	}
+}
+
+func TestIRControl_Macro(t *testing.T) {
+	ir := NewIrControl(irControlStateConfig(t))
+	client := &mockClient{}
+
+	ir.ProcessRequest(core.SwitchRequest{Key: "macro", Value: "console"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(client.published) < 2 && time.Now().Before(deadline) {
+		if _, send := ir.UpdateValue(); send {
+			ir.PublishValue(client)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(client.published) != 2 {
+		t.Fatalf("expected two messages, got %v", client.published)
+	}
+	if client.published[0].payload != `{"ir_code_to_send":"IR_POWER"}` || client.published[1].payload != `{"ir_code_to_send":"IR_HDMI2"}` {
+		t.Errorf("unexpected messages %v", client.published)
+	}
+	if ir.State["input"] != "hdmi2" || ir.State["power"] != "on" {
+		t.Errorf("unexpected state %v", ir.State)
+	}
+}
+
+// slowClient takes longer to publish than a macro waits between its checks for a pending command
+type slowClient struct {
+	mockClient
+}
+
+func (c *slowClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	time.Sleep(core.CycleLength * 3 / 2 * time.Millisecond)
+	return c.mockClient.Publish(topic, qos, retained, payload)
+}
+
+func TestIRControl_MacroWithPreventResending(t *testing.T) {
+	cfg := irControlStateConfig(t)
+	cfg.Options.PreventResending = true
+	cfg.Options.Macros = &map[string][]core.MacroStep{"quick": {{Command: "power"}, {Command: "hdmi2"}}}
+	ir := NewIrControl(cfg)
+	client := &slowClient{}
+
+	// the macro queues its next command while the event loop is still publishing, run with -race
+	ir.ProcessRequest(core.SwitchRequest{Key: "macro", Value: "quick"})
+	deadline := time.Now().Add(3 * time.Second)
+	for len(client.published) < 2 && time.Now().Before(deadline) {
+		if _, send := ir.UpdateValue(); send {
+			ir.PublishValue(client)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(client.published) != 2 {
+		t.Fatalf("expected two messages, got %v", client.published)
+	}
+}
+
+func tasmotaIrConfig(t *testing.T) core.DeviceConfig {
+	t.Helper()
+	raw := `name: aircon
+topic: cmnd/livingroom/ir
+options:
+  protocol: tasmota
+  commands:
+    light: "NEC:32:0x20DF10EF"
+    json: '{"Protocol":"SONY","Bits":12,"Data":"0xA90"}'
+    broken: "NEC"
+  hvac:
+    vendor: MITSUBISHI_AC
+    max_temp: 28`
+	var cfg core.DeviceConfig
+	if err := yaml.Unmarshal([]byte(raw), &cfg); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	return cfg
+}
+
+func TestIRControl_TasmotaIRsend(t *testing.T) {
+	ir := NewIrControl(tasmotaIrConfig(t))
+	client := &mockClient{}
+
+	if ir.GetMqttStateTopic() != "tele/livingroom/ir/RESULT" {
+		t.Errorf("unexpected state topic %s", ir.GetMqttStateTopic())
+	}
+
+	ir.ProcessRequest(core.SwitchRequest{Value: "light"})
+	ir.PublishValue(client)
+	ir.ProcessRequest(core.SwitchRequest{Value: "json"})
+	ir.PublishValue(client)
+	ir.ProcessRequest(core.SwitchRequest{Value: "broken"})
+	ir.PublishValue(client)
+
+	expected := []publishedMessage{
+		{topic: "cmnd/livingroom/ir/IRsend", payload: `{"Protocol":"NEC","Bits":32,"Data":"0x20DF10EF"}`},
+		{topic: "cmnd/livingroom/ir/IRsend", payload: `{"Protocol":"SONY","Bits":12,"Data":"0xA90"}`},
+	}
+	if len(client.published) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, client.published)
+	}
+	for n := range expected {
+		if client.published[n] != expected[n] {
+			t.Errorf("expected %v, got %v", expected[n], client.published[n])
+		}
+	}
+}
+
+func TestIRControl_TasmotaLearn(t *testing.T) {
+	ir := NewIrControl(tasmotaIrConfig(t))
+	handler := ir.GetMessageHandler(nil, ir)
+
+	ir.StartLearning()
+	if _, send := ir.UpdateValue(); send {
+		t.Error("expected no learn message for tasmota")
+	}
+	handler(nil, &mockMessage{payload: []byte(`{"IrReceived":{"Protocol":"NEC","Bits":32,"Data":"0x00FF00FF"}}`)})
+	code, learning := ir.GetLearnedCode()
+	if learning || code != "NEC:32:0x00FF00FF" {
+		t.Errorf("expected learned code NEC:32:0x00FF00FF, got %s (learning=%v)", code, learning)
+	}
+}
+
+func TestIRControl_TasmotaHvac(t *testing.T) {
+	ir := NewIrControl(tasmotaIrConfig(t))
+	client := &mockClient{}
+
+	if ir.Icon != "ac.png" {
+		t.Errorf("expected ac icon, got %s", ir.Icon)
+	}
+
+	ir.ProcessRequest(core.SwitchRequest{Key: "power", Value: "on"})
+	ir.ProcessRequest(core.SwitchRequest{Key: "mode", Value: "Cool"})
+	ir.ProcessRequest(core.SwitchRequest{Key: "temperature", Value: "+10"})
+	ir.ProcessRequest(core.SwitchRequest{Key: "fan", Value: "turbo"})
+	if _, send := ir.UpdateValue(); !send {
+		t.Fatal("expected send=true after changing the hvac state")
+	}
+	ir.PublishValue(client)
+
+	if len(client.published) != 1 {
+		t.Fatalf("expected one message, got %v", client.published)
+	}
+	expected := publishedMessage{
+		topic:   "cmnd/livingroom/ir/IRhvac",
+		payload: `{"Vendor":"MITSUBISHI_AC","Power":"On","Mode":"Cool","FanSpeed":"Auto","Temp":28,"Celsius":"On"}`,
+	}
+	if client.published[0] != expected {
+		t.Errorf("expected %v, got %v", expected, client.published[0])
+	}
+
+	// unchanged values are not sent again
+	ir.ProcessRequest(core.SwitchRequest{Key: "mode", Value: "cool"})
+	if _, send := ir.UpdateValue(); send {
+		t.Error("expected no message for an unchanged value")
+	}
+}
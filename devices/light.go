@@ -2,9 +2,9 @@ package devices
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"math"
-	"regexp"
 	"strconv"
 	"time"
 
@@ -15,6 +15,7 @@ import (
 
 type Light struct {
 	Dimmable
+	curve brightnessCurve
 }
 
 type lightStateMessage struct {
@@ -29,15 +30,14 @@ func NewLight(config core.DeviceConfig) *Light {
 
 	d.persistentFields = []string{"brightness", "value"}
 
-	var re = regexp.MustCompile("^cmnd/(.+)$")
-	d.MqttState = re.ReplaceAllString(d.MqttTopic, "tele/$1/STATE")
+	d.setStateTopic(tasmotaCommandTopic.ReplaceAllString(d.MqttTopic, "tele/$1/STATE"))
 
 	d.Target = 0
 
 	d.Min = 0
 	d.Max = 100
 
-	d.Receivers = []string{"brightness", "duration"}
+	d.Receivers = []string{"brightness", "duration", "easing"}
 
 	if config.Options != nil {
 		if config.Options.Min != nil {
@@ -47,6 +47,11 @@ func NewLight(config core.DeviceConfig) *Light {
 			d.Max = *config.Options.Max
 		}
 	}
+	d.curve = newBrightnessCurve(d.Name, config.Options)
+	d.Adaptive = newAdaptive(d.Name, config.Options)
+	if d.Adaptive != nil {
+		d.Receivers = append(d.Receivers, "adaptive")
+	}
 
 	tt := time.Now()
 	d.LastChanged = &tt
@@ -67,6 +72,8 @@ func (l *Light) SetReceiverValue(key string, value interface{}) {
 	case "duration":
 		duration := value.(int)
 		log.Printf("[%32s] Setting duration to %d seconds\n", l.GetName(), duration)
+	case "easing":
+		log.Printf("[%32s] Setting easing to %v\n", l.GetName(), value)
 	}
 }
 
@@ -108,11 +115,29 @@ func (l *Light) GetMessageHandler(chan core.SwitchRequest, DeviceInterface) mqtt
 	}
 }
 
+func (l *Light) enableAdaptive(sun *DimmyTime) {
+	if l.Adaptive == nil {
+		return
+	}
+	l.Adaptive.sun = sun
+	l.Adaptive.apply = func(brightness float64, _ int, duration int) {
+		l.fade(core.SwitchRequest{Value: fmt.Sprintf("%f", brightness), Duration: duration})
+	}
+}
+
+func (l *Light) followGroup(value float64) {
+	l.setTarget(value)
+	l.SetCurrent(value)
+	l.LastSent = l.PercentageToValue(value)
+}
+
+// PercentageToValue converts a perceived brightness to the value sent to the device. 1% is always
+// the lowest value the device can display, the curve is applied above that.
 func (l *Light) PercentageToValue(percentage float64) int {
 	if percentage <= 1.0 {
 		return l.GetMin() + int(math.Round(percentage))
 	}
-	return l.GetMin() + 1 + int(float64(l.GetMax()-l.GetMin()-1)*(percentage-1)/99)
+	return l.GetMin() + 1 + int(float64(l.GetMax()-l.GetMin()-1)*l.curve.toOutput((percentage-1)/99))
 }
 
 func (l *Light) ValueToPercentage(value int) float64 {
@@ -125,7 +150,7 @@ func (l *Light) ValueToPercentage(value int) float64 {
 	if value >= l.GetMax() {
 		return 100
 	}
-	return 1 + float64(value-l.GetMin()-1)*99/float64(l.GetMax()-l.GetMin()-1)
+	return 1 + l.curve.toPerceived(float64(value-l.GetMin()-1)/float64(l.GetMax()-l.GetMin()-1))*99
 }
 
 func (d *Light) GetConfig(name string) core.DeviceConfig {
@@ -137,6 +162,7 @@ func (d *Light) GetConfig(name string) core.DeviceConfig {
 		Options: &core.ConfigOptions{
 			Transition: &d.transition,
 			Hidden:     &d.Hidden,
+			StateTopic: d.stateTopic,
 		},
 	}
 	return config
@@ -0,0 +1,142 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MqttApi publishes the state of every device as one retained JSON message to <base>/<device>/state
+// and accepts commands on <base>/<device>/set, so everything /api/switch can do also works over MQTT.
+// The state holds the latest value of every trigger and "value" for devices that don't report it themselves.
+type MqttApi struct {
+	base      string
+	mutex     sync.Mutex
+	states    map[string]map[string]any
+	published map[string]string
+}
+
+// mqttCommand is the payload of a set topic. Value can also be a number or boolean.
+type mqttCommand struct {
+	Key      string  `json:"key"`
+	Command  string  `json:"command"`
+	Value    any     `json:"value"`
+	Duration float64 `json:"duration"`
+	Easing   string  `json:"easing"`
+}
+
+func NewMqttApi(base string, devices map[string]DeviceInterface) *MqttApi {
+	a := &MqttApi{
+		base:      strings.TrimSuffix(base, "/"),
+		states:    make(map[string]map[string]any),
+		published: make(map[string]string),
+	}
+	for name, device := range devices {
+		if device.IsPseudoDevice() {
+			continue
+		}
+		if strings.ContainsAny(name, "/+#") {
+			log.Printf("[%32s] Not available over MQTT, the name contains / + or #\n", name)
+			continue
+		}
+		a.states[name] = make(map[string]any)
+		device.Observe(func(field string, value any) {
+			a.mutex.Lock()
+			a.states[name][field] = value
+			a.mutex.Unlock()
+		})
+	}
+	return a
+}
+
+// OwnsTopic tells whether topic is one of the API's, so these messages aren't mistaken for new devices
+func (a *MqttApi) OwnsTopic(topic string) bool {
+	return strings.HasPrefix(topic, a.base+"/")
+}
+
+// PublishStates publishes the state of every device that changed since it was last published
+func (a *MqttApi) PublishStates(client mqtt.Client, devices map[string]DeviceInterface) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for name, fields := range a.states {
+		device, ok := devices[name]
+		if !ok {
+			continue
+		}
+		state := make(map[string]any, len(fields)+1)
+		for field, value := range fields {
+			state[field] = value
+		}
+		if _, ok := state["value"]; !ok {
+			state["value"] = device.GetCurrent()
+		}
+		payload, err := json.Marshal(state)
+		if err != nil || string(payload) == a.published[name] {
+			continue
+		}
+		a.published[name] = string(payload)
+		client.Publish(a.base+"/"+name+"/state", 0, true, payload)
+	}
+}
+
+// Subscriptions returns the handler of the set topics, which passes requests on to channel
+func (a *MqttApi) Subscriptions(channel chan core.SwitchRequest) map[string]mqtt.MessageHandler {
+	handler := func(client mqtt.Client, message mqtt.Message) {
+		name := strings.TrimSuffix(strings.TrimPrefix(message.Topic(), a.base+"/"), "/set")
+		a.mutex.Lock()
+		_, ok := a.states[name]
+		a.mutex.Unlock()
+		if !ok {
+			log.Printf("Can't find device for MQTT command on %s\n", message.Topic())
+			return
+		}
+		request, err := parseMqttCommand(message.Payload())
+		if err != nil {
+			log.Printf("[%32s] Ignoring MQTT command %s: %s\n", name, message.Payload(), err)
+			return
+		}
+		request.Device = name
+		channel <- request
+	}
+	return map[string]mqtt.MessageHandler{a.base + "/+/set": handler}
+}
+
+// parseMqttCommand accepts a JSON object like /api/switch does, or a plain value
+func parseMqttCommand(payload []byte) (core.SwitchRequest, error) {
+	request := core.SwitchRequest{Force: true}
+	text := strings.TrimSpace(string(payload))
+	if !strings.HasPrefix(text, "{") {
+		request.Value = text
+		return request, nil
+	}
+	var command mqttCommand
+	if err := json.Unmarshal(payload, &command); err != nil {
+		return request, err
+	}
+	switch v := command.Value.(type) {
+	case nil:
+	case string:
+		request.Value = v
+	case float64:
+		request.Value = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		request.Value = strconv.FormatBool(v)
+	default:
+		return request, errors.New("value must be a string, number or boolean")
+	}
+	if command.Duration < 0 {
+		return request, fmt.Errorf("invalid duration %v", command.Duration)
+	}
+	request.Key = command.Key
+	request.Command = command.Command
+	request.Duration = int(command.Duration)
+	request.Easing = command.Easing
+	return request, nil
+}
@@ -0,0 +1,47 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMqttApi_PublishStates(t *testing.T) {
+	number := newTestHelper(HelperNumber, core.HelperConfig{})
+	plug := NewPlug(core.DeviceConfig{Name: "Fan", Type: "plug", Topic: "cmnd/fan/POWER"})
+	devices := map[string]DeviceInterface{"counter": number, "Fan": plug, "time": NewDimmyTime(core.DeviceConfig{Name: "time"}, 0, 0)}
+	api := NewMqttApi("dimmy/", devices)
+	client := &mockClient{}
+
+	api.PublishStates(client, devices)
+	assert.ElementsMatch(t, []publishedMessage{
+		{topic: "dimmy/counter/state", payload: `{"value":0}`},
+		{topic: "dimmy/Fan/state", payload: `{"value":0}`},
+	}, client.published)
+
+	client.published = nil
+	number.ProcessRequest(core.SwitchRequest{Value: "7"})
+	api.PublishStates(client, devices)
+	api.PublishStates(client, devices)
+	assert.Equal(t, []publishedMessage{{topic: "dimmy/counter/state", payload: `{"value":7}`}}, client.published)
+}
+
+func TestMqttApi_ParseCommand(t *testing.T) {
+	request, err := parseMqttCommand([]byte(`{"key":"brightness","value":40,"duration":10,"easing":"ease-in"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, core.SwitchRequest{Key: "brightness", Value: "40", Duration: 10, Easing: "ease-in", Force: true}, request)
+
+	request, err = parseMqttCommand([]byte(`{"value":true}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "true", request.Value)
+
+	request, err = parseMqttCommand([]byte(" 75\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, core.SwitchRequest{Value: "75", Force: true}, request)
+
+	_, err = parseMqttCommand([]byte(`{"value":[1,2]}`))
+	assert.Error(t, err)
+	_, err = parseMqttCommand([]byte(`{"value":`))
+	assert.Error(t, err)
+}
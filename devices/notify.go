@@ -0,0 +1,351 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	NotifySmtp    = "smtp"
+	NotifyWebhook = "webhook"
+	NotifyMqtt    = "mqtt"
+	NotifyNtfy    = "ntfy"
+	NotifyGotify  = "gotify"
+
+	defaultNotifyTimeout = 10 // seconds
+	defaultRatePeriod    = 60 // seconds
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+type notifyChannel struct {
+	name   string
+	config core.NotifyChannel
+	mutex  sync.Mutex
+	sent   []time.Time
+}
+
+// allow records a message if the channel's rate limit permits it
+func (c *notifyChannel) allow(now time.Time) bool {
+	if c.config.RateLimit == nil {
+		return true
+	}
+	period := time.Duration(defaultRatePeriod) * time.Second
+	if c.config.RatePeriod != nil {
+		period = time.Duration(*c.config.RatePeriod) * time.Second
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	recent := c.sent[:0]
+	for _, t := range c.sent {
+		if now.Sub(t) < period {
+			recent = append(recent, t)
+		}
+	}
+	c.sent = recent
+	if len(c.sent) >= *c.config.RateLimit {
+		return false
+	}
+	c.sent = append(c.sent, now)
+	return true
+}
+
+func (c *notifyChannel) validate() error {
+	switch c.config.Type {
+	case NotifySmtp:
+		if c.config.Host == "" || c.config.From == "" || len(c.config.To) == 0 {
+			return errors.New("smtp needs host, from and to")
+		}
+	case NotifyWebhook, NotifyNtfy, NotifyGotify:
+		if c.config.URL == "" {
+			return fmt.Errorf("%s needs a url", c.config.Type)
+		}
+	case NotifyMqtt:
+		if c.config.Topic == "" {
+			return errors.New("mqtt needs a topic")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", c.config.Type)
+	}
+	return nil
+}
+
+type notifyMessage struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+type pendingPublish struct {
+	topic   string
+	payload []byte
+}
+
+type Notify struct {
+	Device
+
+	title    string
+	channels map[string]*notifyChannel
+	client   *http.Client
+	sendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+	pending  []pendingPublish
+
+	LastMessage string `json:"lastMessage,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+}
+
+func NewNotify(config core.DeviceConfig) *Notify {
+	n := Notify{}
+	n.setBaseConfig(config)
+	if config.Icon == "" {
+		n.Icon = "🔔"
+	}
+	n.Type = "notify"
+	n.title = "Dimmy"
+	n.channels = make(map[string]*notifyChannel)
+	n.client = &http.Client{}
+	n.sendMail = smtp.SendMail
+
+	if config.Options != nil && config.Options.Notify != nil {
+		if config.Options.Notify.Title != "" {
+			n.title = config.Options.Notify.Title
+		}
+		for name, channelConfig := range config.Options.Notify.Channels {
+			channel := &notifyChannel{name: name, config: channelConfig}
+			if err := channel.validate(); err != nil {
+				log.Printf("[%32s] Skipping channel %s: %s\n", n.Name, name, err.Error())
+				continue
+			}
+			n.channels[name] = channel
+		}
+	}
+
+	n.Receivers = append([]string{"message"}, sortedKeys(n.channels)...)
+	n.Triggers = []string{"message", "failed"}
+
+	return &n
+}
+
+// ProcessRequest sends request.Value through all channels ("message") or a single one (the channel's name)
+func (n *Notify) ProcessRequest(request core.SwitchRequest) error {
+	key := request.Key
+	if key == "" {
+		key = request.Command
+	}
+	message := notifyMessage{Title: n.title, Message: request.Value}
+	if key == "message" {
+		for _, name := range sortedKeys(n.channels) {
+			go n.send(n.channels[name], message)
+		}
+		return nil
+	}
+	channel, ok := n.channels[key]
+	if !ok {
+		return fmt.Errorf("no channel %s, please define it in the config file", key)
+	}
+	go n.send(channel, message)
+	return nil
+}
+
+func (n *Notify) send(channel *notifyChannel, message notifyMessage) {
+	if !channel.allow(time.Now()) {
+		log.Printf("[%32s] Not sending through %s: %s\n", n.Name, channel.name, ErrRateLimited.Error())
+		return
+	}
+	if err := n.deliver(channel, message); err != nil {
+		log.Printf("[%32s] Sending through %s failed: %s\n", n.Name, channel.name, err.Error())
+		n.mutex.Lock()
+		n.LastError = channel.name + ": " + err.Error()
+		n.mutex.Unlock()
+		n.UpdateRules("failed", channel.name)
+		return
+	}
+	log.Printf("[%32s] Sent through %s: %s\n", n.Name, channel.name, message.Message)
+	n.mutex.Lock()
+	n.LastMessage = message.Message
+	n.mutex.Unlock()
+	n.UpdateRules("message", message.Message)
+}
+
+// Test sends a test message through the given channel, or all channels if it is empty, ignoring rate limits
+func (n *Notify) Test(name string) error {
+	message := notifyMessage{Title: n.title, Message: "Test message from " + n.GetLabel()}
+	if name != "" {
+		channel, ok := n.channels[name]
+		if !ok {
+			return fmt.Errorf("unknown channel %s", name)
+		}
+		return n.deliver(channel, message)
+	}
+	var errs []error
+	for _, name := range sortedKeys(n.channels) {
+		if err := n.deliver(n.channels[name], message); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notify) deliver(channel *notifyChannel, message notifyMessage) error {
+	c := channel.config
+	switch c.Type {
+	case NotifySmtp:
+		return n.deliverMail(c, message)
+	case NotifyMqtt:
+		payload, _ := json.Marshal(message)
+		n.mutex.Lock()
+		n.pending = append(n.pending, pendingPublish{topic: c.Topic, payload: payload})
+		n.mutex.Unlock()
+		return nil
+	}
+
+	req, err := buildNotifyRequest(c, message)
+	if err != nil {
+		return err
+	}
+	timeout := defaultNotifyTimeout
+	if c.Timeout != nil {
+		timeout = *c.Timeout
+	}
+	client := *n.client
+	client.Timeout = time.Duration(timeout) * time.Second
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func buildNotifyRequest(c core.NotifyChannel, message notifyMessage) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	switch c.Type {
+	case NotifyNtfy:
+		req, err = http.NewRequest(http.MethodPost, c.URL, strings.NewReader(message.Message))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", headerValue(message.Title))
+		if c.Priority != nil {
+			req.Header.Set("Priority", strconv.Itoa(*c.Priority))
+		}
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+	case NotifyGotify:
+		payload := map[string]any{"title": message.Title, "message": message.Message}
+		if c.Priority != nil {
+			payload["priority"] = *c.Priority
+		}
+		body, _ := json.Marshal(payload)
+		req, err = http.NewRequest(http.MethodPost, strings.TrimSuffix(c.URL, "/")+"/message", strings.NewReader(string(body)))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", c.Token)
+	case NotifyWebhook:
+		body, _ := json.Marshal(message)
+		contentType := "application/json"
+		if c.Body != "" {
+			for key, value := range c.Headers {
+				if strings.EqualFold(key, "Content-Type") {
+					contentType = value
+				}
+			}
+			var escape func(string) string
+			if strings.Contains(strings.ToLower(contentType), "json") {
+				escape = func(s string) string {
+					quoted, _ := json.Marshal(s)
+					return string(quoted[1 : len(quoted)-1])
+				}
+			}
+			substituted, err := substituteParams(c.Body, map[string]string{"title": message.Title, "message": message.Message}, escape)
+			if err != nil {
+				return nil, err
+			}
+			body = []byte(substituted)
+		}
+		method := strings.ToUpper(c.Method)
+		if method == "" {
+			method = http.MethodPost
+		}
+		req, err = http.NewRequest(method, c.URL, strings.NewReader(string(body)))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		for key, value := range c.Headers {
+			req.Header.Set(key, value)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", c.Type)
+	}
+	return req, nil
+}
+
+func (n *Notify) deliverMail(c core.NotifyChannel, message notifyMessage) error {
+	port := c.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+	var mail strings.Builder
+	mail.WriteString("From: " + headerValue(c.From) + "\r\n")
+	mail.WriteString("To: " + headerValue(strings.Join(c.To, ", ")) + "\r\n")
+	mail.WriteString("Subject: " + headerValue(message.Title) + "\r\n")
+	mail.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	mail.WriteString("MIME-Version: 1.0\r\n")
+	mail.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	mail.WriteString(strings.ReplaceAll(message.Message, "\n", "\r\n") + "\r\n")
+	return n.sendMail(net.JoinHostPort(c.Host, strconv.Itoa(port)), auth, c.From, c.To, []byte(mail.String()))
+}
+
+// headerValue keeps values from adding headers of their own
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+}
+
+func (n *Notify) UpdateValue() (float64, bool) {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return 0, len(n.pending) > 0
+}
+
+func (n *Notify) PublishValue(client mqtt.Client) {
+	n.mutex.Lock()
+	pending := n.pending
+	n.pending = nil
+	n.mutex.Unlock()
+	for _, message := range pending {
+		client.Publish(message.topic, 0, false, message.payload)
+	}
+}
+
+func (n *Notify) GetChannels() []string {
+	return sortedKeys(n.channels)
+}
+
+func (n *Notify) IsPseudoDevice() bool {
+	return true
+}
@@ -0,0 +1,148 @@
+package devices
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func notifyConfig(t *testing.T, url string) core.DeviceConfig {
+	t.Helper()
+	raw := `name: notify
+type: notify
+options:
+  notify:
+    title: Home
+    channels:
+      phone:
+        type: ntfy
+        url: ` + url + `/dimmy
+        token: t0ken
+        priority: 5
+        rate_limit: 1
+      gotify:
+        type: gotify
+        url: ` + url + `/
+        token: app
+      hook:
+        type: webhook
+        url: ` + url + `/hook
+        body: '{"text": "{{title}}: {{message}}"}'
+      mail:
+        type: smtp
+        host: mail.example.com
+        from: dimmy@example.com
+        to: [me@example.com]
+      display:
+        type: mqtt
+        topic: dimmy/notifications
+      broken:
+        type: pager`
+	var cfg core.DeviceConfig
+	if err := yaml.Unmarshal([]byte(raw), &cfg); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	return cfg
+}
+
+type notifyCall struct {
+	path    string
+	headers http.Header
+	body    string
+}
+
+func notifyServer(t *testing.T) (*httptest.Server, chan notifyCall) {
+	calls := make(chan notifyCall, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- notifyCall{path: r.URL.Path, headers: r.Header, body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+	return server, calls
+}
+
+func TestNotify_Channels(t *testing.T) {
+	server, calls := notifyServer(t)
+	n := NewNotify(notifyConfig(t, server.URL))
+	assert.Equal(t, []string{"display", "gotify", "hook", "mail", "phone"}, n.GetChannels(), "invalid channels are skipped")
+
+	var mail string
+	n.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equal(t, "mail.example.com:587", addr)
+		mail = string(msg)
+		return nil
+	}
+	assert.NoError(t, n.Test(""))
+
+	received := make(map[string]notifyCall)
+	for i := 0; i < 3; i++ {
+		call := <-calls
+		received[call.path] = call
+	}
+	assert.Equal(t, "Test message from notify", received["/dimmy"].body)
+	assert.Equal(t, "Home", received["/dimmy"].headers.Get("Title"))
+	assert.Equal(t, "5", received["/dimmy"].headers.Get("Priority"))
+	assert.Equal(t, "Bearer t0ken", received["/dimmy"].headers.Get("Authorization"))
+	assert.Equal(t, "app", received["/message"].headers.Get("X-Gotify-Key"))
+	assert.JSONEq(t, `{"title": "Home", "message": "Test message from notify"}`, received["/message"].body)
+	assert.Equal(t, `{"text": "Home: Test message from notify"}`, received["/hook"].body)
+	assert.Contains(t, mail, "Subject: Home\r\n")
+	assert.Contains(t, mail, "To: me@example.com\r\n")
+
+	client := &mockClient{}
+	_, publish := n.UpdateValue()
+	assert.True(t, publish)
+	n.PublishValue(client)
+	assert.Equal(t, "dimmy/notifications", client.published[0].topic)
+}
+
+func TestNotify_RateLimit(t *testing.T) {
+	server, calls := notifyServer(t)
+	n := NewNotify(notifyConfig(t, server.URL))
+	rule := &Rule{Triggers: []Trigger{{Device: n, Key: "message", Condition: &condition{}}}}
+	n.AddRule(rule)
+
+	n.send(n.channels["phone"], notifyMessage{Title: "Home", Message: "first"})
+	n.send(n.channels["phone"], notifyMessage{Title: "Home", Message: "second"})
+	assert.Equal(t, "first", (<-calls).body)
+	select {
+	case call := <-calls:
+		t.Fatalf("rate limited message was sent: %v", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, "first", rule.Triggers[0].Condition.LastValue)
+	assert.True(t, n.channels["phone"].allow(time.Now().Add(time.Minute)))
+}
+
+func TestNotify_HeadersCannotBeInjected(t *testing.T) {
+	req, err := buildNotifyRequest(core.NotifyChannel{Type: NotifyNtfy, URL: "http://localhost/x"}, notifyMessage{Title: "a\r\nX-Evil: 1", Message: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "a X-Evil: 1", req.Header.Get("Title"))
+	assert.Empty(t, req.Header.Get("X-Evil"))
+}
+
+func TestRule_ExpandTemplate(t *testing.T) {
+	sensor := NewDevice(core.DeviceConfig{Name: "leak", Label: "Bathroom", Topic: "zigbee/leak"})
+	n := NewNotify(notifyConfig(t, "http://localhost"))
+	earlier := time.Now().Add(-time.Hour)
+	now := time.Now()
+	rule := &Rule{
+		Triggers: []Trigger{
+			{Device: n, Key: "failed", Condition: &condition{LastValue: "phone", LastChanged: &earlier}},
+			{Device: sensor, Key: "water_leak", Condition: &condition{LastValue: true, LastChanged: &now}},
+		},
+		Receivers: []Receiver{{Device: n, Key: "message", Value: "{{key}} in {{device}}: {{value}} {{unknown}}"}},
+	}
+	channel := make(chan core.SwitchRequest, 1)
+	rule.Fire(channel)
+	request := <-channel
+	assert.Equal(t, "water_leak in Bathroom: true {{unknown}}", request.Value)
+	assert.Equal(t, "notify", request.Device)
+}
@@ -15,19 +15,47 @@ type Plug struct {
 	needsSending bool
 	Min          int `json:"-"`
 	Max          int `json:"-"`
+	// POWER, or POWER<n> for relay n of a device with several
+	powerKey string
+	protocol string
 }
 
-type plugStateMessage struct {
-	Value string `json:"POWER"`
-}
+const (
+	PlugProtocolTasmota     = "tasmota"
+	PlugProtocolZigbee2Mqtt = "zigbee2mqtt"
+)
+
+var plugTopic = regexp.MustCompile("^cmnd/(.+)/(POWER[0-9]*)$")
+
+// the relay is the last part of the command topic, also with a custom FullTopic such as kitchen/cmnd/POWER2
+var plugPowerKey = regexp.MustCompile("/(POWER[0-9]*)$")
 
 func makePlug(config core.DeviceConfig) Plug {
 	p := Plug{}
 	p.Icon = "🔌"
 	p.setBaseConfig(config)
 
-	var re = regexp.MustCompile("^cmnd/(.+)/POWER$")
-	p.MqttState = re.ReplaceAllString(p.MqttTopic, "tele/$1/STATE")
+	p.protocol = PlugProtocolTasmota
+	if config.Options != nil && config.Options.Protocol != nil {
+		switch *config.Options.Protocol {
+		case PlugProtocolTasmota, PlugProtocolZigbee2Mqtt:
+			p.protocol = *config.Options.Protocol
+		default:
+			log.Printf("[%32s] Unknown plug protocol %s, using %s\n", p.Name, *config.Options.Protocol, p.protocol)
+		}
+	}
+
+	if p.protocol == PlugProtocolZigbee2Mqtt {
+		// zigbee2mqtt reports the state on the device's topic and takes commands on <topic>/set
+		p.setStateTopic(p.MqttTopic)
+		p.powerKey = "state"
+	} else {
+		p.setStateTopic(plugTopic.ReplaceAllString(p.MqttTopic, "tele/$1/STATE"))
+		p.powerKey = "POWER"
+		if match := plugPowerKey.FindStringSubmatch(p.MqttTopic); match != nil {
+			p.powerKey = match[1]
+		}
+	}
 
 	p.Receivers = []string{"state"}
 
@@ -44,7 +72,16 @@ func NewPlug(config core.DeviceConfig) *Plug {
 }
 
 func (p *Plug) PublishValue(mqtt mqtt.Client) {
-	mqtt.Publish(p.MqttTopic, 0, false, strconv.Itoa(int(math.Round(p.Current))))
+	if p.protocol == PlugProtocolZigbee2Mqtt {
+		state := "OFF"
+		if math.Round(p.Current) > 0 {
+			state = "ON"
+		}
+		s, _ := json.Marshal(map[string]string{"state": state})
+		mqtt.Publish(p.MqttTopic+"/set", 0, false, s)
+	} else {
+		mqtt.Publish(p.MqttTopic, 0, false, strconv.Itoa(int(math.Round(p.Current))))
+	}
 	p.needsSending = false
 }
 
@@ -63,7 +100,7 @@ func (p *Plug) GetMin() int {
 	return p.Min
 }
 
-func (p *Plug) ProcessRequest(request core.SwitchRequest) {
+func (p *Plug) ProcessRequest(request core.SwitchRequest) error {
 	val, _ := strconv.ParseFloat(request.Value, 64)
 	if val != p.Current {
 		p.Current = val
@@ -75,20 +112,22 @@ func (p *Plug) ProcessRequest(request core.SwitchRequest) {
 		}
 		p.needsSending = true
 	}
+	return nil
 }
 
 func (p *Plug) GetMessageHandler(channel chan core.SwitchRequest, plug DeviceInterface) mqtt.MessageHandler {
 	return func(client mqtt.Client, mqttMessage mqtt.Message) {
 		payload := mqttMessage.Payload()
 
-		var message plugStateMessage
+		var message map[string]any
 		err := json.Unmarshal(payload, &message)
 		if err != nil {
 			log.Println("Could parse status message from plug: " + err.Error())
 		}
 
-		log.Printf("[%32s] Received state Value %s\n", p.GetName(), message.Value)
-		if message.Value == "ON" {
+		value := message[p.powerKey]
+		log.Printf("[%32s] Received state Value %v\n", p.GetName(), value)
+		if value == "ON" {
 			p.SetCurrent(1)
 		} else {
 			p.SetCurrent(0)
@@ -96,6 +135,15 @@ func (p *Plug) GetMessageHandler(channel chan core.SwitchRequest, plug DeviceInt
 	}
 }
 
+func (p *Plug) followGroup(value float64) {
+	p.needsSending = false
+	if value > 0 {
+		p.SetCurrent(1)
+	} else {
+		p.SetCurrent(0)
+	}
+}
+
 func (p *Plug) PercentageToValue(percentage float64) int {
 	if percentage <= 0.99 {
 		return 0
@@ -116,3 +164,20 @@ func (p *Plug) SetReceiverValue(key string, value interface{}) {
 	}
 	p.ProcessRequest(core.SwitchRequest{Device: p.Name, Value: value.(string)})
 }
+
+func (p *Plug) GetConfig(name string) core.DeviceConfig {
+	config := core.DeviceConfig{
+		Name:  name,
+		Type:  "plug",
+		Icon:  p.Icon,
+		Topic: p.MqttTopic,
+		Options: &core.ConfigOptions{
+			Hidden:     &p.Hidden,
+			StateTopic: p.stateTopic,
+		},
+	}
+	if p.protocol != PlugProtocolTasmota {
+		config.Options.Protocol = core.ToPtr(p.protocol)
+	}
+	return config
+}
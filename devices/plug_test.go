@@ -0,0 +1,22 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlug_Tasmota(t *testing.T) {
+	plug := NewPlug(core.DeviceConfig{Name: "Speaker", Type: "plug", Topic: "cmnd/kidsroom/music/POWER2"})
+	assert.Equal(t, "tele/kidsroom/music/STATE", plug.GetMqttStateTopic())
+	assert.Nil(t, plug.GetConfig("Speaker").Options.Protocol)
+
+	client := &mockClient{}
+	assert.NoError(t, plug.ProcessRequest(core.SwitchRequest{Device: "Speaker", Value: "1"}))
+	plug.PublishValue(client)
+	assert.Equal(t, []publishedMessage{{topic: "cmnd/kidsroom/music/POWER2", payload: "1"}}, client.published)
+
+	plug.GetMessageHandler(nil, plug)(client, &mockMessage{payload: []byte(`{"POWER1":"ON","POWER2":"OFF"}`)})
+	assert.Equal(t, 0.0, plug.GetCurrent())
+}
@@ -0,0 +1,329 @@
+package devices
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TopicSubscriber is implemented by devices that listen on more than their state topic
+type TopicSubscriber interface {
+	GetSubscriptions() map[string]mqtt.MessageHandler
+	// OwnsTopic tells whether topic is one of the subscriptions, so it isn't offered as a new device
+	OwnsTopic(topic string) bool
+}
+
+// Presence tracks who is home from MQTT messages, network probes and DHCP leases
+type Presence struct {
+	Device
+
+	People     map[string]bool `json:"people"`
+	AnyoneHome bool            `json:"anyoneHome"`
+
+	people    []*person
+	awayAfter time.Duration
+	interval  time.Duration
+	leaseFile string
+	lastProbe time.Time
+	probing   atomic.Bool
+	reported  bool
+}
+
+type person struct {
+	core.PersonConfig
+	// set by MQTT messages, nil until the first one arrives
+	mqttHome *bool
+	lastSeen time.Time
+}
+
+type ownTracksMessage struct {
+	Type      string   `json:"_type"`
+	Event     string   `json:"event"`
+	Desc      string   `json:"desc"`
+	InRegions []string `json:"inregions"`
+}
+
+func NewPresence(config core.DeviceConfig) *Presence {
+	p := Presence{}
+	p.setBaseConfig(config)
+	if config.Icon == "" {
+		p.Icon = "🏠"
+	}
+	p.Type = "presence"
+	p.awayAfter = 10 * time.Minute
+	p.interval = 30 * time.Second
+	p.People = make(map[string]bool)
+
+	if config.Options != nil && config.Options.Presence != nil {
+		c := config.Options.Presence
+		if c.AwayAfter != nil {
+			p.awayAfter = time.Duration(*c.AwayAfter) * time.Second
+		}
+		if c.Interval != nil && *c.Interval > 0 {
+			p.interval = time.Duration(*c.Interval) * time.Second
+		}
+		p.leaseFile = c.LeaseFile
+		for _, pc := range c.People {
+			if pc.Region == "" {
+				pc.Region = "home"
+			}
+			p.people = append(p.people, &person{PersonConfig: pc})
+			p.People[pc.Name] = false
+			p.Triggers = append(p.Triggers, pc.Name)
+		}
+	}
+	p.Triggers = append(p.Triggers, "anyone_home", "everyone_home", "people_home")
+	p.persistentFields = p.Triggers
+
+	return &p
+}
+
+func (p *Presence) OwnsTopic(topic string) bool {
+	for _, person := range p.people {
+		if person.Topic != "" && topicMatches(person.Topic, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// topicMatches tells whether topic matches an MQTT subscription filter with + and # wildcards
+func topicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func (p *Presence) GetSubscriptions() map[string]mqtt.MessageHandler {
+	subscriptions := make(map[string]mqtt.MessageHandler)
+	for _, person := range p.people {
+		if person.Topic == "" {
+			continue
+		}
+		subscriptions[person.Topic] = func(client mqtt.Client, message mqtt.Message) {
+			if home, ok := parsePresenceMessage(message.Payload(), person.Region); ok {
+				p.mutex.Lock()
+				person.mqttHome = &home
+				p.mutex.Unlock()
+				log.Printf("[%32s] %s reported %s via MQTT\n", p.Name, person.Name, presenceText(home))
+				p.updateState()
+			}
+		}
+	}
+	return subscriptions
+}
+
+// parsePresenceMessage understands OwnTracks location and transition messages as well as plain payloads
+func parsePresenceMessage(payload []byte, region string) (bool, bool) {
+	var message ownTracksMessage
+	if err := json.Unmarshal(payload, &message); err == nil {
+		switch message.Type {
+		case "transition":
+			if message.Desc != region {
+				return false, false
+			}
+			return message.Event == "enter", message.Event == "enter" || message.Event == "leave"
+		case "location":
+			return contains(message.InRegions, region), true
+		}
+		return false, false
+	}
+	switch strings.ToLower(strings.Trim(strings.TrimSpace(string(payload)), `"`)) {
+	case "home", "on", "true", "1", "present":
+		return true, true
+	case "away", "not_home", "off", "false", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// UpdateValue starts a new round of probes once the interval has passed
+func (p *Presence) UpdateValue() (float64, bool) {
+	if time.Since(p.lastProbe) >= p.interval && p.probing.CompareAndSwap(false, true) {
+		p.lastProbe = time.Now()
+		go func() {
+			defer p.probing.Store(false)
+			p.probe()
+		}()
+	}
+	return 0, false
+}
+
+func (p *Presence) probe() {
+	leases := readLeases(p.leaseFile)
+	seen := make(map[*person]bool)
+	results := make(chan *person, len(p.people))
+	pending := 0
+	for _, person := range p.people {
+		if leaseMatches(leases, person.PersonConfig) {
+			seen[person] = true
+		}
+		if person.Host != "" {
+			pending++
+			go func() {
+				if probeHost(person.Host, person.Port) {
+					results <- person
+				} else {
+					results <- nil
+				}
+			}()
+		}
+	}
+	for ; pending > 0; pending-- {
+		if person := <-results; person != nil {
+			seen[person] = true
+		}
+	}
+
+	now := time.Now()
+	p.mutex.Lock()
+	for person := range seen {
+		person.lastSeen = now
+	}
+	p.mutex.Unlock()
+	p.updateState()
+}
+
+// probeHost pings host, or connects to port if it is set. A refused connection also means the host is up.
+func probeHost(host string, port int) bool {
+	if port == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		return exec.CommandContext(ctx, "ping", "-c", "1", "-W", "2", host).Run() == nil
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), 2*time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+type lease struct {
+	mac      string
+	hostname string
+}
+
+// readLeases reads the active leases from a dnsmasq lease file: expiry mac ip hostname client-id
+func readLeases(filename string) []lease {
+	if filename == "" {
+		return nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Printf("Could not read lease file %s: %s\n", filename, err)
+		return nil
+	}
+	defer file.Close()
+
+	var leases []lease
+	now := time.Now().Unix()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || (expiry != 0 && expiry < now) {
+			continue
+		}
+		leases = append(leases, lease{mac: strings.ToLower(fields[1]), hostname: fields[3]})
+	}
+	return leases
+}
+
+func leaseMatches(leases []lease, config core.PersonConfig) bool {
+	for _, l := range leases {
+		if (config.Mac != "" && l.mac == strings.ToLower(config.Mac)) || (config.Hostname != "" && l.hostname == config.Hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateState recomputes who is home and updates the triggers that changed
+func (p *Presence) updateState() {
+	now := time.Now()
+	changed := make(map[string]bool)
+	p.mutex.Lock()
+	count := 0
+	for _, person := range p.people {
+		home := (person.mqttHome != nil && *person.mqttHome) || (!person.lastSeen.IsZero() && now.Sub(person.lastSeen) < p.awayAfter)
+		if home != p.People[person.Name] {
+			changed[person.Name] = home
+			p.People[person.Name] = home
+			p.LastChanged = &now
+		}
+		if home {
+			count++
+		}
+	}
+	anyoneHome := count > 0
+	p.AnyoneHome = anyoneHome
+	p.Current = float64(count)
+	// only changes are passed on, so conditions with a delay keep counting
+	if len(changed) == 0 && p.reported {
+		p.mutex.Unlock()
+		return
+	}
+	p.reported = true
+	p.mutex.Unlock()
+
+	for _, name := range sortedKeys(changed) {
+		log.Printf("[%32s] %s is %s\n", p.Name, name, presenceText(changed[name]))
+		p.UpdateRules(name, changed[name])
+	}
+	p.UpdateRules("anyone_home", anyoneHome)
+	p.UpdateRules("everyone_home", count == len(p.people))
+	p.UpdateRules("people_home", count)
+}
+
+func presenceText(home bool) string {
+	if home {
+		return "home"
+	}
+	return "away"
+}
+
+func (p *Presence) ProcessRequest(core.SwitchRequest) error {
+	return errors.New("presence can't be switched")
+}
+
+func (p *Presence) GetConfig(name string) core.DeviceConfig {
+	c := core.PresenceConfig{
+		AwayAfter: core.ToPtr(int(p.awayAfter.Seconds())),
+		Interval:  core.ToPtr(int(p.interval.Seconds())),
+		LeaseFile: p.leaseFile,
+	}
+	for _, person := range p.people {
+		c.People = append(c.People, person.PersonConfig)
+	}
+	return core.DeviceConfig{
+		Name:    name,
+		Type:    "presence",
+		Icon:    p.Icon,
+		Options: &core.ConfigOptions{Hidden: &p.Hidden, Presence: &c},
+	}
+}
@@ -0,0 +1,91 @@
+package devices
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePresenceMessage(t *testing.T) {
+	tests := []struct {
+		payload string
+		home    bool
+		ok      bool
+	}{
+		{`{"_type":"transition","event":"enter","desc":"home"}`, true, true},
+		{`{"_type":"transition","event":"leave","desc":"home"}`, false, true},
+		{`{"_type":"transition","event":"enter","desc":"work"}`, false, false},
+		{`{"_type":"location","inregions":["home"]}`, true, true},
+		{`{"_type":"location"}`, false, true},
+		{`{"_type":"waypoint"}`, false, false},
+		{`home`, true, true},
+		{`not_home`, false, true},
+		{`something`, false, false},
+	}
+	for _, tt := range tests {
+		home, ok := parsePresenceMessage([]byte(tt.payload), "home")
+		assert.Equal(t, tt.home, home, tt.payload)
+		assert.Equal(t, tt.ok, ok, tt.payload)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	leaseFile := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	leases := fmt.Sprintf("%d aa:bb:cc:dd:ee:ff 192.168.1.22 carols-phone *\n%d 11:22:33:44:55:66 192.168.1.23 old *\n",
+		time.Now().Add(time.Hour).Unix(), time.Now().Add(-time.Hour).Unix())
+	assert.NoError(t, os.WriteFile(leaseFile, []byte(leases), 0o644))
+
+	p := NewPresence(core.DeviceConfig{Name: "presence", Type: "presence", Options: &core.ConfigOptions{Presence: &core.PresenceConfig{
+		LeaseFile: leaseFile,
+		People: []core.PersonConfig{
+			{Name: "alice", Topic: "owntracks/alice/phone"},
+			{Name: "bob", Host: "127.0.0.1", Port: port},
+			{Name: "carol", Mac: "AA:BB:CC:DD:EE:FF"},
+			{Name: "dave", Mac: "11:22:33:44:55:66"},
+		},
+	}}})
+	rule := &Rule{Triggers: []Trigger{{Device: p, Key: "anyone_home", Condition: &condition{}}}}
+	p.AddRule(rule)
+
+	p.probe()
+	assert.Equal(t, map[string]bool{"alice": false, "bob": true, "carol": true, "dave": false}, p.People)
+	assert.Equal(t, true, rule.Triggers[0].Condition.LastValue)
+
+	handler := p.GetSubscriptions()["owntracks/alice/phone"]
+	handler(nil, &mockMessage{payload: []byte(`{"_type":"transition","event":"enter","desc":"home"}`)})
+	assert.True(t, p.People["alice"])
+
+	// people who haven't been seen for longer than away_after are away
+	p.awayAfter = 0
+	p.updateState()
+	assert.Equal(t, map[string]bool{"alice": true, "bob": false, "carol": false, "dave": false}, p.People)
+	handler(nil, &mockMessage{payload: []byte(`away`)})
+	assert.False(t, p.AnyoneHome)
+	assert.Equal(t, false, rule.Triggers[0].Condition.LastValue)
+}
+
+func TestPresence_OwnsTopic(t *testing.T) {
+	p := NewPresence(core.DeviceConfig{Name: "presence", Type: "presence", Options: &core.ConfigOptions{Presence: &core.PresenceConfig{
+		People: []core.PersonConfig{
+			{Name: "alice", Topic: "owntracks/alice/phone"},
+			{Name: "bob", Topic: "owntracks/bob/+"},
+			{Name: "carol", Topic: "presence/carol/#"},
+		},
+	}}})
+	assert.True(t, p.OwnsTopic("owntracks/alice/phone"))
+	assert.True(t, p.OwnsTopic("owntracks/bob/tablet"))
+	assert.False(t, p.OwnsTopic("owntracks/bob/tablet/event"))
+	assert.True(t, p.OwnsTopic("presence/carol/phone/event"))
+	assert.False(t, p.OwnsTopic("owntracks/dave/phone"))
+}
@@ -3,6 +3,7 @@ package devices
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
@@ -187,6 +188,7 @@ func (r *Rule) Fire(channel chan core.SwitchRequest) []Receiver {
 	log.Printf("[%32s] Firing %v\n", "Rules", r)
 	requests := make(map[string]core.SwitchRequest)
 	var firedReceivers []Receiver
+	context := r.templateValues()
 	for _, receiver := range r.Receivers {
 		request, ok := requests[receiver.Device.GetName()]
 		if !ok {
@@ -200,8 +202,10 @@ func (r *Rule) Fire(channel chan core.SwitchRequest) []Receiver {
 				continue
 			}
 			request.Duration = duration
+		case "easing":
+			request.Easing = receiver.Value
 		default:
-			request.Value = receiver.Value
+			request.Value = expandTemplate(receiver.Value, context, templateEscape(receiver.Device))
 			request.Key = receiver.Key
 			request.Command = receiver.Key
 		}
@@ -216,6 +220,52 @@ func (r *Rule) Fire(channel chan core.SwitchRequest) []Receiver {
 	return firedReceivers
 }
 
+// templateValues describes the trigger that changed last, i.e. the one that made the rule fire
+func (r *Rule) templateValues() map[string]string {
+	var last *Trigger
+	for t, trigger := range r.Triggers {
+		changed := trigger.Condition.LastChanged
+		if changed != nil && (last == nil || changed.After(*last.Condition.LastChanged)) {
+			last = &r.Triggers[t]
+		}
+	}
+	values := map[string]string{"time": time.Now().Format("15:04")}
+	if last != nil {
+		values["device"] = last.Device.GetLabel()
+		values["name"] = last.Device.GetName()
+		values["key"] = last.Key
+		values["value"] = fmt.Sprintf("%v", last.Condition.LastValue)
+	}
+	return values
+}
+
+// expandTemplate replaces {{device}}, {{name}}, {{key}}, {{value}} and {{time}} in receiver values, escaped by
+// escape if given. Other placeholders are left alone for the receiving device.
+func expandTemplate(template string, values map[string]string, escape func(string) string) string {
+	if !strings.Contains(template, "{{") {
+		return template
+	}
+	return templatePlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		if value, ok := values[templatePlaceholder.FindStringSubmatch(match)[1]]; ok {
+			if escape != nil {
+				return escape(value)
+			}
+			return value
+		}
+		return match
+	})
+}
+
+// templateEscape returns how template values have to be escaped for a receiving device. Shell commands and
+// webhooks read their parameters in query form, so a value mustn't be able to add parameters of its own.
+func templateEscape(device DeviceInterface) func(string) string {
+	switch device.(type) {
+	case *Shell, *Webhook:
+		return url.QueryEscape
+	}
+	return nil
+}
+
 func makeComparable(value any, target any, numeric bool) (any, any, error) {
 	if reflect.TypeOf(value) == reflect.TypeOf(target) {
 		return value, target, nil
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package devices
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func setShellProcAttr(cmd *exec.Cmd, username string) error {
+	if username == "" {
+		return nil
+	}
+	return errors.New("running commands as another user is not supported on this platform")
+}
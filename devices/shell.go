@@ -1,19 +1,56 @@
 package devices
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/PhilGruber/dimmy/core"
 )
 
+const (
+	defaultShellTimeout = 60 // seconds
+	maxShellOutput      = 4096
+)
+
 type Shell struct {
 	Device
 
-	commands map[string]string
+	commands     map[string]core.ShellCommand
+	defaults     core.ShellCommand
+	allowCommand bool
+	slots        chan struct{}
+	auditLog     string
+	auditMutex   sync.Mutex
+
+	Running     int    `json:"running"`
+	LastCommand string `json:"lastCommand,omitempty"`
+	ExitCode    *int   `json:"exitCode,omitempty"`
+	Output      string `json:"output,omitempty"`
+}
+
+// shellRun is a command with all parameters substituted, ready to be executed
+type shellRun struct {
+	name    string
+	argv    []string
+	timeout time.Duration
+	dir     string
+	env     []string
+	user    string
 }
 
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
 func NewShell(config core.DeviceConfig) *Shell {
 	s := Shell{}
 	s.Icon = "#️"
@@ -21,47 +58,350 @@ func NewShell(config core.DeviceConfig) *Shell {
 	s.Hidden = true
 	s.Type = "shell"
 
+	s.commands = make(map[string]core.ShellCommand)
+	maxConcurrent := 1
 	if config.Options != nil {
-		s.commands = *config.Options.Commands
+		if config.Options.Commands != nil {
+			for name, command := range *config.Options.Commands {
+				s.commands[name] = core.ShellCommand{Command: command}
+			}
+		}
+		if c := config.Options.Shell; c != nil {
+			s.allowCommand = c.AllowCommand
+			s.auditLog = c.AuditLog
+			s.defaults = core.ShellCommand{Timeout: c.Timeout, Dir: c.Dir, Env: c.Env, User: c.User}
+			if c.MaxConcurrent != nil && *c.MaxConcurrent > 0 {
+				maxConcurrent = *c.MaxConcurrent
+			}
+			for name, command := range c.Commands {
+				if command.Command == "" && len(command.Args) == 0 {
+					log.Printf("[%32s] Command %s has neither command nor args, skipping\n", s.Name, name)
+					continue
+				}
+				if len(command.Args) == 0 {
+					if param := quotedPlaceholder(command.Command); param != "" {
+						log.Printf("[%32s] Command %s has {{%s}} inside quotes, where its value isn't safe from the shell, skipping\n", s.Name, name, param)
+						continue
+					}
+				}
+				s.commands[name] = command
+			}
+		}
 	}
+	s.slots = make(chan struct{}, maxConcurrent)
 
-	s.Receivers = []string{"command"}
+	s.Receivers = append([]string{"command"}, sortedKeys(s.commands)...)
+	s.Triggers = []string{"command", "exit_code", "output", "running"}
 
 	return &s
 }
 
-func (s *Shell) ProcessRequest(request core.SwitchRequest) {
-	if request.Key == "command" {
-		// TODO: sanitize this
-		go s.execCommand(request.Value)
-		return
+// ProcessRequest runs a configured command. Parameters are passed in query form, e.g. "volume?level=40", or
+// as the value of the command's own key, e.g. key "volume" with "level=40". The "command" key runs configured
+// commands by name, anything else is run as is, which has to be enabled with allow_command.
+func (s *Shell) ProcessRequest(request core.SwitchRequest) error {
+	if _, ok := s.commands[request.Key]; ok {
+		query := ""
+		if strings.Contains(request.Value, "=") {
+			query = strings.TrimPrefix(request.Value, "?")
+		}
+		return s.runCommand(request.Key, query)
+	}
+
+	name, query, _ := strings.Cut(request.Value, "?")
+	if _, ok := s.commands[name]; ok || request.Key != "command" {
+		return s.runCommand(name, query)
 	}
 
-	command, ok := s.commands[request.Value]
+	if !s.allowCommand {
+		s.audit(shellAuditEntry{Command: request.Value, Error: "free-form commands are disabled"})
+		return errors.New("refusing to run free-form command, set allow_command to enable this")
+	}
+	run, err := s.prepare(request.Value, core.ShellCommand{Command: request.Value}, nil)
+	if err != nil {
+		return err
+	}
+	go s.execCommand(run)
+	return nil
+}
+
+// runCommand runs the configured command name with the parameters in query
+func (s *Shell) runCommand(name string, query string) error {
+	command, ok := s.commands[name]
 	if !ok {
-		log.Printf("Device %s does not support command %s. Please define this in config file.\n", s.Name, request.Value)
+		return fmt.Errorf("command %s is not supported, please define it in the config file", name)
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("invalid parameters for command %s: %w", name, err)
+	}
+	run, err := s.prepare(name, command, params)
+	if err != nil {
+		return fmt.Errorf("not running command %s: %w", name, err)
+	}
+
+	go s.execCommand(run)
+	return nil
+}
+
+// prepare validates the parameters and substitutes them into the command
+func (s *Shell) prepare(name string, command core.ShellCommand, params url.Values) (*shellRun, error) {
+	values := make(map[string]string)
+	for key := range params {
+		if _, ok := command.Params[key]; !ok {
+			return nil, fmt.Errorf("unknown parameter %s", key)
+		}
+	}
+	for key, param := range command.Params {
+		value, err := validateShellParam(key, param, params)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	run := shellRun{name: name, dir: s.defaults.Dir, user: s.defaults.User}
+	if len(command.Args) > 0 {
+		// arguments are passed without a shell, so values can be used verbatim
+		for _, arg := range command.Args {
+			arg, err := substituteShellParams(arg, values, false)
+			if err != nil {
+				return nil, err
+			}
+			run.argv = append(run.argv, arg)
+		}
+	} else {
+		line := command.Command
+		if len(command.Params) > 0 {
+			var err error
+			if line, err = substituteShellParams(line, values, true); err != nil {
+				return nil, err
+			}
+		}
+		run.argv = []string{"sh", "-c", line}
+	}
+
+	timeout := defaultShellTimeout
+	if command.Timeout != nil {
+		timeout = *command.Timeout
+	} else if s.defaults.Timeout != nil {
+		timeout = *s.defaults.Timeout
+	}
+	run.timeout = time.Duration(timeout) * time.Second
+	if command.Dir != "" {
+		run.dir = command.Dir
+	}
+	if command.User != "" {
+		run.user = command.User
+	}
+	run.env = os.Environ()
+	for _, env := range []map[string]string{s.defaults.Env, command.Env} {
+		for _, key := range sortedKeys(env) {
+			run.env = append(run.env, key+"="+env[key])
+		}
+	}
+	return &run, nil
+}
+
+func validateShellParam(key string, param core.ShellParam, params url.Values) (string, error) {
+	if !params.Has(key) {
+		if param.Default == nil {
+			return "", fmt.Errorf("missing parameter %s", key)
+		}
+		return *param.Default, nil
+	}
+	value := params.Get(key)
+	switch param.Type {
+	case "int", "float":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || (param.Type == "int" && number != float64(int64(number))) {
+			return "", fmt.Errorf("parameter %s must be of type %s", key, param.Type)
+		}
+		if (param.Min != nil && number < *param.Min) || (param.Max != nil && number > *param.Max) {
+			return "", fmt.Errorf("parameter %s is out of range", key)
+		}
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s must be a boolean", key)
+		}
+		value = strconv.FormatBool(b)
+	case "enum":
+		if !contains(param.Values, value) {
+			return "", fmt.Errorf("parameter %s must be one of %s", key, strings.Join(param.Values, ", "))
+		}
+	case "", "string":
+		if param.Pattern != "" {
+			re, err := regexp.Compile("^(?:" + param.Pattern + ")$")
+			if err != nil {
+				return "", fmt.Errorf("invalid pattern for parameter %s: %s", key, err.Error())
+			}
+			if !re.MatchString(value) {
+				return "", fmt.Errorf("parameter %s does not match %s", key, param.Pattern)
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown type %s for parameter %s", param.Type, key)
+	}
+	return value, nil
+}
+
+// substituteShellParams replaces {{name}} placeholders. Values are quoted if the result is run through a shell.
+func substituteShellParams(template string, values map[string]string, quote bool) (string, error) {
+	if quote {
+		return substituteParams(template, values, func(value string) string {
+			return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+		})
+	}
+	return substituteParams(template, values, nil)
+}
+
+// quotedPlaceholder returns the name of the first placeholder standing inside single or double quotes of a shell
+// command line. Values are single-quoted on substitution, which only protects them from the shell outside of quotes.
+func quotedPlaceholder(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quote != '\'':
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		case quote != 0:
+			if loc := templatePlaceholder.FindStringSubmatchIndex(line[i:]); loc != nil && loc[0] == 0 {
+				return line[i+loc[2] : i+loc[3]]
+			}
+		}
+	}
+	return ""
+}
+
+// substituteParams replaces {{name}} placeholders with values, escaped by escape if given
+func substituteParams(template string, values map[string]string, escape func(string) string) (string, error) {
+	var err error
+	result := templatePlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		key := templatePlaceholder.FindStringSubmatch(match)[1]
+		value, ok := values[key]
+		if !ok {
+			err = fmt.Errorf("undefined parameter %s", key)
+			return match
+		}
+		if escape != nil {
+			return escape(value)
+		}
+		return value
+	})
+	return result, err
+}
+
+func (s *Shell) execCommand(run *shellRun) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		log.Printf("[%32s] Not running %s, %d commands are already running\n", s.Name, run.name, cap(s.slots))
 		return
 	}
+	s.setRunning(1)
+	defer s.setRunning(-1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), run.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, run.argv[0], run.argv[1:]...)
+	cmd.Dir = run.dir
+	cmd.Env = run.env
+	exitCode := -1
+	var output []byte
+	cmd.WaitDelay = time.Second
+	start := time.Now()
+	err := setShellProcAttr(cmd, run.user)
+	if err == nil {
+		output, err = cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() != nil:
+			err = fmt.Errorf("timed out after %s", run.timeout)
+		case errors.As(err, &exitErr):
+			exitCode = exitErr.ExitCode()
+		case err == nil:
+			exitCode = 0
+		}
+		log.Printf("[%32s] Command %s exited with code %d after %s\n", s.Name, run.name, exitCode, time.Since(start).Round(time.Millisecond))
+	}
+	if err != nil {
+		log.Printf("[%32s] Error running command %s: %s\n", s.Name, run.name, err.Error())
+	}
+	if len(output) > maxShellOutput {
+		output = output[len(output)-maxShellOutput:]
+	}
+	result := strings.TrimSpace(string(output))
+	if result != "" {
+		log.Printf("[%32s] Output of %s: %s\n", s.Name, run.name, result)
+	}
+
+	entry := shellAuditEntry{Command: run.name, Args: run.argv, ExitCode: &exitCode, Output: result, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	s.audit(entry)
 
-	go s.execCommand(command)
+	s.mutex.Lock()
+	s.LastCommand = run.name
+	s.ExitCode = &exitCode
+	s.Output = result
+	s.mutex.Unlock()
+	s.UpdateRules("command", run.name)
+	s.UpdateRules("exit_code", exitCode)
+	s.UpdateRules("output", result)
+}
 
+// shellAuditEntry is a line of the audit log, a command that was run or refused
+type shellAuditEntry struct {
+	Time     time.Time `json:"time"`
+	Device   string    `json:"device"`
+	Command  string    `json:"command"`
+	Args     []string  `json:"args,omitempty"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration float64   `json:"duration,omitempty"` // seconds
 }
 
-func (s *Shell) execCommand(command string) {
-	cmd := exec.Command("sh", "-c", command)
-	output, err := cmd.CombinedOutput()
-	fmt.Printf("executed command '%s': %v, output: %s", command, err, output)
+// audit appends entry to the audit log as a line of JSON, if one is configured
+func (s *Shell) audit(entry shellAuditEntry) {
+	if s.auditLog == "" {
+		return
+	}
+	entry.Time = time.Now()
+	entry.Device = s.Name
+	line, err := json.Marshal(entry)
 	if err != nil {
-		fmt.Printf("error executing command '%s': %v, output: %s", command, err, output)
+		return
+	}
+	s.auditMutex.Lock()
+	defer s.auditMutex.Unlock()
+	file, err := os.OpenFile(s.auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("[%32s] Could not write audit log: %s\n", s.Name, err.Error())
+		return
 	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("[%32s] Could not write audit log: %s\n", s.Name, err.Error())
+	}
+}
+
+func (s *Shell) setRunning(delta int) {
+	s.mutex.Lock()
+	s.Running += delta
+	running := s.Running
+	s.mutex.Unlock()
+	s.UpdateRules("running", running)
 }
 
 func (s *Shell) GetCommands() []string {
-	var commands []string
-	for k := range s.commands {
-		commands = append(commands, k)
-	}
-	return commands
+	return sortedKeys(s.commands)
 }
 
 func (s *Shell) UpdateValue() (float64, bool) {
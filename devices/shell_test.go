@@ -0,0 +1,239 @@
+package devices
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"gopkg.in/yaml.v3"
+)
+
+func shellConfig(t *testing.T) core.DeviceConfig {
+	t.Helper()
+	raw := `name: test-shell
+type: shell
+options:
+  commands:
+    hello: echo hello
+  shell:
+    timeout: 1
+    env:
+      GREETING: hi
+    commands:
+      greet:
+        command: echo $GREETING {{name}}
+        params:
+          name:
+            type: string
+            default: world
+      add:
+        args: ["expr", "{{a}}", "+", "{{b}}"]
+        params:
+          a:
+            type: int
+            min: 0
+            max: 10
+          b:
+            type: enum
+            values: ["1", "2"]
+      fail:
+        command: exit 3
+      sleep:
+        command: sleep 5`
+	var cfg core.DeviceConfig
+	if err := yaml.Unmarshal([]byte(raw), &cfg); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	return cfg
+}
+
+func runShell(t *testing.T, s *Shell, value string) (int, string) {
+	t.Helper()
+	run, err := s.prepare(value, s.commands[value], nil)
+	if err != nil {
+		t.Fatalf("prepare %s: %v", value, err)
+	}
+	s.execCommand(run)
+	return *s.ExitCode, s.Output
+}
+
+func TestShell_Commands(t *testing.T) {
+	s := NewShell(shellConfig(t))
+
+	if code, output := runShell(t, s, "hello"); code != 0 || output != "hello" {
+		t.Errorf("expected hello, got %d %q", code, output)
+	}
+	if code, output := runShell(t, s, "greet"); code != 0 || output != "hi world" {
+		t.Errorf("expected default parameter and env, got %d %q", code, output)
+	}
+	if code, _ := runShell(t, s, "fail"); code != 3 {
+		t.Errorf("expected exit code 3, got %d", code)
+	}
+
+	start := time.Now()
+	if code, _ := runShell(t, s, "sleep"); code != -1 {
+		t.Errorf("expected exit code -1 after timeout, got %d", code)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Error("expected command to be stopped after the timeout")
+	}
+}
+
+func TestShell_Parameters(t *testing.T) {
+	s := NewShell(shellConfig(t))
+
+	tests := []struct {
+		value  string
+		output string
+		valid  bool
+	}{
+		{"add?a=3&b=2", "5", true},
+		{"add?a=11&b=2", "", false},
+		{"add?a=1.5&b=2", "", false},
+		{"add?a=1&b=3", "", false},
+		{"add?a=1", "", false},
+		{"add?a=1&b=1&c=1", "", false},
+		{"greet?name=" + "%27%3B%20rm%20-rf%20%2F", "hi '; rm -rf /", true},
+	}
+	for _, tt := range tests {
+		s.Output = ""
+		s.ProcessRequest(core.SwitchRequest{Value: tt.value})
+		deadline := time.Now().Add(2 * time.Second)
+		for tt.valid && s.getOutput() == "" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if output := s.getOutput(); output != tt.output {
+			t.Errorf("%s: expected %q, got %q", tt.value, tt.output, output)
+		}
+	}
+}
+
+func TestShell_FreeCommandDisabledByDefault(t *testing.T) {
+	s := NewShell(shellConfig(t))
+	if err := s.ProcessRequest(core.SwitchRequest{Key: "command", Value: "echo unsafe"}); err == nil {
+		t.Error("expected an error for a refused command")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if s.getOutput() != "" {
+		t.Error("expected free-form command to be refused")
+	}
+
+	s.allowCommand = true
+	s.ProcessRequest(core.SwitchRequest{Key: "command", Value: "echo allowed"})
+	deadline := time.Now().Add(2 * time.Second)
+	for s.getOutput() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.getOutput() != "allowed" {
+		t.Errorf("expected free-form command to run, got %q", s.getOutput())
+	}
+}
+
+func TestShell_RuleReceivers(t *testing.T) {
+	s := NewShell(shellConfig(t))
+	if receivers := s.GetReceivers(); len(receivers) != 6 || receivers[0] != "command" || receivers[1] != "add" {
+		t.Fatalf("expected command and the configured commands as receivers, got %v", receivers)
+	}
+
+	// rules send the receiver's key along with its value
+	for _, request := range []core.SwitchRequest{
+		{Key: "greet", Value: "name=rule"},
+		{Key: "hello", Value: "true"},
+		{Key: "command", Value: "greet?name=command"},
+	} {
+		s.Output = ""
+		s.ProcessRequest(request)
+		deadline := time.Now().Add(2 * time.Second)
+		for s.getOutput() == "" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if s.getOutput() != "hi command" {
+		t.Errorf("expected configured command to run with allow_command disabled, got %q", s.getOutput())
+	}
+}
+
+func TestShell_QuotedPlaceholders(t *testing.T) {
+	tests := []struct {
+		line  string
+		param string
+	}{
+		{`echo {{msg}}`, ""},
+		{`echo "{{msg}}"`, "msg"},
+		{`echo '{{ msg }}'`, "msg"},
+		{`echo "done" {{msg}} 'ok'`, ""},
+		{`echo \"{{msg}}\"`, ""},
+		{`echo "it's {{msg}}"`, "msg"},
+		{`echo 'say "hi"' {{msg}}`, ""},
+	}
+	for _, tt := range tests {
+		if param := quotedPlaceholder(tt.line); param != tt.param {
+			t.Errorf("%s: expected %q, got %q", tt.line, tt.param, param)
+		}
+	}
+
+	var cfg core.DeviceConfig
+	if err := yaml.Unmarshal([]byte(`name: test-shell
+type: shell
+options:
+  shell:
+    commands:
+      quoted:
+        command: echo "{{msg}}"
+        params:
+          msg:
+            type: string
+      unquoted:
+        command: echo {{msg}}
+        params:
+          msg:
+            type: string`), &cfg); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	s := NewShell(cfg)
+	if _, ok := s.commands["quoted"]; ok {
+		t.Error("expected a command with a quoted placeholder to be rejected")
+	}
+	run, err := s.prepare("unquoted", s.commands["unquoted"], map[string][]string{"msg": {"$(id) `id` it's"}})
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	s.execCommand(run)
+	if s.Output != "$(id) `id` it's" {
+		t.Errorf("expected the value to reach the command verbatim, got %q", s.Output)
+	}
+}
+
+func TestShell_AuditLog(t *testing.T) {
+	s := NewShell(shellConfig(t))
+	s.auditLog = filepath.Join(t.TempDir(), "audit.log")
+
+	s.ProcessRequest(core.SwitchRequest{Key: "command", Value: "echo unsafe"})
+	runShell(t, s, "fail")
+
+	data, err := os.ReadFile(s.auditLog)
+	if err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two entries, got %q", data)
+	}
+	var refused, failed shellAuditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &refused); err != nil || refused.Command != "echo unsafe" || refused.Error == "" || refused.ExitCode != nil {
+		t.Errorf("expected refused command to be logged, got %s", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &failed); err != nil || failed.Command != "fail" || *failed.ExitCode != 3 || failed.Device != "test-shell" {
+		t.Errorf("expected exit code to be logged, got %s", lines[1])
+	}
+}
+
+func (s *Shell) getOutput() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.Output
+}
@@ -86,7 +86,8 @@ func (s *Switch) GetMin() int {
 func (s *Switch) setCurrent(float64) {
 }
 
-func (s *Switch) ProcessRequest(request core.SwitchRequest) {
+func (s *Switch) ProcessRequest(request core.SwitchRequest) error {
+	return nil
 }
 
 func (s *Switch) ClearTrigger(key string) {
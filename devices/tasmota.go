@@ -0,0 +1,185 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+var irModule = regexp.MustCompile(`(?i)\bIR\b`)
+
+// TasmotaDiscovery collects the config and sensors messages a Tasmota device publishes to tasmota/discovery/<mac>/
+type TasmotaDiscovery struct {
+	Config  *core.TasmotaDiscoveryConfig
+	Sensors map[string]any
+}
+
+// Update parses a message published to tasmota/discovery/<mac>/<kind>
+func (t *TasmotaDiscovery) Update(kind string, payload []byte) error {
+	switch kind {
+	case "config":
+		var config core.TasmotaDiscoveryConfig
+		if err := json.Unmarshal(payload, &config); err != nil {
+			return err
+		}
+		t.Config = &config
+	case "sensors":
+		var sensors core.TasmotaDiscoverySensors
+		if err := json.Unmarshal(payload, &sensors); err != nil {
+			return err
+		}
+		t.Sensors = sensors.Sensors
+	}
+	return nil
+}
+
+// fullTopic returns the topic below which the device uses the prefix with the given index (cmnd, stat, tele)
+func (t *TasmotaDiscovery) fullTopic(prefix int) string {
+	prefixes := []string{"cmnd", "stat", "tele"}
+	if len(t.Config.Prefixes) == len(prefixes) {
+		prefixes = t.Config.Prefixes
+	}
+	fullTopic := t.Config.FullTopic
+	if fullTopic == "" {
+		fullTopic = "%prefix%/%topic%/"
+	}
+	id := t.Config.Mac
+	if len(id) > 6 {
+		id = id[len(id)-6:]
+	}
+	return strings.TrimSuffix(strings.NewReplacer(
+		"%prefix%", prefixes[prefix],
+		"%topic%", t.Config.Topic,
+		"%hostname%", t.Config.Hostname,
+		"%id%", id,
+	).Replace(fullTopic), "/")
+}
+
+// OwnsTopic tells whether the device publishes to or listens on topic
+func (t *TasmotaDiscovery) OwnsTopic(topic string) bool {
+	if t.Config == nil {
+		return false
+	}
+	for prefix := 0; prefix < 3; prefix++ {
+		fullTopic := t.fullTopic(prefix)
+		if topic == fullTopic || strings.HasPrefix(topic, fullTopic+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Devices returns what the Tasmota device offers as unknown devices: a light for dimmers, a plug per relay,
+// an IR control for IR bridges and a device with its sensors
+func (t *TasmotaDiscovery) Devices() []*GenericDevice {
+	if t.Config == nil {
+		return nil
+	}
+	command := t.fullTopic(0)
+	telemetry := t.fullTopic(2)
+	customFullTopic := command != "cmnd/"+t.Config.Topic || telemetry != "tele/"+t.Config.Topic
+	name := t.Config.DeviceName
+	if name == "" {
+		name = t.Config.Topic
+	}
+	newDevice := func(deviceType string, topic string, stateTopic string, icon string, deviceName string) *GenericDevice {
+		d := newDiscoveredDevice(topic)
+		d.Type = deviceType
+		d.MqttState = stateTopic
+		d.Icon = icon
+		d.discovery.Name = deviceName
+		d.discovery.Vendor = "Tasmota"
+		d.discovery.Model = t.Config.Module
+		d.discovery.Description = strings.TrimSpace(fmt.Sprintf("%s at %s", t.Config.Version, t.Config.IP))
+		d.discovery.AvailabilityTopic = core.ToPtr(telemetry + "/LWT")
+		if customFullTopic && stateTopic != topic {
+			// configured devices derive their state topic from cmnd/<topic> otherwise
+			d.discovery.StateTopic = core.ToPtr(stateTopic)
+		}
+		return d
+	}
+
+	var devices []*GenericDevice
+	_, irReceiver := t.Sensors["IrReceived"]
+	if irModule.MatchString(t.Config.Module) || irReceiver {
+		ir := newDevice("ir-control", command, telemetry+"/RESULT", "📡", name)
+		ir.discovery.Protocol = core.ToPtr(IrProtocolTasmota)
+		devices = append(devices, ir)
+	}
+
+	light := t.Config.LightSubtype > 0
+	var relays []int
+	for i, relay := range t.Config.Relays {
+		switch relay {
+		case 1:
+			relays = append(relays, i)
+		case 2:
+			light = true
+		}
+	}
+	if light {
+		devices = append(devices, newDevice("light", command, telemetry+"/STATE", "💡", name))
+	}
+	for _, i := range relays {
+		topic := command + "/POWER"
+		relayName := name
+		if countRelays(t.Config.Relays) > 1 {
+			topic = fmt.Sprintf("%s/POWER%d", command, i+1)
+			relayName = fmt.Sprintf("%s %d", name, i+1)
+			if i < len(t.Config.FriendlyNames) && t.Config.FriendlyNames[i] != nil && *t.Config.FriendlyNames[i] != "" {
+				relayName = *t.Config.FriendlyNames[i]
+			}
+		}
+		devices = append(devices, newDevice("plug", topic, telemetry+"/STATE", "🔌", relayName))
+	}
+
+	if sensors := tasmotaSensors(t.Sensors); len(sensors) > 0 {
+		sensorName := name
+		if len(devices) > 0 {
+			sensorName += " Sensors"
+		}
+		d := newDevice("device", telemetry+"/SENSOR", telemetry+"/SENSOR", "", sensorName)
+		for _, sensor := range sensors {
+			d.addSensor(sensor)
+		}
+		d.Icon = sensors[0].Icon
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+// countRelays counts the relays and lights, Tasmota numbers their POWER commands only if there's more than one
+func countRelays(relays []int) int {
+	count := 0
+	for _, relay := range relays {
+		if relay == 1 || relay == 2 {
+			count++
+		}
+	}
+	return count
+}
+
+// tasmotaSensors turns the readings of a sensors message into sensors, nested readings are named like AM2301.Temperature
+func tasmotaSensors(readings map[string]any) []core.Sensor {
+	var sensors []core.Sensor
+	for _, key := range sortedKeys(readings) {
+		if key == "Time" || key == "IrReceived" || strings.HasSuffix(key, "Unit") {
+			continue
+		}
+		nested, ok := readings[key].(map[string]any)
+		if !ok {
+			sensors = append(sensors, core.Sensor{Name: key, Icon: getIcon(strings.ToLower(key))})
+			continue
+		}
+		for _, field := range sortedKeys(nested) {
+			switch nested[field].(type) {
+			case float64, string, bool:
+				sensors = append(sensors, core.Sensor{Name: key + "." + field, Icon: getIcon(strings.ToLower(field))})
+			}
+		}
+	}
+	return sensors
+}
@@ -0,0 +1,92 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tasmotaConfig = `{"ip":"192.168.1.50","dn":"Kitchen","fn":["Kettle","Toaster",null,null],"hn":"kitchen-1234","mac":"A4CF12341234",
+	"md":"Sonoff Dual R2","ty":0,"if":0,"ofln":"Offline","onln":"Online","sw":"13.1.0","t":"kitchen","ft":"%prefix%/%topic%/",
+	"tp":["cmnd","stat","tele"],"rl":[1,1,0,0,0,0,0,0],"lt_st":0,"ver":1}`
+
+func TestTasmotaDiscovery_Relays(t *testing.T) {
+	discovery := TasmotaDiscovery{}
+	assert.NoError(t, discovery.Update("sensors", []byte(`{"sn":{"Time":"2024-05-01T10:00:00","AM2301":{"Temperature":21.5,"Humidity":48},"TempUnit":"C"},"ver":1}`)))
+	assert.Nil(t, discovery.Devices(), "nothing is offered until the config arrived")
+	assert.NoError(t, discovery.Update("config", []byte(tasmotaConfig)))
+
+	devices := discovery.Devices()
+	assert.Len(t, devices, 3)
+	assert.Equal(t, "plug", devices[0].GetType())
+	assert.Equal(t, "cmnd/kitchen/POWER1", devices[0].GetMqttTopic())
+	assert.Equal(t, "Kettle", devices[0].GetDiscovery().Name)
+	assert.Equal(t, "cmnd/kitchen/POWER2", devices[1].GetMqttTopic())
+
+	sensors := devices[2]
+	assert.Equal(t, "device", sensors.GetType())
+	assert.Equal(t, "tele/kitchen/SENSOR", sensors.GetMqttStateTopic())
+	assert.Equal(t, "Kitchen Sensors", sensors.GetDiscovery().Name)
+	var names []string
+	for _, sensor := range sensors.GetSensors() {
+		names = append(names, sensor.Name)
+	}
+	assert.Equal(t, []string{"AM2301.Humidity", "AM2301.Temperature"}, names)
+	assert.Equal(t, "tele/kitchen/LWT", *sensors.GetConfig("Kitchen").Options.AvailabilityTopic)
+
+	assert.True(t, discovery.OwnsTopic("tele/kitchen/STATE"))
+	assert.True(t, discovery.OwnsTopic("cmnd/kitchen"))
+	assert.False(t, discovery.OwnsTopic("tele/kitchen-2/STATE"))
+
+	assert.Nil(t, devices[1].GetConfig("Toaster").Options.StateTopic, "derived from the default FullTopic")
+
+	plug := NewPlug(devices[1].GetConfig("Toaster"))
+	plug.GetMessageHandler(nil, plug)(nil, &mockMessage{payload: []byte(`{"POWER1":"OFF","POWER2":"ON"}`)})
+	assert.Equal(t, float64(1), plug.GetCurrent())
+}
+
+func TestTasmotaDiscovery_LightAndIr(t *testing.T) {
+	discovery := TasmotaDiscovery{}
+	assert.NoError(t, discovery.Update("config", []byte(`{"dn":"Desk","md":"YTF IR Bridge","t":"desk","ft":"%topic%/%prefix%/","rl":[2,0,0,0],"lt_st":1}`)))
+
+	devices := discovery.Devices()
+	assert.Len(t, devices, 2)
+	assert.Equal(t, "ir-control", devices[0].GetType())
+	assert.Equal(t, IrProtocolTasmota, *devices[0].GetConfig("Desk IR").Options.Protocol)
+	assert.Equal(t, "light", devices[1].GetType())
+	assert.Equal(t, "desk/cmnd", devices[1].GetMqttTopic())
+	assert.Equal(t, "desk/tele/STATE", devices[1].GetMqttStateTopic())
+
+	// saved devices keep the topics built from the custom FullTopic
+	light := NewLight(devices[1].GetConfig("Desk"))
+	assert.Equal(t, "desk/tele/STATE", light.GetMqttStateTopic())
+	assert.Equal(t, "desk/tele/STATE", *light.GetConfig("Desk").Options.StateTopic)
+	ir := NewIrControl(devices[0].GetConfig("Desk IR"))
+	assert.Equal(t, "desk/tele/RESULT", ir.GetMqttStateTopic())
+}
+
+func TestTasmotaDiscovery_CustomFullTopicPlug(t *testing.T) {
+	discovery := TasmotaDiscovery{}
+	assert.NoError(t, discovery.Update("config", []byte(`{"dn":"Fan","t":"fan","ft":"home/%topic%/%prefix%/","rl":[1,1,0,0]}`)))
+
+	devices := discovery.Devices()
+	assert.Len(t, devices, 2)
+	assert.Equal(t, "home/fan/cmnd/POWER2", devices[1].GetMqttTopic())
+
+	plug := NewPlug(devices[1].GetConfig("Fan 2"))
+	assert.Equal(t, "home/fan/tele/STATE", plug.GetMqttStateTopic())
+	assert.Equal(t, "home/fan/tele/LWT", plug.GetAvailabilityTopic())
+	plug.GetMessageHandler(nil, plug)(nil, &mockMessage{payload: []byte(`{"POWER1":"OFF","POWER2":"ON"}`)})
+	assert.Equal(t, float64(1), plug.GetCurrent())
+}
+
+func TestLookupValue(t *testing.T) {
+	data := map[string]any{"AM2301": map[string]any{"Temperature": 21.5}, "power": 3.0}
+	value, ok := lookupValue(data, "AM2301.Temperature")
+	assert.True(t, ok)
+	assert.Equal(t, 21.5, value)
+	value, _ = lookupValue(data, "power")
+	assert.Equal(t, 3.0, value)
+	_, ok = lookupValue(data, "power.Total")
+	assert.False(t, ok)
+}
@@ -2,6 +2,7 @@ package devices
 
 import (
 	"log"
+	"math"
 	"time"
 
 	"github.com/PhilGruber/dimmy/core"
@@ -63,6 +64,20 @@ func (s *DimmyTime) updateEvents() {
 	s.events["sunset_tomorrow"] = setTomorrow.Truncate(time.Second)
 }
 
+// Daylight returns the position of the sun at the given time, from 0 at sunrise, sunset and during
+// the night to 1 at solar noon. It returns false if no location is configured.
+func (s *DimmyTime) Daylight(now time.Time) (float64, bool) {
+	rise, okRise := s.events["sunrise"]
+	set, okSet := s.events["sunset"]
+	if !okRise || !okSet || !set.After(rise) {
+		return 0, false
+	}
+	if now.Before(rise) || now.After(set) {
+		return 0, true
+	}
+	return math.Sin(math.Pi * now.Sub(rise).Seconds() / set.Sub(rise).Seconds()), true
+}
+
 func (s *DimmyTime) InitRule(rule *Rule) {
 	now := time.Now().Truncate(time.Second)
 	s.UpdateRule(rule, "day", now.Day())
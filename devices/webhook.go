@@ -0,0 +1,279 @@
+package devices
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+const defaultWebhookTimeout = 10 // seconds
+
+var ErrWebhookUnauthorized = errors.New("invalid webhook secret")
+var ErrWebhookNoSecret = errors.New("webhook has no secret, incoming calls are disabled")
+
+type Webhook struct {
+	Device
+
+	commands   map[string]core.WebhookCommand
+	headers    map[string]string
+	secret     string
+	accepted   []string
+	timeout    int
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+
+	LastCommand string         `json:"lastCommand,omitempty"`
+	StatusCode  int            `json:"statusCode,omitempty"`
+	Values      map[string]any `json:"values"`
+}
+
+func NewWebhook(config core.DeviceConfig) *Webhook {
+	w := Webhook{}
+	w.setBaseConfig(config)
+	if config.Icon == "" {
+		w.Icon = "🪝"
+	}
+	w.Type = "webhook"
+
+	w.commands = make(map[string]core.WebhookCommand)
+	w.timeout = defaultWebhookTimeout
+	w.retryDelay = time.Second
+	w.Values = make(map[string]any)
+	if config.Options != nil && config.Options.Webhook != nil {
+		c := config.Options.Webhook
+		w.secret = c.Secret
+		w.accepted = c.Triggers
+		w.headers = c.Headers
+		if c.Timeout != nil {
+			w.timeout = *c.Timeout
+		}
+		if c.Retries != nil {
+			w.retries = *c.Retries
+		}
+		if c.RetryDelay != nil {
+			w.retryDelay = time.Duration(*c.RetryDelay * float64(time.Second))
+		}
+		for name, command := range c.Commands {
+			if _, err := url.Parse(command.URL); err != nil || command.URL == "" {
+				log.Printf("[%32s] Command %s has an invalid url, skipping\n", w.Name, name)
+				continue
+			}
+			w.commands[name] = command
+		}
+	}
+	w.client = &http.Client{}
+	if w.secret == "" {
+		log.Printf("[%32s] No secret set, rejecting all incoming calls\n", w.Name)
+	}
+
+	w.Receivers = []string{"command"}
+	w.Triggers = append([]string{"command", "status"}, w.accepted...)
+
+	return &w
+}
+
+// ProcessRequest sends the request of a configured command. Parameters are passed in query form, e.g. "notify?text=hello".
+func (w *Webhook) ProcessRequest(request core.SwitchRequest) error {
+	name, query, _ := strings.Cut(request.Value, "?")
+	command, ok := w.commands[name]
+	if !ok {
+		return fmt.Errorf("command %s is not supported, please define it in the config file", name)
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("invalid parameters for command %s: %w", name, err)
+	}
+	values := make(map[string]string)
+	for key := range params {
+		values[key] = params.Get(key)
+	}
+	go w.send(name, command, values)
+	return nil
+}
+
+// buildRequest substitutes the parameters, escaped for where they are used
+func (w *Webhook) buildRequest(command core.WebhookCommand, values map[string]string) (*http.Request, error) {
+	// values in the path must keep spaces and slashes within their segment, those in the query within their parameter
+	path, query, hasQuery := strings.Cut(command.URL, "?")
+	target, err := substituteParams(path, values, url.PathEscape)
+	if err != nil {
+		return nil, err
+	}
+	if hasQuery {
+		query, err = substituteParams(query, values, url.QueryEscape)
+		if err != nil {
+			return nil, err
+		}
+		target += "?" + query
+	}
+	headers := make(map[string]string)
+	for _, h := range []map[string]string{w.headers, command.Headers} {
+		for key, value := range h {
+			value, err := substituteParams(value, values, func(s string) string {
+				return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+			})
+			if err != nil {
+				return nil, err
+			}
+			headers[key] = value
+		}
+	}
+	var escapeBody func(string) string
+	if strings.Contains(strings.ToLower(headers["Content-Type"]), "json") {
+		escapeBody = func(s string) string {
+			quoted, _ := json.Marshal(s)
+			return string(quoted[1 : len(quoted)-1])
+		}
+	}
+	body, err := substituteParams(command.Body, values, escapeBody)
+	if err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(command.Method)
+	if method == "" {
+		method = http.MethodPost
+		if command.Body == "" {
+			method = http.MethodGet
+		}
+	}
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// send performs the request, retrying on network errors and server errors
+func (w *Webhook) send(name string, command core.WebhookCommand, values map[string]string) {
+	retries := w.retries
+	if command.Retries != nil {
+		retries = *command.Retries
+	}
+	timeout := w.timeout
+	if command.Timeout != nil {
+		timeout = *command.Timeout
+	}
+	client := *w.client
+	client.Timeout = time.Duration(timeout) * time.Second
+
+	status := 0
+	delay := w.retryDelay
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		req, err := w.buildRequest(command, values)
+		if err != nil {
+			log.Printf("[%32s] Not sending %s: %s\n", w.Name, name, err.Error())
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("[%32s] Request %s failed (attempt %d/%d): %s\n", w.Name, name, attempt+1, retries+1, err.Error())
+			continue
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		_ = resp.Body.Close()
+		status = resp.StatusCode
+		log.Printf("[%32s] Request %s returned %d\n", w.Name, name, status)
+		if status < 500 {
+			break
+		}
+	}
+
+	w.mutex.Lock()
+	w.LastCommand = name
+	w.StatusCode = status
+	w.mutex.Unlock()
+	w.UpdateRules("command", name)
+	w.UpdateRules("status", status)
+}
+
+// Authorize checks the secret of an incoming call. Without a secret, anyone who can reach dimmy could set the
+// webhook's triggers, so all calls are rejected.
+func (w *Webhook) Authorize(request *http.Request) error {
+	if w.secret == "" {
+		return ErrWebhookNoSecret
+	}
+	given := request.Header.Get("X-Webhook-Secret")
+	if token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer "); ok {
+		given = token
+	}
+	if given == "" {
+		given = request.URL.Query().Get("secret")
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(w.secret)) != 1 {
+		return ErrWebhookUnauthorized
+	}
+	return nil
+}
+
+// Receive sets trigger values from an incoming call
+func (w *Webhook) Receive(values map[string]any) error {
+	for key := range values {
+		// command and status report the webhook's own requests and can't be set from outside
+		if key == "command" || key == "status" || (len(w.accepted) > 0 && !contains(w.accepted, key)) {
+			return fmt.Errorf("key %s is not accepted", key)
+		}
+	}
+	now := time.Now()
+	w.mutex.Lock()
+	for key, value := range values {
+		w.Values[key] = value
+	}
+	w.LastChanged = &now
+	w.mutex.Unlock()
+	for _, key := range sortedKeys(values) {
+		log.Printf("[%32s] Received %s = %v\n", w.Name, key, values[key])
+		w.UpdateRules(key, values[key])
+	}
+	return nil
+}
+
+func (w *Webhook) GetCommands() []string {
+	return sortedKeys(w.commands)
+}
+
+func (w *Webhook) UpdateValue() (float64, bool) {
+	return 0, false
+}
+
+// ReadWebhookPayload reads the values of an incoming call from a JSON object or, if the body is empty, the form values
+func ReadWebhookPayload(request *http.Request) (map[string]any, error) {
+	body, err := io.ReadAll(io.LimitReader(request.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+	if err := request.ParseForm(); err != nil {
+		return nil, err
+	}
+	for key := range request.Form {
+		if key != "secret" {
+			values[key] = request.Form.Get(key)
+		}
+	}
+	return values, nil
+}
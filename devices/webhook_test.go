@@ -0,0 +1,167 @@
+package devices
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	"gopkg.in/yaml.v3"
+)
+
+func webhookConfig(t *testing.T, url string) core.DeviceConfig {
+	t.Helper()
+	raw := `name: hook
+type: webhook
+options:
+  webhook:
+    secret: s3cret
+    triggers: [pressed]
+    retries: 2
+    retry_delay: 0.01
+    headers:
+      Content-Type: application/json
+    commands:
+      notify:
+        url: ` + url + `/notify?to={{to}}
+        body: '{"text": "{{text}}"}'`
+	var cfg core.DeviceConfig
+	if err := yaml.Unmarshal([]byte(raw), &cfg); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	return cfg
+}
+
+func TestWebhook_SendWithRetries(t *testing.T) {
+	var mutex sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		calls = append(calls, r.Method+" "+r.URL.String()+" "+string(body))
+		first := len(calls) == 1
+		mutex.Unlock()
+		if first {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	w := NewWebhook(webhookConfig(t, server.URL))
+	w.send("notify", w.commands["notify"], map[string]string{"to": "a&b", "text": `say "hi"`})
+
+	expected := `POST /notify?to=a%26b {"text": "say \"hi\""}`
+	if len(calls) != 2 || calls[0] != expected || calls[1] != expected {
+		t.Fatalf("expected two calls %s, got %v", expected, calls)
+	}
+	if w.StatusCode != http.StatusOK || w.LastCommand != "notify" {
+		t.Errorf("unexpected result %d %s", w.StatusCode, w.LastCommand)
+	}
+}
+
+func TestWebhook_Receive(t *testing.T) {
+	w := NewWebhook(webhookConfig(t, "http://localhost"))
+
+	request := httptest.NewRequest(http.MethodPost, "/api/webhooks/hook", nil)
+	if err := w.Authorize(request); err == nil {
+		t.Error("expected request without secret to be rejected")
+	}
+	request.Header.Set("Authorization", "Bearer s3cret")
+	if err := w.Authorize(request); err != nil {
+		t.Errorf("expected bearer token to be accepted: %v", err)
+	}
+
+	if err := w.Receive(map[string]any{"other": 1}); err == nil {
+		t.Error("expected unknown key to be rejected")
+	}
+
+	rule := &Rule{Triggers: []Trigger{{Device: w, Key: "pressed", Condition: &condition{}}}}
+	w.AddRule(rule)
+	if err := w.Receive(map[string]any{"pressed": true}); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if rule.Triggers[0].Condition.LastValue != true {
+		t.Errorf("expected trigger value true, got %v", rule.Triggers[0].Condition.LastValue)
+	}
+	if time.Since(*w.LastChanged) > time.Second {
+		t.Error("expected LastChanged to be updated")
+	}
+}
+
+func TestWebhook_NoSecretRejectsCalls(t *testing.T) {
+	cfg := webhookConfig(t, "http://localhost")
+	cfg.Options.Webhook.Secret = ""
+	w := NewWebhook(cfg)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/webhooks/hook", nil)
+	if err := w.Authorize(request); err != ErrWebhookNoSecret {
+		t.Errorf("expected calls to be rejected without a secret, got %v", err)
+	}
+}
+
+func TestWebhook_BuildRequestEscapesPerPosition(t *testing.T) {
+	w := NewWebhook(webhookConfig(t, "http://localhost"))
+	request, err := w.buildRequest(core.WebhookCommand{URL: "http://camera.local/{{folder}}/snapshot?label={{label}}"},
+		map[string]string{"folder": "front door/2", "label": "a b&c=d"})
+	if err != nil {
+		t.Fatalf("buildRequest: %v", err)
+	}
+	if request.URL.EscapedPath() != "/front%20door%2F2/snapshot" {
+		t.Errorf("expected the value to stay within its path segment, got %s", request.URL.EscapedPath())
+	}
+	if label := request.URL.Query().Get("label"); label != "a b&c=d" || len(request.URL.Query()) != 1 {
+		t.Errorf("expected the value to stay within its parameter, got %v", request.URL.Query())
+	}
+}
+
+func TestWebhook_ReceiveRejectsOwnTriggers(t *testing.T) {
+	cfg := webhookConfig(t, "http://localhost")
+	cfg.Options.Webhook.Triggers = nil
+	w := NewWebhook(cfg)
+
+	if err := w.Receive(map[string]any{"anything": 1}); err != nil {
+		t.Errorf("expected any key to be accepted without triggers: %v", err)
+	}
+	for _, key := range []string{"command", "status"} {
+		if err := w.Receive(map[string]any{key: "faked"}); err == nil {
+			t.Errorf("expected %s to be rejected", key)
+		}
+	}
+	if _, ok := w.Values["command"]; ok {
+		t.Error("expected rejected values not to be stored")
+	}
+}
+
+func TestWebhook_RuleValuesAreEscaped(t *testing.T) {
+	w := NewWebhook(webhookConfig(t, "http://localhost"))
+	now := time.Now()
+	rule := &Rule{
+		Triggers:  []Trigger{{Device: w, Key: "pressed", Condition: &condition{LastValue: "front door&to=everyone", LastChanged: &now}}},
+		Receivers: []Receiver{{Device: w, Key: "command", Value: "notify?to=me&text={{value}}"}},
+	}
+	channel := make(chan core.SwitchRequest, 1)
+	rule.Fire(channel)
+	request := <-channel
+
+	_, query, _ := strings.Cut(request.Value, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	if params.Get("to") != "me" || len(params["to"]) != 1 || params.Get("text") != "front door&to=everyone" {
+		t.Errorf("expected the trigger value to stay within text, got %v", params)
+	}
+}
+
+func TestReadWebhookPayload(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/api/webhooks/hook?pressed=1&secret=x", nil)
+	values, err := ReadWebhookPayload(request)
+	if err != nil || len(values) != 1 || values["pressed"] != "1" {
+		t.Errorf("expected form values without secret, got %v (%v)", values, err)
+	}
+}
@@ -0,0 +1,264 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PhilGruber/dimmy/core"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const maxBridgeLogs = 200
+
+// zigbeeDeviceSubtopic matches the availability, set and get topics below a device's friendly name, which
+// can itself contain slashes such as kitchen/lamp
+var zigbeeDeviceSubtopic = regexp.MustCompile("/(availability|(set|get)(/[^/]+)?)$")
+
+// ZigbeeBridge keeps track of a zigbee2mqtt bridge (info, logs and firmware updates of its devices) and
+// sends it requests to permit joining, rename devices and update their firmware
+type ZigbeeBridge struct {
+	Base    string
+	mutex   sync.RWMutex
+	info    *core.Zigbee2MqttBridgeInfo
+	joinEnd *time.Time
+	models  map[string]string
+	updates map[string]core.Zigbee2MqttMessageUpdate
+	logs    []BridgeLogEntry
+	renamed func(from string, to string)
+}
+
+type BridgeLogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+type ZigbeeDeviceStatus struct {
+	Name   string                         `json:"name"`
+	Model  string                         `json:"model,omitempty"`
+	Update *core.Zigbee2MqttMessageUpdate `json:"update,omitempty"`
+}
+
+type ZigbeeBridgeStatus struct {
+	Base       string               `json:"base"`
+	Online     bool                 `json:"online"`
+	Version    string               `json:"version,omitempty"`
+	PermitJoin bool                 `json:"permit_join"`
+	JoinUntil  *time.Time           `json:"join_until,omitempty"`
+	Devices    []ZigbeeDeviceStatus `json:"devices"`
+	Logs       []BridgeLogEntry     `json:"logs"`
+}
+
+// NewZigbeeBridge creates a bridge for the zigbee2mqtt instance publishing below base. renamed is called
+// with the old and new topic once zigbee2mqtt confirmed renaming a device.
+func NewZigbeeBridge(base string, renamed func(from string, to string)) *ZigbeeBridge {
+	return &ZigbeeBridge{
+		Base:    base,
+		models:  make(map[string]string),
+		updates: make(map[string]core.Zigbee2MqttMessageUpdate),
+		renamed: renamed,
+	}
+}
+
+// HandleMessage parses a message published to topic below the bridge's base
+func (b *ZigbeeBridge) HandleMessage(topic string, payload []byte) error {
+	switch {
+	case topic == "bridge/info":
+		var info core.Zigbee2MqttBridgeInfo
+		if err := json.Unmarshal(payload, &info); err != nil {
+			return err
+		}
+		b.mutex.Lock()
+		b.info = &info
+		b.joinEnd = nil
+		if info.PermitJoinEnd != nil {
+			b.joinEnd = core.ToPtr(time.UnixMilli(*info.PermitJoinEnd))
+		} else if info.PermitJoinTimeout != nil {
+			b.joinEnd = core.ToPtr(time.Now().Add(time.Duration(*info.PermitJoinTimeout) * time.Second))
+		}
+		b.mutex.Unlock()
+	case topic == "bridge/devices":
+		var list []core.Zigbee2MqttBridgeDevice
+		if err := json.Unmarshal(payload, &list); err != nil {
+			return err
+		}
+		b.mutex.Lock()
+		b.models = make(map[string]string)
+		for _, device := range list {
+			if device.Type == "Coordinator" {
+				continue
+			}
+			b.models[device.FriendlyName] = ""
+			if device.Definition != nil {
+				b.models[device.FriendlyName] = device.Definition.Vendor + " " + device.Definition.Model
+			}
+		}
+		b.mutex.Unlock()
+	case topic == "bridge/logging":
+		var message core.Zigbee2MqttLogMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			return err
+		}
+		b.addLog(message.Level, message.Message)
+	case strings.HasPrefix(topic, "bridge/response/"):
+		return b.handleResponse(strings.TrimPrefix(topic, "bridge/response/"), payload)
+	case strings.HasPrefix(topic, "bridge/"):
+	default:
+		if zigbeeDeviceSubtopic.MatchString(topic) {
+			return nil
+		}
+		var message struct {
+			Update *core.Zigbee2MqttMessageUpdate `json:"update"`
+		}
+		if err := json.Unmarshal(payload, &message); err != nil || message.Update == nil {
+			return nil
+		}
+		b.mutex.Lock()
+		b.updates[topic] = *message.Update
+		b.mutex.Unlock()
+	}
+	return nil
+}
+
+func (b *ZigbeeBridge) handleResponse(request string, payload []byte) error {
+	var response core.Zigbee2MqttResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return err
+	}
+	if response.Status != "ok" {
+		b.addLog("error", fmt.Sprintf("%s failed: %s", request, response.Error))
+		return nil
+	}
+	switch request {
+	case "device/rename":
+		var data struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		}
+		if err := json.Unmarshal(response.Data, &data); err != nil {
+			return err
+		}
+		b.addLog("info", fmt.Sprintf("Renamed %s to %s", data.From, data.To))
+		b.mutex.Lock()
+		if update, ok := b.updates[data.From]; ok {
+			b.updates[data.To] = update
+			delete(b.updates, data.From)
+		}
+		b.mutex.Unlock()
+		if b.renamed != nil {
+			b.renamed(b.Base+"/"+data.From, b.Base+"/"+data.To)
+		}
+	case "device/ota_update/update":
+		var data struct {
+			Id string `json:"id"`
+		}
+		_ = json.Unmarshal(response.Data, &data)
+		b.addLog("info", fmt.Sprintf("Updated firmware of %s", data.Id))
+	}
+	return nil
+}
+
+func (b *ZigbeeBridge) addLog(level string, message string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.logs = append(b.logs, BridgeLogEntry{Time: time.Now(), Level: level, Message: message})
+	if len(b.logs) > maxBridgeLogs {
+		b.logs = b.logs[len(b.logs)-maxBridgeLogs:]
+	}
+}
+
+// PermitJoin allows new devices to join for the given number of seconds, or stops joining if it's 0
+func (b *ZigbeeBridge) PermitJoin(client mqtt.Client, seconds int) error {
+	if seconds < 0 || seconds > 254 {
+		return errors.New("joining can be permitted for up to 254 seconds")
+	}
+	return b.request(client, "permit_join", map[string]any{"value": seconds > 0, "time": seconds})
+}
+
+// Rename asks zigbee2mqtt to rename a device, the config is updated once it confirmed
+func (b *ZigbeeBridge) Rename(client mqtt.Client, from string, to string) error {
+	if to == "" || strings.ContainsAny(to, "+#") || strings.HasPrefix(to, "bridge/") {
+		return fmt.Errorf("%q is not a valid name", to)
+	}
+	if !b.knows(from) {
+		return fmt.Errorf("device %s was not found", from)
+	}
+	return b.request(client, "device/rename", map[string]any{"from": from, "to": to})
+}
+
+// Update starts updating a device's firmware if zigbee2mqtt found a newer one
+func (b *ZigbeeBridge) Update(client mqtt.Client, device string) error {
+	b.mutex.RLock()
+	update, ok := b.updates[device]
+	b.mutex.RUnlock()
+	if !ok || update.State != "available" {
+		return fmt.Errorf("no firmware update available for %s", device)
+	}
+	return b.request(client, "device/ota_update/update", map[string]any{"id": device})
+}
+
+func (b *ZigbeeBridge) knows(device string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	_, ok := b.models[device]
+	return ok
+}
+
+func (b *ZigbeeBridge) request(client mqtt.Client, request string, payload map[string]any) error {
+	if client == nil {
+		return errors.New("not connected to MQTT")
+	}
+	message, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	log.Printf("[%32s] Requesting %s: %s\n", b.Base, request, message)
+	token := client.Publish(b.Base+"/bridge/request/"+request, 0, false, message)
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+// Status returns what's known about the bridge, its devices sorted by name and its logs, newest first
+func (b *ZigbeeBridge) Status() ZigbeeBridgeStatus {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	status := ZigbeeBridgeStatus{Base: b.Base, Online: b.info != nil, Devices: []ZigbeeDeviceStatus{}}
+	if b.info != nil {
+		status.Version = b.info.Version
+		status.PermitJoin = b.info.PermitJoin
+		if b.info.PermitJoin {
+			status.JoinUntil = b.joinEnd
+		}
+	}
+	names := make([]string, 0, len(b.models))
+	for name := range b.models {
+		names = append(names, name)
+	}
+	for name := range b.updates {
+		if _, ok := b.models[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		device := ZigbeeDeviceStatus{Name: name, Model: strings.TrimSpace(b.models[name])}
+		if update, ok := b.updates[name]; ok {
+			device.Update = &update
+		}
+		status.Devices = append(status.Devices, device)
+	}
+	status.Logs = make([]BridgeLogEntry, len(b.logs))
+	for i, entry := range b.logs {
+		status.Logs[len(b.logs)-1-i] = entry
+	}
+	return status
+}
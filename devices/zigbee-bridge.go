@@ -0,0 +1,101 @@
+package devices
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/PhilGruber/dimmy/core"
+)
+
+// NewDeviceFromDefinition creates an unknown device from zigbee2mqtt's device list. Sensors and controls
+// are taken from the exposes, including their ranges and values.
+func NewDeviceFromDefinition(topic string, device core.Zigbee2MqttBridgeDevice) *GenericDevice {
+	d := newDiscoveredDevice(topic)
+	d.discovery.Name = device.FriendlyName
+	if device.Definition != nil {
+		d.discovery.Vendor = device.Definition.Vendor
+		d.discovery.Model = device.Definition.Model
+		d.discovery.Description = device.Definition.Description
+		d.addExposes(device.Definition.Exposes)
+	}
+
+	log.Printf("Found %s %s of type %s at %s\n", d.discovery.Vendor, d.discovery.Model, d.Type, topic)
+
+	return d
+}
+
+func (d *GenericDevice) addExposes(exposes []core.Zigbee2MqttExpose) {
+	for _, expose := range exposes {
+		name := expose.Property
+		if name == "" {
+			name = expose.Name
+		}
+		switch expose.Type {
+		case "light":
+			if hasExpose(expose.Features, "brightness") {
+				d.Type = "zlight"
+			}
+			d.addExposes(expose.Features)
+		case "switch":
+			if hasExpose(expose.Features, "state") {
+				d.Type = "plug"
+				d.discovery.Protocol = core.ToPtr(PlugProtocolZigbee2Mqtt)
+			}
+			d.addExposes(expose.Features)
+		case "lock", "cover", "fan", "climate":
+			d.addExposes(expose.Features)
+		case "composite":
+			// colours are the only composite values that can be set as a whole
+			if expose.Access&core.Zigbee2MqttAccessSet != 0 && (name == "color" || name == "color_xy" || name == "color_hs") {
+				d.addExposedControl(name, expose, core.ControlTypeColour)
+			}
+		case "numeric", "binary", "enum":
+			if name == "battery" || name == "linkquality" || d.hasSensor(name) || d.hasControl(name) {
+				// battery and link quality are part of every device's health
+				continue
+			}
+			if expose.Access&core.Zigbee2MqttAccessSet != 0 {
+				controlType := map[string]core.ControlType{"numeric": core.ControlTypeScale, "binary": core.ControlTypeBool, "enum": core.ControlTypeList}[expose.Type]
+				d.addExposedControl(name, expose, controlType)
+			} else if expose.Access&core.Zigbee2MqttAccessState != 0 {
+				sensor := core.Sensor{Name: name, Icon: getIcon(name)}
+				if expose.Type == "enum" {
+					sensor.Values = exposeValues(expose)
+				}
+				d.addSensor(sensor)
+			}
+		}
+	}
+}
+
+func (d *GenericDevice) addExposedControl(name string, expose core.Zigbee2MqttExpose, controlType core.ControlType) {
+	control := core.Control{Name: name, Icon: getIcon(name), Type: controlType}
+	if expose.ValueMin != nil {
+		control.Min = core.ToPtr(int(math.Round(*expose.ValueMin)))
+	}
+	if expose.ValueMax != nil {
+		control.Max = core.ToPtr(int(math.Round(*expose.ValueMax)))
+	}
+	if expose.Type == "enum" {
+		control.Values = exposeValues(expose)
+	}
+	d.addControl(control)
+}
+
+func hasExpose(exposes []core.Zigbee2MqttExpose, name string) bool {
+	for _, expose := range exposes {
+		if expose.Name == name || expose.Property == name {
+			return true
+		}
+	}
+	return false
+}
+
+func exposeValues(expose core.Zigbee2MqttExpose) []string {
+	values := make([]string, len(expose.Values))
+	for i, value := range expose.Values {
+		values[i] = fmt.Sprintf("%v", value)
+	}
+	return values
+}
@@ -0,0 +1,87 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZigbeeBridge_Status(t *testing.T) {
+	bridge := NewZigbeeBridge("zigbee2mqtt", nil)
+	assert.False(t, bridge.Status().Online)
+
+	assert.NoError(t, bridge.HandleMessage("bridge/info", []byte(`{"version":"2.1.0","permit_join":true,"permit_join_end":1733666394000}`)))
+	assert.NoError(t, bridge.HandleMessage("bridge/devices", []byte(bridgeDevices)))
+	assert.NoError(t, bridge.HandleMessage("Kitchen Bulb", []byte(`{"state":"ON","update":{"state":"available","installed_version":16783874,"latest_version":16784128}}`)))
+	assert.NoError(t, bridge.HandleMessage("Kitchen Bulb/availability", []byte(`{"state":"online"}`)))
+	assert.NoError(t, bridge.HandleMessage("bridge/logging", []byte(`{"level":"info","message":"first"}`)))
+	assert.NoError(t, bridge.HandleMessage("bridge/logging", []byte(`{"level":"warning","message":"second"}`)))
+	assert.Error(t, bridge.HandleMessage("bridge/info", []byte(`not json`)))
+
+	status := bridge.Status()
+	assert.True(t, status.Online)
+	assert.Equal(t, "2.1.0", status.Version)
+	assert.True(t, status.PermitJoin)
+	assert.Equal(t, int64(1733666394000), status.JoinUntil.UnixMilli())
+	assert.Len(t, status.Devices, 2)
+	assert.Equal(t, "Hallway Motion", status.Devices[0].Name)
+	assert.Equal(t, "Aqara RTCGQ11LM", status.Devices[0].Model)
+	assert.Nil(t, status.Devices[0].Update)
+	assert.Equal(t, "available", status.Devices[1].Update.State)
+	assert.Equal(t, 16784128, *status.Devices[1].Update.LatestVersion)
+	assert.Equal(t, "second", status.Logs[0].Message)
+	assert.Equal(t, "first", status.Logs[1].Message)
+}
+
+func TestZigbeeBridge_NestedFriendlyNames(t *testing.T) {
+	bridge := NewZigbeeBridge("zigbee2mqtt", nil)
+	assert.NoError(t, bridge.HandleMessage("bridge/devices", []byte(`[
+		{"ieee_address": "0x0017880104", "type": "Router", "friendly_name": "kitchen/lamp", "definition": {"model": "LCT001", "vendor": "Philips"}}
+	]`)))
+	update := `{"update":{"state":"available","installed_version":1,"latest_version":2}}`
+	assert.NoError(t, bridge.HandleMessage("kitchen/lamp", []byte(update)))
+	for _, topic := range []string{"kitchen/lamp/availability", "kitchen/lamp/set", "kitchen/lamp/set/state", "kitchen/lamp/get"} {
+		assert.NoError(t, bridge.HandleMessage(topic, []byte(update)))
+	}
+
+	status := bridge.Status()
+	assert.Len(t, status.Devices, 1)
+	assert.Equal(t, "kitchen/lamp", status.Devices[0].Name)
+	assert.Equal(t, 2, *status.Devices[0].Update.LatestVersion)
+	assert.Len(t, bridge.updates, 1)
+}
+
+func TestZigbeeBridge_Requests(t *testing.T) {
+	var renamed []string
+	bridge := NewZigbeeBridge("zigbee2mqtt", func(from string, to string) {
+		renamed = append(renamed, from, to)
+	})
+	assert.NoError(t, bridge.HandleMessage("bridge/devices", []byte(bridgeDevices)))
+	assert.NoError(t, bridge.HandleMessage("Kitchen Bulb", []byte(`{"update":{"state":"available"}}`)))
+	assert.NoError(t, bridge.HandleMessage("Hallway Motion", []byte(`{"update":{"state":"idle"}}`)))
+
+	client := &mockClient{}
+	assert.NoError(t, bridge.PermitJoin(client, 120))
+	assert.NoError(t, bridge.PermitJoin(client, 0))
+	assert.Error(t, bridge.PermitJoin(client, 300))
+	assert.NoError(t, bridge.Rename(client, "Kitchen Bulb", "Pantry Bulb"))
+	assert.Error(t, bridge.Rename(client, "Missing", "Other"))
+	assert.Error(t, bridge.Rename(client, "Kitchen Bulb", "bad/#"))
+	assert.NoError(t, bridge.Update(client, "Kitchen Bulb"))
+	assert.Error(t, bridge.Update(client, "Hallway Motion"), "no newer firmware")
+	assert.Equal(t, []publishedMessage{
+		{"zigbee2mqtt/bridge/request/permit_join", `{"time":120,"value":true}`},
+		{"zigbee2mqtt/bridge/request/permit_join", `{"time":0,"value":false}`},
+		{"zigbee2mqtt/bridge/request/device/rename", `{"from":"Kitchen Bulb","to":"Pantry Bulb"}`},
+		{"zigbee2mqtt/bridge/request/device/ota_update/update", `{"id":"Kitchen Bulb"}`},
+	}, client.published)
+
+	assert.NoError(t, bridge.HandleMessage("bridge/response/device/rename", []byte(`{"status":"error","error":"Device 'Kitchen Bulb' does not exist"}`)))
+	assert.Empty(t, renamed)
+	assert.NoError(t, bridge.HandleMessage("bridge/response/device/rename", []byte(`{"status":"ok","data":{"from":"Kitchen Bulb","to":"Pantry Bulb","homeassistant_rename":false}}`)))
+	assert.Equal(t, []string{"zigbee2mqtt/Kitchen Bulb", "zigbee2mqtt/Pantry Bulb"}, renamed)
+
+	logs := bridge.Status().Logs
+	assert.Equal(t, "Renamed Kitchen Bulb to Pantry Bulb", logs[0].Message)
+	assert.Equal(t, "error", logs[1].Level)
+}
@@ -0,0 +1,100 @@
+package devices
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/PhilGruber/dimmy/core"
+	"github.com/stretchr/testify/assert"
+)
+
+const bridgeDevices = `[
+	{"ieee_address": "0x00124b0022", "type": "Coordinator", "friendly_name": "Coordinator", "supported": true},
+	{"ieee_address": "0x0017880104", "type": "Router", "friendly_name": "Kitchen Bulb", "supported": true,
+	 "definition": {"model": "8718699673147", "vendor": "Philips", "description": "Hue white A60 bulb E27",
+	  "exposes": [
+		{"type": "light", "features": [
+			{"type": "binary", "name": "state", "property": "state", "access": 7, "value_on": "ON", "value_off": "OFF"},
+			{"type": "numeric", "name": "brightness", "property": "brightness", "access": 7, "value_min": 0, "value_max": 254}
+		]},
+		{"type": "enum", "name": "effect", "property": "effect", "access": 2, "values": ["blink", "breathe", "okay"]},
+		{"type": "numeric", "name": "linkquality", "property": "linkquality", "access": 1, "unit": "lqi"}
+	 ]}},
+	{"ieee_address": "0x00158d0003", "type": "EndDevice", "friendly_name": "Hallway Motion", "supported": true,
+	 "definition": {"model": "RTCGQ11LM", "vendor": "Aqara", "description": "Motion sensor",
+	  "exposes": [
+		{"type": "numeric", "name": "battery", "property": "battery", "access": 1},
+		{"type": "binary", "name": "occupancy", "property": "occupancy", "access": 1},
+		{"type": "numeric", "name": "illuminance", "property": "illuminance", "access": 1},
+		{"type": "numeric", "name": "occupancy_timeout", "property": "occupancy_timeout", "access": 2, "value_min": 0, "value_max": 65535}
+	 ]}}
+]`
+
+func TestNewDeviceFromDefinition(t *testing.T) {
+	var list []core.Zigbee2MqttBridgeDevice
+	assert.NoError(t, json.Unmarshal([]byte(bridgeDevices), &list))
+	assert.Len(t, list, 3)
+
+	bulb := NewDeviceFromDefinition("zigbee2mqtt/Kitchen Bulb", list[1])
+	assert.Equal(t, "zlight", bulb.GetType())
+	assert.Equal(t, "zigbee2mqtt/Kitchen Bulb", bulb.GetMqttStateTopic())
+	assert.Empty(t, bulb.GetSensors())
+	assert.Len(t, bulb.GetControls(), 3)
+	brightness := bulb.GetControls()[1]
+	assert.Equal(t, core.ControlTypeScale, brightness.Type)
+	assert.Equal(t, 254, *brightness.Max)
+	assert.Equal(t, core.ControlTypeList, bulb.GetControls()[2].Type)
+	assert.Equal(t, []string{"blink", "breathe", "okay"}, bulb.GetControls()[2].Values)
+	assert.Equal(t, "Philips", bulb.GetDiscovery().Vendor)
+	assert.Equal(t, "Kitchen Bulb", bulb.GetDiscovery().Name)
+
+	motion := NewDeviceFromDefinition("zigbee2mqtt/Hallway Motion", list[2])
+	assert.Equal(t, "device", motion.GetType())
+	var sensors []string
+	for _, sensor := range motion.GetSensors() {
+		sensors = append(sensors, sensor.Name)
+	}
+	assert.Equal(t, []string{"occupancy", "illuminance"}, sensors)
+	assert.Equal(t, []string{"occupancy_timeout"}, motion.GetReceivers())
+
+	// messages no longer change what zigbee2mqtt described
+	motion.UpdateFromMessage(map[string]any{"occupancy": true, "temperature": 21.5, "Dimmer": 20})
+	assert.Equal(t, "device", motion.GetType())
+	assert.Len(t, motion.GetSensors(), 2)
+}
+
+func TestNewDeviceFromDefinition_SwitchIsZigbeePlug(t *testing.T) {
+	var device core.Zigbee2MqttBridgeDevice
+	assert.NoError(t, json.Unmarshal([]byte(`{"ieee_address": "0xa4c1380fb2", "type": "Router", "friendly_name": "Desk Plug", "supported": true,
+	 "definition": {"model": "TS011F_plug_1", "vendor": "TuYa", "description": "Smart plug (with power monitoring)",
+	  "exposes": [
+		{"type": "switch", "features": [
+			{"type": "binary", "name": "state", "property": "state", "access": 7, "value_on": "ON", "value_off": "OFF"}
+		]},
+		{"type": "numeric", "name": "power", "property": "power", "access": 5, "unit": "W"}
+	 ]}}`), &device))
+
+	discovered := NewDeviceFromDefinition("zigbee2mqtt/Desk Plug", device)
+	assert.Equal(t, "plug", discovered.GetType())
+
+	// saved the way the unknown devices page does
+	plug := NewPlug(discovered.GetConfig("Desk Plug"))
+	assert.Equal(t, "zigbee2mqtt/Desk Plug", plug.GetMqttStateTopic())
+	assert.Equal(t, PlugProtocolZigbee2Mqtt, *plug.GetConfig("Desk Plug").Options.Protocol)
+
+	client := &mockClient{}
+	assert.NoError(t, plug.ProcessRequest(core.SwitchRequest{Device: "Desk Plug", Value: "1"}))
+	plug.PublishValue(client)
+	assert.NoError(t, plug.ProcessRequest(core.SwitchRequest{Device: "Desk Plug", Value: "0"}))
+	plug.PublishValue(client)
+	assert.Equal(t, []publishedMessage{
+		{topic: "zigbee2mqtt/Desk Plug/set", payload: `{"state":"ON"}`},
+		{topic: "zigbee2mqtt/Desk Plug/set", payload: `{"state":"OFF"}`},
+	}, client.published)
+
+	handler := plug.GetMessageHandler(nil, plug)
+	handler(client, &mockMessage{payload: []byte(`{"state":"ON","power":12.5,"linkquality":120}`)})
+	assert.Equal(t, 1.0, plug.GetCurrent())
+	handler(client, &mockMessage{payload: []byte(`{"state":"OFF","power":0}`)})
+	assert.Equal(t, 0.0, plug.GetCurrent())
+}
@@ -2,7 +2,9 @@ package devices
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/PhilGruber/dimmy/core"
@@ -13,6 +15,9 @@ import (
 type ZLight struct {
 	Light
 	StupidHack bool
+	ColorTemp  *int `json:"color_temp,omitempty"`
+
+	nextColorTemp *int
 }
 
 func NewZLight(config core.DeviceConfig) *ZLight {
@@ -32,7 +37,7 @@ func makeZLight(config core.DeviceConfig) ZLight {
 	d.Max = 254
 	d.transition = false
 	d.Type = "light"
-	d.Receivers = []string{"brightness", "duration"}
+	d.Receivers = []string{"brightness", "duration", "easing"}
 
 	if config.Options != nil {
 		if config.Options.Min != nil {
@@ -49,6 +54,12 @@ func makeZLight(config core.DeviceConfig) ZLight {
 			d.StupidHack = config.Options.StupidHack
 		}
 	}
+	d.curve = newBrightnessCurve(d.Name, config.Options)
+	d.Adaptive = newAdaptive(d.Name, config.Options)
+	d.Receivers = append(d.Receivers, "color_temp")
+	if d.Adaptive != nil {
+		d.Receivers = append(d.Receivers, "adaptive")
+	}
 
 	d.Triggers = []string{"brightness"}
 	d.persistentFields = []string{"brightness"}
@@ -59,11 +70,63 @@ func makeZLight(config core.DeviceConfig) ZLight {
 	return d
 }
 
+func (l *ZLight) enableAdaptive(sun *DimmyTime) {
+	if l.Adaptive == nil {
+		return
+	}
+	l.Adaptive.sun = sun
+	l.Adaptive.apply = func(brightness float64, colorTemp int, duration int) {
+		l.setColorTemp(colorTemp)
+		l.fade(core.SwitchRequest{Value: fmt.Sprintf("%f", brightness), Duration: duration})
+	}
+}
+
+func (l *ZLight) ProcessRequest(request core.SwitchRequest) error {
+	if request.Key != "color_temp" {
+		return l.Light.ProcessRequest(request)
+	}
+	colorTemp, err := strconv.Atoi(request.Value)
+	if err != nil {
+		return fmt.Errorf("invalid colour temperature %s", request.Value)
+	}
+	l.Adaptive.manualChange(false)
+	l.setColorTemp(colorTemp)
+	return nil
+}
+
+func (l *ZLight) setColorTemp(colorTemp int) {
+	l.targetLock.Lock()
+	defer l.targetLock.Unlock()
+	if l.ColorTemp != nil && *l.ColorTemp == colorTemp {
+		return
+	}
+	l.nextColorTemp = &colorTemp
+}
+
+func (l *ZLight) takeColorTemp() *int {
+	l.targetLock.Lock()
+	defer l.targetLock.Unlock()
+	colorTemp := l.nextColorTemp
+	l.nextColorTemp = nil
+	return colorTemp
+}
+
+func (l *ZLight) UpdateValue() (float64, bool) {
+	value, ok := l.Light.UpdateValue()
+	l.targetLock.RLock()
+	defer l.targetLock.RUnlock()
+	if l.nextColorTemp != nil {
+		return l.GetCurrent(), true
+	}
+	return value, ok
+}
+
 func (l *ZLight) PublishValue(mqtt mqtt.Client) {
 	tt := time.Now()
 	newVal := l.PercentageToValue(l.GetCurrent())
 	var state string
-	if newVal == l.LastSent {
+	colorTemp := l.takeColorTemp()
+	if newVal == l.LastSent && colorTemp == nil {
 		return
 	}
 	l.LastChanged = &tt
@@ -78,6 +141,7 @@ func (l *ZLight) PublishValue(mqtt mqtt.Client) {
 	msg := core.Zigbee2MqttLightMessage{
 		State:      &state,
 		Brightness: &newVal,
+		ColorTemp:  colorTemp,
 	}
 
 	if l.transition {
@@ -91,6 +155,7 @@ func (l *ZLight) PublishValue(mqtt mqtt.Client) {
 		// Hack for stupid lights
 		log.Printf("Brightness is zero, activating stupid hack")
 		msg.Transition = nil
+		msg.ColorTemp = nil
 		msg.Brightness = core.ToPtr(10)
 		msg.State = core.ToPtr("OFF")
 		s, _ = json.Marshal(msg)
@@ -131,6 +196,11 @@ func (l *ZLight) GetMessageHandler(channel chan core.SwitchRequest, sw DeviceInt
 				l.SetCurrent(0)
 			}
 		}
+		if data.ColorTemp != nil {
+			l.targetLock.Lock()
+			l.ColorTemp = data.ColorTemp
+			l.targetLock.Unlock()
+		}
 		if data.Battery != nil {
 			l.setBatteryLevel(data.Battery)
 		}
@@ -22,9 +22,28 @@ $(document).ready(function() {
         $.get('/api/status', null, function(data, status, jqXHR) {
             const now = new Date()
             for (const name in data) {
+                $(`[data-device="${name}"]`).toggleClass('offline', data[name].availability === 'offline');
 
                 if (data[name].Type === 'plug') {
                     $("#value_" + name).text(data[name].value ? "on" : "off");
+                } else if (data[name].Type === 'IRControl') {
+                    const state = [];
+                    for (const key in data[name].state) {
+                        const value = data[name].state[key];
+                        state.push(key + ": " + (value === null ? "--" : value));
+                    }
+                    $("#value_" + name).text(state.join(", "));
+                } else if (data[name].Type === 'shell') {
+                    let text = data[name].running > 0 ? "running" : "";
+                    if (!text && data[name].lastCommand) {
+                        text = data[name].lastCommand + ": " + data[name].exitCode;
+                    }
+                    $("#value_" + name).text(text).attr("title", data[name].output || "");
+                } else if (data[name].Type === 'presence') {
+                    const home = Object.keys(data[name].people).filter(person => data[name].people[person]).sort();
+                    $("#value_" + name).text(home.length > 0 ? home.join(", ") : "nobody home");
+                } else if (data[name].display !== undefined) {
+                    $("#value_" + name).text(data[name].display);
                 } else if (data[name].Type === 'sensor') {
                     for (let key in data[name].Values) {
                         const prec = (key === "temperature" ? 10 : 1);
@@ -82,12 +101,12 @@ $(document).ready(function() {
     });
 });
 
-function switchDevice(device, control, value) {
+function switchDevice(device, control, value, duration = 1) {
     const data = {
         device: device,
         key: control,
         value: value.toString(),
-        duration: 1,
+        duration: duration,
     };
     $.post('/api/switch', JSON.stringify(data), null, 'json');
 }
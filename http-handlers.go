@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -68,11 +69,27 @@ func (s *Server) ShowStatus(devices *map[string]dimmyDevices.DeviceInterface) ht
 }
 
 type unknownDeviceView struct {
-	Name     string
-	Topic    string
-	Type     string
-	Sensors  []string
-	Controls []string
+	Name  string
+	Topic string
+	Type  string
+	Model string
+	// name the device announced for itself
+	Suggested string
+	Sensors   []string
+	Controls  []string
+}
+
+// controlLabel shows a control's range or choices next to its name
+func controlLabel(control core.Control) string {
+	switch {
+	case control.Min != nil && control.Max != nil:
+		return fmt.Sprintf("%s (%d–%d)", control.Name, *control.Min, *control.Max)
+	case control.Values != nil:
+		if values, ok := control.Values.([]string); ok && len(values) > 0 {
+			return fmt.Sprintf("%s (%s)", control.Name, strings.Join(values, ", "))
+		}
+	}
+	return control.Name
 }
 
 func (s *Server) ShowUnknownDevices(webroot string) http.HandlerFunc {
@@ -90,7 +107,14 @@ func (s *Server) ShowUnknownDevices(webroot string) http.HandlerFunc {
 					view.Sensors = append(view.Sensors, sensor.Name)
 				}
 				for _, control := range generic.GetControls() {
-					view.Controls = append(view.Controls, control.Name)
+					view.Controls = append(view.Controls, controlLabel(control))
+				}
+				if discovery := generic.GetDiscovery(); discovery != nil {
+					view.Model = strings.TrimSpace(discovery.Vendor + " " + discovery.Model)
+					if discovery.Description != "" {
+						view.Model += ": " + discovery.Description
+					}
+					view.Suggested = discovery.Name
 				}
 			}
 			devices = append(devices, view)
@@ -151,6 +175,9 @@ func (s *Server) SaveUnknownDevice() http.HandlerFunc {
 		var deviceConfig core.DeviceConfig
 		ok := true
 		switch deviceType {
+		case "", "device":
+			// keep what was discovered, with its sensors and receivers
+			device = bareDevice
 		case "generic-device":
 			device = dimmyDevices.NewDevice(bareDevice.GetConfig(name))
 		case "zlight":
@@ -159,6 +186,10 @@ func (s *Server) SaveUnknownDevice() http.HandlerFunc {
 			device = dimmyDevices.NewLight(bareDevice.GetConfig(name))
 		case "ir-control":
 			device = dimmyDevices.NewIrControl(bareDevice.GetConfig(name))
+		case "plug":
+			device = dimmyDevices.NewPlug(bareDevice.GetConfig(name))
+		default:
+			ok = false
 		}
 		if !ok {
 			s.mutex.Unlock()
@@ -183,10 +214,13 @@ func (s *Server) SaveUnknownDevice() http.HandlerFunc {
 		s.mutex.Unlock()
 
 		if mqttClient != nil && device.GetMqttStateTopic() != "" {
-			token := mqttClient.Subscribe(device.GetMqttStateTopic(), 0, device.GetMessageHandler(s.channel, device))
+			token := mqttClient.Subscribe(device.GetMqttStateTopic(), 0, s.stateHandler(device))
 			if token.Wait() && token.Error() != nil {
 				log.Printf("Could not subscribe saved device %s: %s", name, token.Error())
 			}
+			if topic := device.GetAvailabilityTopic(); topic != "" {
+				mqttClient.Subscribe(topic, 0, device.GetAvailabilityHandler())
+			}
 			device.PollValue(mqttClient)
 		}
 
@@ -441,3 +475,445 @@ func contains(values []string, value string) bool {
 	}
 	return false
 }
+
+type irLearnView struct {
+	Name     string
+	Label    string
+	Commands []string
+}
+
+func (s *Server) getIrControl(name string) (*dimmyDevices.IRControl, bool) {
+	device, ok := s.getDevice(name)
+	if !ok {
+		return nil, false
+	}
+	irControl, ok := device.(*dimmyDevices.IRControl)
+	return irControl, ok
+}
+
+func (s *Server) ShowIrLearning(webroot string) http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		devices := make([]irLearnView, 0)
+		for _, device := range s.deviceSnapshot() {
+			if irControl, ok := device.(*dimmyDevices.IRControl); ok {
+				devices = append(devices, irLearnView{Name: irControl.GetName(), Label: irControl.GetLabel(), Commands: irControl.GetCommands()})
+			}
+		}
+		sort.Slice(devices, func(i, j int) bool { return devices[i].Label < devices[j].Label })
+
+		templ, err := template.ParseFiles(webroot + "/ir-learn.html")
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := templ.Execute(output, struct {
+			Devices []irLearnView
+		}{devices}); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// fleetHealth lists all real devices, the ones needing attention first
+func (s *Server) fleetHealth() []dimmyDevices.Health {
+	health := make([]dimmyDevices.Health, 0)
+	for _, device := range s.deviceSnapshot() {
+		if !device.IsPseudoDevice() {
+			health = append(health, device.GetHealth())
+		}
+	}
+	sort.Slice(health, func(i, j int) bool {
+		if health[i].HasProblem() != health[j].HasProblem() {
+			return health[i].HasProblem()
+		}
+		return health[i].Label < health[j].Label
+	})
+	return health
+}
+
+func (s *Server) ShowHealthStatus() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		output.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(output).Encode(s.fleetHealth())
+	}
+}
+
+func (s *Server) ShowHealth(webroot string) http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		templ, err := template.ParseFiles(webroot + "/health.html")
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := templ.Execute(output, struct {
+			Devices []dimmyDevices.Health
+		}{s.fleetHealth()}); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// LearnIrCode starts learn mode on POST and reports the learned code on GET
+func (s *Server) LearnIrCode() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		var name string
+		switch request.Method {
+		case http.MethodGet:
+			name = request.URL.Query().Get("device")
+		case http.MethodPost:
+			var form struct {
+				Device string `json:"device"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(output, request.Body, 1<<16)).Decode(&form); err != nil {
+				http.Error(output, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			name = form.Device
+		default:
+			http.Error(output, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		irControl, ok := s.getIrControl(name)
+		if !ok {
+			http.Error(output, "IR device was not found", http.StatusNotFound)
+			return
+		}
+		if request.Method == http.MethodPost {
+			irControl.StartLearning()
+		}
+		code, learning := irControl.GetLearnedCode()
+		output.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(output).Encode(map[string]any{"device": name, "learning": learning, "code": code})
+	}
+}
+
+// SaveIrCommand stores a learned code as a named command of the device and in the config file
+func (s *Server) SaveIrCommand() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(output, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var form struct {
+			Device string `json:"device"`
+			Name   string `json:"name"`
+			Code   string `json:"code"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(output, request.Body, 1<<16)).Decode(&form); err != nil {
+			http.Error(output, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		form.Name = strings.TrimSpace(form.Name)
+		if form.Name == "" {
+			http.Error(output, "command name is required", http.StatusBadRequest)
+			return
+		}
+		irControl, ok := s.getIrControl(form.Device)
+		if !ok {
+			http.Error(output, "IR device was not found", http.StatusNotFound)
+			return
+		}
+		if form.Code == "" {
+			form.Code, _ = irControl.GetLearnedCode()
+		}
+		if form.Code == "" {
+			http.Error(output, "no IR code has been learned yet", http.StatusBadRequest)
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if err := core.SaveDeviceCommand(s.config.Filename, form.Device, form.Name, form.Code); err != nil {
+			log.Printf("Could not save command %s for %s: %s", form.Name, form.Device, err)
+			http.Error(output, "could not update config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		irControl.SaveCommand(form.Name, form.Code)
+
+		output.Header().Set("Content-Type", "application/json")
+		output.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(output).Encode(map[string]any{"device": form.Device, "name": form.Name})
+	}
+}
+
+// ReceiveWebhook sets trigger values of a webhook device from a JSON object or form values
+func (s *Server) ReceiveWebhook() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		device, ok := s.getDevice(request.PathValue("name"))
+		webhook, isWebhook := device.(*dimmyDevices.Webhook)
+		if !ok || !isWebhook {
+			http.Error(output, "webhook was not found", http.StatusNotFound)
+			return
+		}
+		if err := webhook.Authorize(request); err != nil {
+			log.Printf("[%32s] Rejected call from %s: %s\n", webhook.GetName(), request.RemoteAddr, err)
+			status := http.StatusUnauthorized
+			if errors.Is(err, dimmyDevices.ErrWebhookNoSecret) {
+				status = http.StatusForbidden
+			}
+			http.Error(output, err.Error(), status)
+			return
+		}
+		values, err := dimmyDevices.ReadWebhookPayload(request)
+		if err != nil {
+			http.Error(output, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := webhook.Receive(values); err != nil {
+			http.Error(output, err.Error(), http.StatusBadRequest)
+			return
+		}
+		output.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(output).Encode(map[string]any{"device": webhook.GetName(), "values": values})
+	}
+}
+
+// TestNotification sends a test message through one or all channels of a notify device
+func (s *Server) TestNotification() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		var form struct {
+			Device  string `json:"device"`
+			Channel string `json:"channel"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(output, request.Body, 1<<16)).Decode(&form); err != nil {
+			http.Error(output, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		device, ok := s.getDevice(form.Device)
+		notify, isNotify := device.(*dimmyDevices.Notify)
+		if !ok || !isNotify {
+			http.Error(output, "notify device was not found", http.StatusNotFound)
+			return
+		}
+		if err := notify.Test(form.Channel); err != nil {
+			http.Error(output, err.Error(), http.StatusBadGateway)
+			return
+		}
+		output.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(output).Encode(map[string]any{"device": form.Device, "channel": form.Channel, "sent": true})
+	}
+}
+
+func (s *Server) zigbeeStatus() []dimmyDevices.ZigbeeBridgeStatus {
+	s.mutex.RLock()
+	status := make([]dimmyDevices.ZigbeeBridgeStatus, 0, len(s.zigbee))
+	for _, bridge := range s.zigbee {
+		status = append(status, bridge.Status())
+	}
+	s.mutex.RUnlock()
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Base < status[j].Base
+	})
+	return status
+}
+
+func (s *Server) ShowZigbee(webroot string) http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		templ, err := template.ParseFiles(webroot + "/zigbee.html")
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := templ.Execute(output, struct {
+			Bridges []dimmyDevices.ZigbeeBridgeStatus
+		}{s.zigbeeStatus()}); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (s *Server) ShowZigbeeStatus() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		output.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(output).Encode(s.zigbeeStatus())
+	}
+}
+
+// ZigbeeRequest passes permit join, rename and firmware update requests on to a zigbee2mqtt bridge
+func (s *Server) ZigbeeRequest() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		var form struct {
+			Bridge  string `json:"bridge"`
+			Seconds int    `json:"seconds"`
+			Device  string `json:"device"`
+			Name    string `json:"name"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(output, request.Body, 1<<16)).Decode(&form); err != nil {
+			http.Error(output, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		bridge := s.getZigbeeBridge(form.Bridge, false)
+		if bridge == nil {
+			http.Error(output, "bridge was not found", http.StatusNotFound)
+			return
+		}
+		s.mutex.RLock()
+		client := s.mqttClient
+		s.mutex.RUnlock()
+
+		var err error
+		switch action := request.PathValue("action"); action {
+		case "permit-join":
+			err = bridge.PermitJoin(client, form.Seconds)
+		case "rename":
+			err = bridge.Rename(client, form.Device, strings.TrimSpace(form.Name))
+		case "update":
+			err = bridge.Update(client, form.Device)
+		default:
+			http.Error(output, "unknown action "+action, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusBadRequest)
+			return
+		}
+		output.Header().Set("Content-Type", "application/json")
+		output.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(output).Encode(map[string]any{"bridge": form.Bridge, "requested": true})
+	}
+}
+
+// parseHistoryTime accepts RFC 3339, unix seconds or a duration before now such as 24h or 7d
+func parseHistoryTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 1e9 {
+		return time.Unix(seconds, 0), nil
+	}
+	ago, err := core.ParseDuration(strings.TrimPrefix(value, "-"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s", value)
+	}
+	return time.Now().Add(-ago), nil
+}
+
+// ShowHistory returns the recorded values of a device's key, aggregated into steps
+func (s *Server) ShowHistory() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		now := time.Now()
+		from, err := parseHistoryTime(query.Get("from"), now.Add(-24*time.Hour))
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseHistoryTime(query.Get("to"), now)
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var step time.Duration
+		if query.Get("step") != "" {
+			if step, err = core.ParseDuration(query.Get("step")); err != nil || step < 0 {
+				http.Error(output, "invalid step "+query.Get("step"), http.StatusBadRequest)
+				return
+			}
+		}
+
+		device, key := request.PathValue("device"), request.PathValue("key")
+		points, err := s.history.Query(device, key, from, to, step)
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusNotFound)
+			return
+		}
+		output.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(output).Encode(map[string]any{"device": device, "key": key, "from": from, "to": to, "points": points})
+	}
+}
+
+// ShowHistoryKeys lists the keys recorded for a device
+func (s *Server) ShowHistoryKeys() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		output.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(output).Encode(s.history.Keys(request.PathValue("device")))
+	}
+}
+
+type historyChartView struct {
+	Key  string
+	Step bool
+}
+
+// ShowHistoryPage shows charts of all recorded keys of a device
+func (s *Server) ShowHistoryPage(webroot string) http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		device, ok := s.getDevice(request.PathValue("device"))
+		if !ok {
+			http.Error(output, "device was not found", http.StatusNotFound)
+			return
+		}
+		charts := make([]historyChartView, 0)
+		for _, key := range s.history.Keys(device.GetName()) {
+			charts = append(charts, historyChartView{Key: key, Step: dimmyDevices.StepHistory(device, key)})
+		}
+
+		templ, err := template.ParseFiles(webroot + "/history.html")
+		if err != nil {
+			http.Error(output, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := templ.Execute(output, struct {
+			Name   string
+			Label  string
+			Charts []historyChartView
+		}{device.GetName(), device.GetLabel(), charts}); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// ShowMetrics exposes device values and server counters in the Prometheus text format
+func (s *Server) ShowMetrics() http.HandlerFunc {
+	return func(output http.ResponseWriter, request *http.Request) {
+		var values, sensors, battery, linkQuality, online []core.MetricSample
+		for name, device := range s.deviceSnapshot() {
+			if device.IsPseudoDevice() {
+				continue
+			}
+			values = append(values, core.MetricSample{Labels: map[string]string{"device": name, "type": device.GetType()}, Value: device.GetCurrent()})
+			if d, ok := device.(interface {
+				GetSensors() []core.Sensor
+				GetValue(string) any
+			}); ok {
+				for _, sensor := range d.GetSensors() {
+					if value, ok := core.NumericValue(d.GetValue(sensor.Name)); ok {
+						sensors = append(sensors, core.MetricSample{Labels: map[string]string{"device": name, "sensor": sensor.Name}, Value: value})
+					}
+				}
+			}
+			health := device.GetHealth()
+			if health.Battery != nil {
+				battery = append(battery, core.MetricSample{Labels: map[string]string{"device": name}, Value: float64(*health.Battery)})
+			}
+			if health.LinkQuality != nil {
+				linkQuality = append(linkQuality, core.MetricSample{Labels: map[string]string{"device": name}, Value: float64(*health.LinkQuality)})
+			}
+			if health.Availability != "" {
+				up := 0.0
+				if health.Availability == dimmyDevices.AvailabilityOnline {
+					up = 1
+				}
+				online = append(online, core.MetricSample{Labels: map[string]string{"device": name}, Value: up})
+			}
+		}
+
+		output.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		core.WriteMetric(output, "dimmy_device_value", "gauge", "Current value of a device, e.g. the brightness of a light in percent.", values)
+		core.WriteMetric(output, "dimmy_sensor_value", "gauge", "Last numeric value of a sensor, booleans are 0 or 1.", sensors)
+		core.WriteMetric(output, "dimmy_device_battery_percent", "gauge", "Battery level reported by a device.", battery)
+		core.WriteMetric(output, "dimmy_device_linkquality", "gauge", "Zigbee link quality reported by a device.", linkQuality)
+		core.WriteMetric(output, "dimmy_device_online", "gauge", "Whether a device with availability tracking is online.", online)
+		s.metrics.rulesFired.Write(output)
+		s.metrics.mqttReceived.Write(output)
+		s.metrics.mqttPublished.Write(output)
+		s.metrics.requestsProcessed.Write(output)
+		s.metrics.requestsDropped.Write(output)
+		s.metrics.requestsFailed.Write(output)
+		s.metrics.cycleDuration.Write(output)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -24,9 +25,50 @@ type Server struct {
 	channel        chan core.SwitchRequest
 	config         *core.ServerConfig
 	mqttClient     mqtt.Client
+	savedState     []byte
+	history        *core.HistoryStore
+	metrics        *serverMetrics
+	homeAssistant  *dimmyDevices.HomeAssistant
+	mqttApi        *dimmyDevices.MqttApi
+	tasmota        map[string]*dimmyDevices.TasmotaDiscovery
+	zigbee         map[string]*dimmyDevices.ZigbeeBridge
+	historySaved   time.Time
 	mutex          sync.RWMutex
 }
 
+type serverMetrics struct {
+	rulesFired        *core.CounterVec
+	mqttReceived      *core.CounterVec
+	mqttPublished     *core.CounterVec
+	requestsProcessed *core.CounterVec
+	requestsDropped   *core.CounterVec
+	requestsFailed    *core.CounterVec
+	cycleDuration     *core.Histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		rulesFired:        core.NewCounterVec("dimmy_rules_fired_total", "Number of times a rule fired."),
+		mqttReceived:      core.NewCounterVec("dimmy_mqtt_messages_received_total", "MQTT messages received per topic of a configured device, other topics are counted as other.", "topic"),
+		mqttPublished:     core.NewCounterVec("dimmy_mqtt_messages_published_total", "MQTT messages published per topic.", "topic"),
+		requestsProcessed: core.NewCounterVec("dimmy_requests_processed_total", "Switch requests passed to a device.", "device"),
+		requestsDropped:   core.NewCounterVec("dimmy_requests_dropped_total", "Switch requests for devices that don't exist."),
+		requestsFailed:    core.NewCounterVec("dimmy_requests_failed_total", "Switch requests a device failed to process.", "device"),
+		cycleDuration:     core.NewHistogram("dimmy_event_loop_cycle_seconds", "Time spent updating devices and checking rules per event loop cycle.", 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1),
+	}
+}
+
+// countingClient counts the messages devices publish
+type countingClient struct {
+	mqtt.Client
+	published *core.CounterVec
+}
+
+func (c countingClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.published.Inc(topic)
+	return c.Client.Publish(topic, qos, retained, payload)
+}
+
 func main() {
 	version := flag.Bool("version", false, "Print version")
 	flag.Parse()
@@ -51,9 +93,12 @@ func main() {
 
 func (s *Server) initialize(config *core.ServerConfig) {
 	s.config = config
+	s.metrics = newServerMetrics()
 
 	s.devices = make(map[string]dimmyDevices.DeviceInterface)
 	s.unknownDevices = make(map[string]dimmyDevices.DeviceInterface)
+	s.tasmota = make(map[string]*dimmyDevices.TasmotaDiscovery)
+	s.zigbee = make(map[string]*dimmyDevices.ZigbeeBridge)
 
 	for _, deviceConfig := range config.Devices {
 		switch deviceConfig.Type {
@@ -72,24 +117,31 @@ func (s *Server) initialize(config *core.ServerConfig) {
 			s.devices[deviceConfig.Name] = dimmyDevices.NewIrControl(deviceConfig)
 		case "shell":
 			s.devices[deviceConfig.Name] = dimmyDevices.NewShell(deviceConfig)
+		case "presence":
+			s.devices[deviceConfig.Name] = dimmyDevices.NewPresence(deviceConfig)
+		case "derived":
+			s.devices[deviceConfig.Name] = dimmyDevices.NewDerived(deviceConfig)
+		case "webhook":
+			s.devices[deviceConfig.Name] = dimmyDevices.NewWebhook(deviceConfig)
+		case "notify":
+			s.devices[deviceConfig.Name] = dimmyDevices.NewNotify(deviceConfig)
+		case dimmyDevices.HelperBoolean, dimmyDevices.HelperNumber, dimmyDevices.HelperSelect, dimmyDevices.HelperCounter, dimmyDevices.HelperTimer:
+			s.devices[deviceConfig.Name] = dimmyDevices.NewHelper(deviceConfig)
 		case "group":
 		default:
 			log.Println("Skipping deviceConfig of unknown type '" + deviceConfig.Type + "'")
 		}
 	}
 
-	s.devices["time"] = dimmyDevices.NewDimmyTime(core.DeviceConfig{Name: "time", Type: "time"}, config.Lat, config.Lon)
-	s.devices["shell"] = dimmyDevices.NewShell(core.DeviceConfig{Name: "shell", Type: "shell"})
+	dimmyTime := dimmyDevices.NewDimmyTime(core.DeviceConfig{Name: "time", Type: "time"}, config.Lat, config.Lon)
+	s.devices["time"] = dimmyTime
+	if _, ok := s.devices["shell"]; !ok {
+		s.devices["shell"] = dimmyDevices.NewShell(core.DeviceConfig{Name: "shell", Type: "shell"})
+	}
 
 	// Parse Groups separately at the end, to make sure all referencing Devices exist at that point
-	for _, device := range config.Devices {
-		if device.Type == "group" {
-			group := dimmyDevices.NewGroup(device, s.devices)
-			if group != nil {
-				s.devices[device.Name] = group
-			}
-		}
-	}
+	dimmyDevices.NewGroups(config.Devices, s.devices)
+	dimmyDevices.EnableAdaptiveLighting(s.devices, dimmyTime)
 
 	for _, ruleConfig := range config.Rules {
 		rule := dimmyDevices.NewRule(ruleConfig, s.devices)
@@ -97,6 +149,28 @@ func (s *Server) initialize(config *core.ServerConfig) {
 			s.rules = append(s.rules, rule)
 		}
 	}
+	// derived devices are connected after the rules, so these see the initial values
+	dimmyDevices.ConnectDerivedDevices(s.devices)
+	s.restoreState()
+
+	s.history = core.NewHistoryStore()
+	if err := s.history.Load(config.HistoryFile); err != nil {
+		log.Printf("Could not load history file %s: %s\n", config.HistoryFile, err)
+	}
+	dimmyDevices.RecordHistory(s.devices, s.history)
+
+	if config.Export != nil && (config.Export.URL != "" || config.Export.File != "") {
+		exporter := core.NewLineExporter(*config.Export)
+		dimmyDevices.ExportValues(s.devices, exporter)
+		go exporter.Run(nil)
+	}
+
+	if config.MqttBaseTopic != nil && *config.MqttBaseTopic != "" {
+		s.mqttApi = dimmyDevices.NewMqttApi(*config.MqttBaseTopic, s.devices)
+	}
+	if config.HomeAssistant != nil {
+		s.homeAssistant = dimmyDevices.NewHomeAssistant(*config.HomeAssistant)
+	}
 
 	s.dashboards = make(map[string][]dimmyDevices.Panel)
 	s.dashboards["all"] = make([]dimmyDevices.Panel, len(config.Panels)+len(s.devices))
@@ -149,6 +223,20 @@ func (s *Server) Start(config *core.ServerConfig) {
 	http.Handle("/dashboard/all", s.ShowDashboard(config.WebRoot, "all"))
 	http.Handle("/devices/new-devices", s.ShowUnknownDevices(config.WebRoot))
 	http.Handle("/devices/new-devices/save", s.SaveUnknownDevice())
+	http.Handle("/devices/ir-learn", s.ShowIrLearning(config.WebRoot))
+	http.Handle("/devices/health", s.ShowHealth(config.WebRoot))
+	http.Handle("/api/health", s.ShowHealthStatus())
+	http.Handle("GET /devices/zigbee", s.ShowZigbee(config.WebRoot))
+	http.Handle("GET /api/zigbee", s.ShowZigbeeStatus())
+	http.Handle("POST /api/zigbee/{action}", s.ZigbeeRequest())
+	http.Handle("GET /metrics", s.ShowMetrics())
+	http.Handle("GET /devices/history/{device}", s.ShowHistoryPage(config.WebRoot))
+	http.Handle("GET /api/history/{device}", s.ShowHistoryKeys())
+	http.Handle("GET /api/history/{device}/{key}", s.ShowHistory())
+	http.Handle("/api/ir/learn", s.LearnIrCode())
+	http.Handle("/api/ir/commands", s.SaveIrCommand())
+	http.Handle("POST /api/webhooks/{name}", s.ReceiveWebhook())
+	http.Handle("POST /api/notify/test", s.TestNotification())
 	http.Handle("/rules/add-single-use", s.AddSingleUseRule(config.WebRoot))
 	http.Handle("/rules/edit", s.EditRules(config.WebRoot))
 	http.Handle("/api/rules", s.SaveRules())
@@ -160,7 +248,7 @@ func (s *Server) Start(config *core.ServerConfig) {
 
 func (s *Server) eventLoop(mqttServer string) {
 	hostname, _ := os.Hostname()
-	client := s.initMqtt(mqttServer, "goserver-"+hostname)
+	var client mqtt.Client = countingClient{Client: s.initMqtt(mqttServer, "goserver-"+hostname), published: s.metrics.mqttPublished}
 	s.mutex.Lock()
 	s.mqttClient = client
 	s.mutex.Unlock()
@@ -170,14 +258,34 @@ func (s *Server) eventLoop(mqttServer string) {
 	for name, device := range s.deviceSnapshot() {
 		if device.GetMqttStateTopic() != "" {
 			log.Printf("[%32s] Subscribing to %s\n", name, device.GetMqttStateTopic())
-			client.Subscribe(device.GetMqttStateTopic(), 0, device.GetMessageHandler(s.channel, device))
+			client.Subscribe(device.GetMqttStateTopic(), 0, s.stateHandler(device))
 			device.PollValue(client)
 		}
+		if topic := device.GetAvailabilityTopic(); topic != "" {
+			client.Subscribe(topic, 0, device.GetAvailabilityHandler())
+		}
+		if subscriber, ok := device.(dimmyDevices.TopicSubscriber); ok {
+			for topic, handler := range subscriber.GetSubscriptions() {
+				log.Printf("[%32s] Subscribing to %s\n", name, topic)
+				client.Subscribe(topic, 0, handler)
+			}
+		}
+	}
+	if s.mqttApi != nil {
+		for topic, handler := range s.mqttApi.Subscriptions(s.channel) {
+			log.Printf("Subscribing to %s for commands\n", topic)
+			client.Subscribe(topic, 0, handler)
+		}
+	}
+	if s.homeAssistant != nil {
+		s.announceToHomeAssistant(client)
 	}
 
 	for {
+		cycleStart := time.Now()
 
 		for _, device := range s.deviceSnapshot() {
+			device.CheckAvailability()
 			if _, ok := device.UpdateValue(); ok {
 				go device.PublishValue(client)
 			}
@@ -187,6 +295,7 @@ func (s *Server) eventLoop(mqttServer string) {
 		for idx, rule := range s.rules {
 			if rule.CheckTriggers() {
 				rule.Fire(s.channel)
+				s.metrics.rulesFired.Inc()
 				firedRules = append(firedRules, idx)
 			}
 		}
@@ -202,24 +311,134 @@ func (s *Server) eventLoop(mqttServer string) {
 			s.rules[idx].ClearTriggers()
 		}
 
+		if s.mqttApi != nil || s.homeAssistant != nil {
+			s.publishStates(client)
+		}
+
+		s.saveState()
+		s.saveHistory()
+		s.metrics.cycleDuration.Observe(time.Since(cycleStart).Seconds())
+
 		time.Sleep(core.CycleLength * time.Millisecond)
 	}
 }
 
+// stateHandler passes messages to the device's handler and keeps track of when it was last heard from
+func (s *Server) stateHandler(device dimmyDevices.DeviceInterface) mqtt.MessageHandler {
+	handler := device.GetMessageHandler(s.channel, device)
+	return func(client mqtt.Client, message mqtt.Message) {
+		device.MessageReceived(message.Retained())
+		handler(client, message)
+	}
+}
+
+// publishStates publishes the states of devices that changed on dimmy's own topics and to Home Assistant
+func (s *Server) publishStates(client mqtt.Client) {
+	snapshot := s.deviceSnapshot()
+	if s.mqttApi != nil {
+		s.mqttApi.PublishStates(client, snapshot)
+	}
+	if s.homeAssistant != nil {
+		s.homeAssistant.PublishStates(client, snapshot)
+	}
+}
+
+// announceToHomeAssistant publishes the discovery configs now and whenever Home Assistant restarts
+func (s *Server) announceToHomeAssistant(client mqtt.Client) {
+	for topic, handler := range s.homeAssistant.Subscriptions(s.channel, s.getDevice) {
+		log.Printf("[%32s] Subscribing to %s\n", "Home Assistant", topic)
+		client.Subscribe(topic, 0, handler)
+	}
+	client.Subscribe(s.homeAssistant.StatusTopic(), 0, func(_ mqtt.Client, message mqtt.Message) {
+		if string(message.Payload()) == "online" {
+			s.homeAssistant.Announce(client, s.deviceSnapshot())
+		}
+	})
+	s.homeAssistant.Announce(client, s.deviceSnapshot())
+}
+
+// restoreState loads the state of persistent devices saved before the last shutdown
+func (s *Server) restoreState() {
+	if s.config.StateFile == "" {
+		return
+	}
+	states, err := core.LoadState(s.config.StateFile)
+	if err != nil {
+		log.Printf("Could not load state file %s: %s\n", s.config.StateFile, err)
+		return
+	}
+	for name, state := range states {
+		device, ok := s.devices[name].(dimmyDevices.PersistentDevice)
+		if !ok {
+			continue
+		}
+		if err := device.RestoreState(state); err != nil {
+			log.Printf("[%32s] Could not restore state: %s\n", name, err)
+		}
+	}
+}
+
+// saveState writes the state of persistent devices whenever it changes
+func (s *Server) saveState() {
+	if s.config.StateFile == "" {
+		return
+	}
+	states := make(map[string]any)
+	for name, device := range s.deviceSnapshot() {
+		if d, ok := device.(dimmyDevices.PersistentDevice); ok {
+			states[name] = d.GetState()
+		}
+	}
+	if len(states) == 0 {
+		return
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil || bytes.Equal(data, s.savedState) {
+		return
+	}
+	if err := core.SaveState(s.config.StateFile, data); err != nil {
+		log.Printf("Could not save state file %s: %s\n", s.config.StateFile, err)
+		return
+	}
+	s.savedState = data
+}
+
+// saveHistory writes the history store once a minute
+func (s *Server) saveHistory() {
+	if s.config.HistoryFile == "" || time.Since(s.historySaved) < time.Minute {
+		return
+	}
+	s.historySaved = time.Now()
+	if err := s.history.Save(s.config.HistoryFile); err != nil {
+		log.Printf("Could not save history file %s: %s\n", s.config.HistoryFile, err)
+	}
+}
+
 func (s *Server) processRequests() {
 	for {
 		request := <-s.channel
 		for _, device := range strings.Split(request.Device, ",") {
 			target, ok := s.getDevice(device)
 			if ok {
-				target.ProcessRequest(request)
+				s.processRequest(target, request)
 			} else {
+				s.metrics.requestsDropped.Inc()
 				log.Printf("Can't find device for request [%s (%s)]", device, request.Device)
 			}
 		}
 	}
 }
 
+// processRequest passes a request to the device and counts whether it could be processed
+func (s *Server) processRequest(device dimmyDevices.DeviceInterface, request core.SwitchRequest) {
+	if err := device.ProcessRequest(request); err != nil {
+		s.metrics.requestsFailed.Inc(device.GetName())
+		log.Printf("[%32s] Failed to process request %v: %s\n", device.GetName(), request, err.Error())
+		return
+	}
+	s.metrics.requestsProcessed.Inc(device.GetName())
+}
+
 func (s *Server) deviceSnapshot() map[string]dimmyDevices.DeviceInterface {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -241,6 +460,9 @@ func (s *Server) initMqtt(hostname string, clientId string) mqtt.Client {
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(fmt.Sprintf("tcp://%s:1883", hostname))
 	opts.SetClientID(clientId)
+	if s.homeAssistant != nil {
+		opts.SetWill(s.homeAssistant.AvailabilityTopic(), "offline", 0, true)
+	}
 	client := mqtt.NewClient(opts)
 	token := client.Connect()
 	for !token.WaitTimeout(5 * time.Second) {
@@ -256,9 +478,39 @@ func (s *Server) DetectDevice() mqtt.MessageHandler {
 	log.Println("Subscribing to detect new devices")
 	// Parse incoming mqtt messages for new devices
 	return func(client mqtt.Client, mqttMessage mqtt.Message) {
+		// every message arrives here through the wildcard subscription
+		s.metrics.mqttReceived.Inc(s.topicLabel(mqttMessage.Topic()))
+		if discovery, ok := strings.CutPrefix(mqttMessage.Topic(), "tasmota/discovery/"); ok {
+			s.importTasmotaDevice(discovery, mqttMessage.Payload())
+			return
+		}
+		if base, subtopic, ok := strings.Cut(mqttMessage.Topic(), "/bridge/"); ok {
+			if bridge := s.getZigbeeBridge(base, isZigbee2MqttAnnouncement(subtopic, mqttMessage.Payload())); bridge != nil {
+				if err := bridge.HandleMessage("bridge/"+subtopic, mqttMessage.Payload()); err != nil {
+					log.Printf("[%32s] Could not parse %s: %s\n", base, mqttMessage.Topic(), err)
+				}
+				if subtopic == "devices" {
+					s.importZigbeeDevices(base, mqttMessage.Payload())
+				}
+				return
+			}
+		}
+		s.mutex.RLock()
+		for base, bridge := range s.zigbee {
+			if subtopic, ok := strings.CutPrefix(mqttMessage.Topic(), base+"/"); ok {
+				_ = bridge.HandleMessage(subtopic, mqttMessage.Payload())
+			}
+		}
+		s.mutex.RUnlock()
 		if IsMetaTopic(mqttMessage.Topic()) {
 			return
 		}
+		if s.homeAssistant != nil && s.homeAssistant.OwnsTopic(mqttMessage.Topic()) {
+			return
+		}
+		if s.mqttApi != nil && s.mqttApi.OwnsTopic(mqttMessage.Topic()) {
+			return
+		}
 		payload := mqttMessage.Payload()
 		var data map[string]any
 		err := json.Unmarshal(payload, &data)
@@ -268,9 +520,17 @@ func (s *Server) DetectDevice() mqtt.MessageHandler {
 		topic := dimmyDevices.LikelyDeviceTopic(mqttMessage.Topic())
 		s.mutex.Lock()
 		defer s.mutex.Unlock()
-		for _, d := range s.devices {
-			if d.GetMqttTopic() == topic || d.GetMqttStateTopic() == topic || dimmyDevices.LikelyDeviceTopic(d.GetMqttTopic()) == topic {
-				// We already know this device. Nothing to do.
+		if s.isKnownTopic(topic) {
+			return
+		}
+		for _, device := range s.devices {
+			if subscriber, ok := device.(dimmyDevices.TopicSubscriber); ok && subscriber.OwnsTopic(mqttMessage.Topic()) {
+				return
+			}
+		}
+		for _, discovery := range s.tasmota {
+			if discovery.OwnsTopic(topic) {
+				// described by its discovery messages already
 				return
 			}
 		}
@@ -291,9 +551,171 @@ func (s *Server) DetectDevice() mqtt.MessageHandler {
 	}
 }
 
+// topicLabel returns the topic if a configured device uses it and "other" otherwise, so the metric doesn't
+// grow with every topic on the broker
+func (s *Server) topicLabel(topic string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.isKnownTopic(topic) {
+		return topic
+	}
+	return "other"
+}
+
+// isKnownTopic tells whether a configured device uses topic. The caller must hold the mutex.
+func (s *Server) isKnownTopic(topic string) bool {
+	for _, d := range s.devices {
+		if d.GetMqttTopic() == topic || d.GetMqttStateTopic() == topic || dimmyDevices.LikelyDeviceTopic(d.GetMqttTopic()) == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// importZigbeeDevices offers the devices zigbee2mqtt lists on bridge/devices that aren't configured yet,
+// replacing those guessed from their messages
+func (s *Server) importZigbeeDevices(base string, payload []byte) {
+	var bridgeDevices []core.Zigbee2MqttBridgeDevice
+	if err := json.Unmarshal(payload, &bridgeDevices); err != nil {
+		log.Printf("Could not parse device list of %s: %s\n", base, err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, bridgeDevice := range bridgeDevices {
+		if bridgeDevice.Type == "Coordinator" || bridgeDevice.Disabled || bridgeDevice.Definition == nil {
+			continue
+		}
+		topic := base + "/" + bridgeDevice.FriendlyName
+		if s.isKnownTopic(topic) {
+			continue
+		}
+		s.unknownDevices[topic] = dimmyDevices.NewDeviceFromDefinition(topic, bridgeDevice)
+	}
+}
+
+// isZigbee2MqttAnnouncement tells whether a message to <base>/bridge/<subtopic> was published by zigbee2mqtt,
+// so an unrelated topic that happens to contain "bridge" isn't taken for a zigbee2mqtt bridge
+func isZigbee2MqttAnnouncement(subtopic string, payload []byte) bool {
+	switch subtopic {
+	case "info":
+		var info core.Zigbee2MqttBridgeInfo
+		return json.Unmarshal(payload, &info) == nil && info.Version != ""
+	case "devices":
+		var devices []core.Zigbee2MqttBridgeDevice
+		if json.Unmarshal(payload, &devices) != nil {
+			return false
+		}
+		for _, device := range devices {
+			if device.Type == "Coordinator" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getZigbeeBridge returns the zigbee2mqtt bridge publishing below base, creating it if create is set
+func (s *Server) getZigbeeBridge(base string, create bool) *dimmyDevices.ZigbeeBridge {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	bridge, ok := s.zigbee[base]
+	if !ok && create {
+		log.Printf("[%32s] Found zigbee2mqtt bridge\n", base)
+		bridge = dimmyDevices.NewZigbeeBridge(base, s.renameZigbeeDevice)
+		s.zigbee[base] = bridge
+		// bridge messages that arrived before the bridge was recognised were guessed as devices
+		for topic := range s.unknownDevices {
+			if strings.HasPrefix(topic, base+"/bridge") {
+				delete(s.unknownDevices, topic)
+			}
+		}
+	}
+	return bridge
+}
+
+// renameZigbeeDevice moves configured devices to the new topic after zigbee2mqtt renamed a device, both
+// while running and in the config file
+func (s *Server) renameZigbeeDevice(from string, to string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.unknownDevices, from)
+	for name, device := range s.devices {
+		stateTopic, availability := device.GetMqttStateTopic(), device.GetAvailabilityTopic()
+		if !device.RenameTopic(from, to) {
+			continue
+		}
+		log.Printf("[%32s] Moved from %s to %s\n", name, from, to)
+		if s.mqttClient == nil {
+			continue
+		}
+		if stateTopic != device.GetMqttStateTopic() {
+			s.mqttClient.Unsubscribe(stateTopic)
+			log.Printf("[%32s] Subscribing to %s\n", name, device.GetMqttStateTopic())
+			s.mqttClient.Subscribe(device.GetMqttStateTopic(), 0, s.stateHandler(device))
+		}
+		if availability != device.GetAvailabilityTopic() {
+			s.mqttClient.Unsubscribe(availability)
+			s.mqttClient.Subscribe(device.GetAvailabilityTopic(), 0, device.GetAvailabilityHandler())
+		}
+	}
+	rename := func(topic string) string {
+		if topic == from || strings.HasPrefix(topic, from+"/") {
+			return to + strings.TrimPrefix(topic, from)
+		}
+		return topic
+	}
+	for i, device := range s.config.Devices {
+		s.config.Devices[i].Topic = rename(device.Topic)
+		if device.Options != nil && device.Options.AvailabilityTopic != nil {
+			device.Options.AvailabilityTopic = core.ToPtr(rename(*device.Options.AvailabilityTopic))
+		}
+	}
+	changed, err := core.RenameDeviceTopic(s.config.Filename, from, to)
+	if err != nil {
+		log.Printf("Could not update config after renaming %s to %s: %s\n", from, to, err)
+		return
+	}
+	if changed > 0 {
+		log.Printf("Renamed %s to %s in %d config topics\n", from, to, changed)
+	}
+}
+
+// importTasmotaDevice offers what a Tasmota device announces on tasmota/discovery/<mac>/config and sensors,
+// replacing the devices guessed from its messages
+func (s *Server) importTasmotaDevice(topic string, payload []byte) {
+	mac, kind, _ := strings.Cut(topic, "/")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	discovery, ok := s.tasmota[mac]
+	if !ok {
+		discovery = &dimmyDevices.TasmotaDiscovery{}
+		s.tasmota[mac] = discovery
+	}
+	if err := discovery.Update(kind, payload); err != nil {
+		log.Printf("Could not parse Tasmota discovery message of %s: %s\n", mac, err)
+		return
+	}
+	if discovery.Config == nil {
+		return
+	}
+	for topic := range s.unknownDevices {
+		if discovery.OwnsTopic(topic) {
+			delete(s.unknownDevices, topic)
+		}
+	}
+	for _, device := range discovery.Devices() {
+		if s.isKnownTopic(device.GetMqttTopic()) {
+			continue
+		}
+		s.unknownDevices[device.GetMqttTopic()] = device
+	}
+}
+
 func IsMetaTopic(topic string) bool {
 	r := strings.Split(topic, "/")
-	if len(r) > 1 && (r[1] == "bridge" || r[1] == "901" || r[1] == "Coordinator") {
+	// bridge topics of zigbee2mqtt are handled by their ZigbeeBridge before this is checked
+	if len(r) > 1 && (r[1] == "901" || r[1] == "Coordinator") {
 		return true
 	}
 	return false
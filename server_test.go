@@ -11,6 +11,7 @@ import (
 
 	"github.com/PhilGruber/dimmy/core"
 	dimmyDevices "github.com/PhilGruber/dimmy/devices"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
 )
@@ -52,3 +53,109 @@ func TestSaveUnknownDevice(t *testing.T) {
 	require.Equal(t, topic, config.Devices[0].Topic)
 	require.Len(t, *config.Devices[0].Options.Sensors, 2)
 }
+
+func TestProcessRequest_CountsFailures(t *testing.T) {
+	helper := dimmyDevices.NewHelper(core.DeviceConfig{Name: "mode", Type: "input_select", Options: &core.ConfigOptions{Helper: &core.HelperConfig{Values: []string{"home", "away"}}}})
+	lamp := dimmyDevices.NewDeviceFromMessage("zigbee/lamp", map[string]any{"state": "ON"})
+	server := &Server{
+		devices: map[string]dimmyDevices.DeviceInterface{"mode": helper, "lamp": lamp},
+		metrics: newServerMetrics(),
+	}
+
+	server.processRequest(helper, core.SwitchRequest{Device: "mode", Value: "away"})
+	server.processRequest(helper, core.SwitchRequest{Device: "mode", Value: "party"})
+	server.processRequest(lamp, core.SwitchRequest{Device: "lamp", Key: "state"})
+	require.Equal(t, 1.0, server.metrics.requestsProcessed.Get("mode"))
+	require.Equal(t, 1.0, server.metrics.requestsFailed.Get("mode"))
+	require.Equal(t, 1.0, server.metrics.requestsProcessed.Get(lamp.GetName()), "empty values don't crash the server")
+
+	require.Equal(t, "zigbee/lamp", server.topicLabel("zigbee/lamp"))
+	require.Equal(t, "other", server.topicLabel("owntracks/alice/phone"))
+}
+
+// subscribingClient records subscriptions. Methods that are not overridden panic when called.
+type subscribingClient struct {
+	mqtt.Client
+	subscribed   []string
+	unsubscribed []string
+}
+
+func (c *subscribingClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.subscribed = append(c.subscribed, topic)
+	return &mqtt.DummyToken{}
+}
+
+func (c *subscribingClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.unsubscribed = append(c.unsubscribed, topics...)
+	return &mqtt.DummyToken{}
+}
+
+func TestRenameZigbeeDevice(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dimmyd.conf.yaml")
+	config := "mqtt_server: localhost\ndevices:\n- name: Kitchen\n  type: zlight\n  topic: zigbee2mqtt/Kitchen Bulb\n"
+	require.NoError(t, os.WriteFile(filename, []byte(config), 0o600))
+
+	deviceConfig := core.DeviceConfig{Name: "Kitchen", Type: "zlight", Topic: "zigbee2mqtt/Kitchen Bulb"}
+	light := dimmyDevices.NewZLight(deviceConfig)
+	client := &subscribingClient{}
+	server := &Server{
+		devices:        map[string]dimmyDevices.DeviceInterface{"Kitchen": light},
+		unknownDevices: make(map[string]dimmyDevices.DeviceInterface),
+		config:         &core.ServerConfig{Filename: filename, Devices: []core.DeviceConfig{deviceConfig}},
+		mqttClient:     client,
+	}
+
+	server.renameZigbeeDevice("zigbee2mqtt/Kitchen Bulb", "zigbee2mqtt/Pantry Bulb")
+	require.Equal(t, "zigbee2mqtt/Pantry Bulb", server.config.Devices[0].Topic)
+	require.Equal(t, "zigbee2mqtt/Pantry Bulb", light.GetMqttTopic())
+	require.Equal(t, "zigbee2mqtt/Pantry Bulb", light.GetMqttStateTopic())
+	require.Equal(t, "zigbee2mqtt/Pantry Bulb/availability", light.GetAvailabilityTopic())
+	require.Equal(t, []string{"zigbee2mqtt/Kitchen Bulb", "zigbee2mqtt/Kitchen Bulb/availability"}, client.unsubscribed)
+	require.Equal(t, []string{"zigbee2mqtt/Pantry Bulb", "zigbee2mqtt/Pantry Bulb/availability"}, client.subscribed)
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "topic: zigbee2mqtt/Pantry Bulb")
+
+	// the renamed device is configured, so the bridge's device list doesn't offer it again
+	server.importZigbeeDevices("zigbee2mqtt", []byte(`[{"ieee_address": "0x0017880104", "type": "Router", "friendly_name": "Pantry Bulb",
+		"definition": {"model": "8718699673147", "vendor": "Philips", "exposes": []}}]`))
+	require.Empty(t, server.unknownDevices)
+}
+
+type testMessage struct {
+	mqtt.Message
+	topic   string
+	payload string
+}
+
+func (m testMessage) Topic() string   { return m.topic }
+func (m testMessage) Payload() []byte { return []byte(m.payload) }
+func (m testMessage) Retained() bool  { return false }
+
+func TestDetectDevice_BridgeTopics(t *testing.T) {
+	server := &Server{
+		devices:        make(map[string]dimmyDevices.DeviceInterface),
+		unknownDevices: make(map[string]dimmyDevices.DeviceInterface),
+		tasmota:        make(map[string]*dimmyDevices.TasmotaDiscovery),
+		zigbee:         make(map[string]*dimmyDevices.ZigbeeBridge),
+		metrics:        newServerMetrics(),
+	}
+	detect := server.DetectDevice()
+
+	// not published by zigbee2mqtt, so it is a device like any other
+	detect(nil, testMessage{topic: "home/bridge/temp", payload: `{"temperature": 21.5}`})
+	require.Empty(t, server.zigbee)
+	require.Len(t, server.unknownDevices, 1)
+
+	detect(nil, testMessage{topic: "zigbee2mqtt/bridge/state", payload: `{"state": "online"}`})
+	detect(nil, testMessage{topic: "zigbee2mqtt/bridge/info", payload: `{"version": "2.1.0", "permit_join": false}`})
+	require.Contains(t, server.zigbee, "zigbee2mqtt")
+	for topic := range server.unknownDevices {
+		require.NotContains(t, topic, "zigbee2mqtt/bridge")
+	}
+
+	detect(nil, testMessage{topic: "zigbee2mqtt/bridge/logging", payload: `{"level": "info", "message": "hello"}`})
+	require.Equal(t, "hello", server.zigbee["zigbee2mqtt"].Status().Logs[0].Message)
+	require.Len(t, server.unknownDevices, 1)
+}